@@ -0,0 +1,24 @@
+--
+-- Create table for the university organizational unit hierarchy
+-- (school -> department -> office) and link users and accessions to units
+--
+DROP TABLE IF EXISTS org_units;
+CREATE TABLE org_units (
+   id int(11) NOT NULL AUTO_INCREMENT PRIMARY KEY,
+   code varchar(50) NOT NULL,
+   name varchar(255) NOT NULL,
+   unit_type varchar(20) NOT NULL,
+   parent_id int(11) DEFAULT NULL,
+   UNIQUE KEY (code),
+   FOREIGN KEY (parent_id) REFERENCES org_units(id) ON DELETE SET NULL
+) ENGINE=InnoDB DEFAULT CHARSET=utf8;
+
+ALTER TABLE users
+   ADD COLUMN unit_id int(11) DEFAULT NULL,
+   ADD FOREIGN KEY (unit_id) REFERENCES org_units(id) ON DELETE SET NULL;
+
+ALTER TABLE accessions
+   ADD COLUMN unit_id int(11) DEFAULT NULL,
+   ADD FOREIGN KEY (unit_id) REFERENCES org_units(id) ON DELETE SET NULL;
+
+insert into versions(version, created_at) values ("v10", NOW());
@@ -0,0 +1,8 @@
+--
+-- Record the owner / creator of the records for submissions made on their behalf
+--
+ALTER TABLE accessions
+   ADD COLUMN owner_id int(11) DEFAULT NULL,
+   ADD FOREIGN KEY (owner_id) REFERENCES users(id) ON DELETE SET NULL;
+
+insert into versions(version, created_at) values ("v11", NOW());
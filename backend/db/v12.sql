@@ -0,0 +1,38 @@
+--
+-- Create tables for the accession activity history and for supplemental documents
+-- that staff attach to an accession
+--
+DROP TABLE IF EXISTS accession_events;
+CREATE TABLE accession_events (
+   id int(11) NOT NULL AUTO_INCREMENT PRIMARY KEY,
+   accession_id int(11) NOT NULL,
+   user_id int(11) DEFAULT NULL,
+   event_type varchar(30) NOT NULL,
+   description varchar(255) NOT NULL default "",
+   created_at datetime NOT NULL,
+   INDEX (accession_id, created_at),
+   FOREIGN KEY (accession_id) REFERENCES accessions(id) ON DELETE CASCADE,
+   FOREIGN KEY (user_id) REFERENCES users(id) ON DELETE SET NULL
+) ENGINE=InnoDB DEFAULT CHARSET=utf8;
+
+DROP TABLE IF EXISTS accession_attachments;
+CREATE TABLE accession_attachments (
+   id int(11) NOT NULL AUTO_INCREMENT PRIMARY KEY,
+   accession_id int(11) NOT NULL,
+   title varchar(255) NOT NULL,
+   attachment_type varchar(30) NOT NULL,
+   filename varchar(255) NOT NULL,
+   content_type varchar(100) NOT NULL,
+   size bigint NOT NULL default 0,
+   uploaded_by int(11) NOT NULL,
+   created_at datetime NOT NULL,
+   FOREIGN KEY (accession_id) REFERENCES accessions(id) ON DELETE CASCADE,
+   FOREIGN KEY (uploaded_by) REFERENCES users(id)
+) ENGINE=InnoDB DEFAULT CHARSET=utf8;
+
+-- Start the history of existing accessions with their submission
+insert into accession_events (accession_id, event_type, description, created_at)
+   select a.id, "submitted", concat("Submitted by ", u.first_name, " ", u.last_name), a.created_at
+   from accessions a inner join users u on u.id = a.user_id;
+
+insert into versions(version, created_at) values ("v12", NOW());
@@ -0,0 +1,22 @@
+--
+-- Create table for messages between archivists and the submitter of an accession.
+-- The message token is the secret in the submitter's link to the conversation
+--
+DROP TABLE IF EXISTS accession_messages;
+CREATE TABLE accession_messages (
+   id int(11) NOT NULL AUTO_INCREMENT PRIMARY KEY,
+   accession_id int(11) NOT NULL,
+   user_id int(11) NOT NULL,
+   from_staff tinyint(1) NOT NULL default 0,
+   body text NOT NULL,
+   created_at datetime NOT NULL,
+   read_at datetime DEFAULT NULL,
+   notified_at datetime DEFAULT NULL,
+   INDEX (accession_id, created_at),
+   FOREIGN KEY (accession_id) REFERENCES accessions(id) ON DELETE CASCADE,
+   FOREIGN KEY (user_id) REFERENCES users(id)
+) ENGINE=InnoDB DEFAULT CHARSET=utf8;
+
+ALTER TABLE accessions ADD COLUMN message_token varchar(64) DEFAULT NULL UNIQUE;
+
+insert into versions(version, created_at) values ("v13", NOW());
@@ -0,0 +1,40 @@
+--
+-- Add the processing status of accessions with its history, and the assignment of
+-- accessions to archivists. Ended assignments are kept with unassigned_at set
+--
+ALTER TABLE accessions ADD COLUMN status varchar(20) NOT NULL default "submitted";
+CREATE INDEX accessions_status ON accessions (status);
+
+DROP TABLE IF EXISTS accession_status_history;
+CREATE TABLE accession_status_history (
+   id int(11) NOT NULL AUTO_INCREMENT PRIMARY KEY,
+   accession_id int(11) NOT NULL,
+   status varchar(20) NOT NULL,
+   user_id int(11) DEFAULT NULL,
+   created_at datetime NOT NULL,
+   INDEX (accession_id, created_at),
+   FOREIGN KEY (accession_id) REFERENCES accessions(id) ON DELETE CASCADE,
+   FOREIGN KEY (user_id) REFERENCES users(id)
+) ENGINE=InnoDB DEFAULT CHARSET=utf8;
+
+-- existing accessions start their history at submission
+insert into accession_status_history (accession_id, status, created_at)
+   select id, "submitted", created_at from accessions;
+
+DROP TABLE IF EXISTS accession_assignments;
+CREATE TABLE accession_assignments (
+   id int(11) NOT NULL AUTO_INCREMENT PRIMARY KEY,
+   accession_id int(11) NOT NULL,
+   user_id int(11) NOT NULL,
+   assigned_by int(11) DEFAULT NULL,
+   assigned_at datetime NOT NULL,
+   unassigned_at datetime DEFAULT NULL,
+   notified_at datetime DEFAULT NULL,
+   INDEX (accession_id),
+   INDEX (user_id, unassigned_at),
+   FOREIGN KEY (accession_id) REFERENCES accessions(id) ON DELETE CASCADE,
+   FOREIGN KEY (user_id) REFERENCES users(id),
+   FOREIGN KEY (assigned_by) REFERENCES users(id)
+) ENGINE=InnoDB DEFAULT CHARSET=utf8;
+
+insert into versions(version, created_at) values ("v14", NOW());
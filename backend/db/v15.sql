@@ -0,0 +1,23 @@
+--
+-- Create the SLA rules for processing deadlines and add the current deadline to
+-- accessions. Deadlines of existing accessions are calculated when the rules are saved
+--
+DROP TABLE IF EXISTS sla_rules;
+CREATE TABLE sla_rules (
+   id int(11) NOT NULL AUTO_INCREMENT PRIMARY KEY,
+   status varchar(20) NOT NULL,
+   transfer_type varchar(20) NOT NULL default "any",
+   days int(11) NOT NULL,
+   warn_days int(11) NOT NULL default 0,
+   description varchar(255) NOT NULL default ""
+) ENGINE=InnoDB DEFAULT CHARSET=utf8;
+
+insert into sla_rules(status, transfer_type, days, warn_days, description) values
+   ("acknowledged", "any", 3, 1, "Acknowledge transfers within 3 days"),
+   ("received", "physical", 30, 5, "Receive physical boxes within 30 days");
+
+ALTER TABLE accessions ADD COLUMN due_at datetime DEFAULT NULL;
+ALTER TABLE accessions ADD COLUMN due_status varchar(20) NOT NULL default "";
+CREATE INDEX accessions_due_at ON accessions (due_at);
+
+insert into versions(version, created_at) values ("v15", NOW());
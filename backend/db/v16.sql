@@ -0,0 +1,17 @@
+--
+-- Create the full text search index of accessions. There is one row per searchable
+-- field of an accession, rebuilt by the index job when the accession changes.
+-- Existing accessions are queued for indexing when the service starts
+--
+DROP TABLE IF EXISTS accession_search;
+CREATE TABLE accession_search (
+   id int(11) NOT NULL AUTO_INCREMENT PRIMARY KEY,
+   accession_id int(11) NOT NULL,
+   field varchar(30) NOT NULL,
+   content mediumtext NOT NULL,
+   INDEX (accession_id),
+   FULLTEXT KEY accession_search_content (content),
+   FOREIGN KEY (accession_id) REFERENCES accessions(id) ON DELETE CASCADE
+) ENGINE=InnoDB DEFAULT CHARSET=utf8;
+
+insert into versions(version, created_at) values ("v16", NOW());
@@ -0,0 +1,30 @@
+--
+-- Create the saved searches of the admin accession list. The query is the URL query
+-- string of the list filters and sort. Matches record the accessions an owner has
+-- already been notified about, so each is only sent once
+--
+DROP TABLE IF EXISTS saved_search_matches;
+DROP TABLE IF EXISTS saved_searches;
+CREATE TABLE saved_searches (
+   id int(11) NOT NULL AUTO_INCREMENT PRIMARY KEY,
+   user_id int(11) NOT NULL,
+   name varchar(100) NOT NULL,
+   query text NOT NULL,
+   shared tinyint(1) NOT NULL default 0,
+   notify tinyint(1) NOT NULL default 0,
+   created_at datetime NOT NULL,
+   updated_at datetime NOT NULL,
+   FOREIGN KEY (user_id) REFERENCES users(id) ON DELETE CASCADE
+) ENGINE=InnoDB DEFAULT CHARSET=utf8;
+
+CREATE TABLE saved_search_matches (
+   id int(11) NOT NULL AUTO_INCREMENT PRIMARY KEY,
+   saved_search_id int(11) NOT NULL,
+   accession_id int(11) NOT NULL,
+   created_at datetime NOT NULL,
+   UNIQUE KEY (saved_search_id, accession_id),
+   FOREIGN KEY (saved_search_id) REFERENCES saved_searches(id) ON DELETE CASCADE,
+   FOREIGN KEY (accession_id) REFERENCES accessions(id) ON DELETE CASCADE
+) ENGINE=InnoDB DEFAULT CHARSET=utf8;
+
+insert into versions(version, created_at) values ("v17", NOW());
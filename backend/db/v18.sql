@@ -0,0 +1,9 @@
+--
+-- Add the random token used in the submitter's receipt and box label download links.
+-- The identifier can't be used since it is printed on the box labels
+--
+ALTER TABLE accessions ADD COLUMN access_token varchar(48) NOT NULL DEFAULT "";
+UPDATE accessions SET access_token = hex(random_bytes(24));
+ALTER TABLE accessions ADD UNIQUE KEY accession_access_token (access_token);
+
+insert into versions(version, created_at) values ("v18", NOW());
@@ -0,0 +1,7 @@
+--
+-- Record when the overdue reminder for the current deadline of an accession was sent,
+-- so recalculating deadlines does not send it again
+--
+ALTER TABLE accessions ADD COLUMN reminded_at datetime DEFAULT NULL;
+
+insert into versions(version, created_at) values ("v19", NOW());
@@ -0,0 +1,6 @@
+--
+-- Track a fingerprint of the submitted data so retried submissions can be detected
+--
+ALTER TABLE accessions ADD COLUMN request_hash varchar(64) NOT NULL DEFAULT "";
+
+insert into versions(version, created_at) values ("v2", NOW());
@@ -0,0 +1,6 @@
+--
+-- Widen the digital transfer size so transfers over 2 GB are not truncated
+--
+ALTER TABLE digital_accessions MODIFY upload_size bigint;
+
+insert into versions(version, created_at) values ("v20", NOW());
@@ -0,0 +1,10 @@
+--
+-- Give submitter sessions a token of their own so they can never be used as an admin
+-- session, and clear the verify tokens of verified users so verification links can not
+-- be used to sign in again
+--
+ALTER TABLE users ADD COLUMN submit_token varchar(25) NOT NULL DEFAULT "";
+ALTER TABLE users ADD INDEX (submit_token);
+UPDATE users SET verify_token=NULL WHERE verified=1;
+
+insert into versions(version, created_at) values ("v21", NOW());
@@ -0,0 +1,6 @@
+--
+-- The accession type sort of the accession list is now named accessionType
+--
+UPDATE saved_searches SET query=REPLACE(query, 'sort=accession_type', 'sort=accessionType');
+
+insert into versions(version, created_at) values ("v22", NOW());
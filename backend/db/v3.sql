@@ -0,0 +1,27 @@
+--
+-- Create table for post-submit processing jobs
+--
+DROP TABLE IF EXISTS jobs;
+CREATE TABLE jobs (
+   id int(11) NOT NULL AUTO_INCREMENT PRIMARY KEY,
+   accession_id int(11) NOT NULL,
+   job_type varchar(25) NOT NULL,
+   status varchar(15) NOT NULL DEFAULT "pending",
+   attempts int(11) NOT NULL DEFAULT 0,
+   max_attempts int(11) NOT NULL DEFAULT 5,
+   last_error varchar(1024) NOT NULL DEFAULT "",
+   claim_token varchar(25) NOT NULL DEFAULT "",
+   run_at datetime NOT NULL,
+   created_at datetime NOT NULL,
+   updated_at datetime NOT NULL,
+   INDEX (status, run_at),
+   INDEX (claim_token),
+   FOREIGN KEY (accession_id) REFERENCES accessions(id) ON DELETE CASCADE
+) ENGINE=InnoDB DEFAULT CHARSET=utf8;
+
+--
+-- Track checksums of transferred digital files
+--
+ALTER TABLE digital_files ADD COLUMN checksum varchar(64) NOT NULL DEFAULT "";
+
+insert into versions(version, created_at) values ("v3", NOW());
@@ -0,0 +1,18 @@
+--
+-- Track receipt of physical transfers
+--
+ALTER TABLE physical_accessions ADD COLUMN received_at datetime DEFAULT NULL;
+ALTER TABLE physical_accessions ADD COLUMN received_by int(11) DEFAULT NULL;
+ALTER TABLE physical_accessions ADD COLUMN receipt_closed_at datetime DEFAULT NULL;
+ALTER TABLE physical_accessions ADD FOREIGN KEY (received_by) REFERENCES users(id) ON DELETE SET NULL;
+
+--
+-- Track the receiving status of each inventory box: expected, received, missing, damaged or extra
+--
+ALTER TABLE inventory_items ADD COLUMN status varchar(15) NOT NULL DEFAULT "expected";
+ALTER TABLE inventory_items ADD COLUMN note varchar(255) NOT NULL DEFAULT "";
+ALTER TABLE inventory_items ADD COLUMN checked_at datetime DEFAULT NULL;
+ALTER TABLE inventory_items ADD COLUMN checked_by int(11) DEFAULT NULL;
+ALTER TABLE inventory_items ADD FOREIGN KEY (checked_by) REFERENCES users(id) ON DELETE SET NULL;
+
+insert into versions(version, created_at) values ("v4", NOW());
@@ -0,0 +1,17 @@
+--
+-- Create table to track the yearly sequence of human readable accession numbers
+--
+DROP TABLE IF EXISTS accession_sequences;
+CREATE TABLE accession_sequences (
+   year int(11) NOT NULL PRIMARY KEY,
+   last_number int(11) NOT NULL DEFAULT 0
+) ENGINE=InnoDB DEFAULT CHARSET=utf8;
+
+--
+-- Add accession numbers. Existing accessions are numbered in submission order with the
+-- configured accession number pattern when the service starts
+--
+ALTER TABLE accessions ADD COLUMN accession_number varchar(25) DEFAULT NULL AFTER identifier;
+ALTER TABLE accessions ADD UNIQUE INDEX (accession_number);
+
+insert into versions(version, created_at) values ("v5", NOW());
@@ -0,0 +1,20 @@
+--
+-- Create table for typed links between accessions. The accession is an accrual to,
+-- supersedes or is related to the related accession
+--
+DROP TABLE IF EXISTS accession_links;
+CREATE TABLE accession_links (
+   id int(11) NOT NULL AUTO_INCREMENT PRIMARY KEY,
+   accession_id int(11) NOT NULL,
+   related_accession_id int(11) NOT NULL,
+   link_type varchar(20) NOT NULL,
+   created_by int(11) DEFAULT NULL,
+   created_at datetime NOT NULL,
+   UNIQUE KEY (accession_id, related_accession_id, link_type),
+   INDEX (related_accession_id, link_type),
+   FOREIGN KEY (accession_id) REFERENCES accessions(id) ON DELETE CASCADE,
+   FOREIGN KEY (related_accession_id) REFERENCES accessions(id) ON DELETE CASCADE,
+   FOREIGN KEY (created_by) REFERENCES users(id) ON DELETE SET NULL
+) ENGINE=InnoDB DEFAULT CHARSET=utf8;
+
+insert into versions(version, created_at) values ("v6", NOW());
@@ -0,0 +1,36 @@
+--
+-- Create tables for versioned transfer agreements (deed of gift) and the record
+-- of which version was accepted for each accession
+--
+DROP TABLE IF EXISTS accession_agreements;
+DROP TABLE IF EXISTS agreements;
+CREATE TABLE agreements (
+   id int(11) NOT NULL AUTO_INCREMENT PRIMARY KEY,
+   version varchar(20) NOT NULL UNIQUE,
+   title varchar(255) NOT NULL,
+   body text NOT NULL,
+   effective_at datetime NOT NULL,
+   created_by int(11) DEFAULT NULL,
+   created_at datetime NOT NULL,
+   INDEX (effective_at),
+   FOREIGN KEY (created_by) REFERENCES users(id) ON DELETE SET NULL
+) ENGINE=InnoDB DEFAULT CHARSET=utf8;
+
+CREATE TABLE accession_agreements (
+   id int(11) NOT NULL AUTO_INCREMENT PRIMARY KEY,
+   accession_id int(11) NOT NULL UNIQUE,
+   agreement_id int(11) NOT NULL,
+   user_id int(11) NOT NULL,
+   ip_address varchar(45) NOT NULL default "",
+   accepted_at datetime NOT NULL,
+   FOREIGN KEY (accession_id) REFERENCES accessions(id) ON DELETE CASCADE,
+   FOREIGN KEY (agreement_id) REFERENCES agreements(id),
+   FOREIGN KEY (user_id) REFERENCES users(id)
+) ENGINE=InnoDB DEFAULT CHARSET=utf8;
+
+insert into agreements(version, title, body, effective_at, created_at) values
+   ("1.0", "Transfer of Records to the University Archives",
+    "By submitting this transfer, I confirm that I am authorized to transfer these records on behalf of the creating office and that ownership of the records, and any copyright held by the University in them, passes to the University of Virginia Library. The Library may arrange, describe, preserve, reformat and provide access to the records in accordance with its policies and any restrictions agreed at the time of transfer.",
+    NOW(), NOW());
+
+insert into versions(version, created_at) values ("v7", NOW());
@@ -0,0 +1,36 @@
+--
+-- Create the restriction category vocabulary and the table of access restrictions
+-- proposed by submitters and confirmed by admins
+--
+DROP TABLE IF EXISTS accession_restrictions;
+DROP TABLE IF EXISTS restriction_categories;
+CREATE TABLE restriction_categories (
+   id int(11) NOT NULL AUTO_INCREMENT PRIMARY KEY,
+   name varchar(255) NOT NULL,
+   description text,
+   UNIQUE KEY (name)
+) ENGINE=InnoDB DEFAULT CHARSET=utf8;
+
+insert into restriction_categories(name, description) values
+   ("FERPA", "Student education records protected by the Family Educational Rights and Privacy Act"),
+   ("HIPAA", "Protected health information covered by the Health Insurance Portability and Accountability Act"),
+   ("Personnel", "Personnel and employment records of individual faculty and staff"),
+   ("Legal", "Records subject to attorney-client privilege or pending legal matters"),
+   ("Donor/Office Requested", "Restriction requested by the transferring office for a fixed period");
+
+CREATE TABLE accession_restrictions (
+   id int(11) NOT NULL AUTO_INCREMENT PRIMARY KEY,
+   accession_id int(11) NOT NULL UNIQUE,
+   category_id int(11) NOT NULL,
+   restricted_until datetime DEFAULT NULL,
+   justification text NOT NULL,
+   status varchar(20) NOT NULL default "proposed",
+   confirmed_by int(11) DEFAULT NULL,
+   confirmed_at datetime DEFAULT NULL,
+   INDEX (restricted_until),
+   FOREIGN KEY (accession_id) REFERENCES accessions(id) ON DELETE CASCADE,
+   FOREIGN KEY (category_id) REFERENCES restriction_categories(id),
+   FOREIGN KEY (confirmed_by) REFERENCES users(id) ON DELETE SET NULL
+) ENGINE=InnoDB DEFAULT CHARSET=utf8;
+
+insert into versions(version, created_at) values ("v8", NOW());
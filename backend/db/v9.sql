@@ -0,0 +1,22 @@
+--
+-- Create table for university records retention schedule series and link accessions
+-- to the series that governs them
+--
+DROP TABLE IF EXISTS retention_schedules;
+CREATE TABLE retention_schedules (
+   id int(11) NOT NULL AUTO_INCREMENT PRIMARY KEY,
+   schedule_number varchar(50) NOT NULL,
+   series_title varchar(255) NOT NULL,
+   retention_period varchar(255) NOT NULL default "",
+   retention_years int(11) DEFAULT NULL,
+   disposition varchar(255) NOT NULL default "",
+   UNIQUE KEY (schedule_number)
+) ENGINE=InnoDB DEFAULT CHARSET=utf8;
+
+ALTER TABLE accessions
+   ADD COLUMN retention_schedule_id int(11) DEFAULT NULL,
+   ADD COLUMN disposition_date date DEFAULT NULL,
+   ADD INDEX (disposition_date),
+   ADD FOREIGN KEY (retention_schedule_id) REFERENCES retention_schedules(id) ON DELETE SET NULL;
+
+insert into versions(version, created_at) values ("v9", NOW());
@@ -1,8 +1,13 @@
 package main
 
 import (
+	"crypto/sha256"
+	"encoding/json"
+	"fmt"
 	"log"
+	"regexp"
 	"strconv"
+	"strings"
 	"time"
 
 	dbx "github.com/go-ozzo/ozzo-dbx"
@@ -16,7 +21,7 @@ type DigitalAccession struct {
 	DateRange   *string  `json:"dateRange" db:"date_range"`
 	RecordTypes []string `json:"selectedTypes" db:"-"`
 	Files       []string `json:"uploadedFiles" db:"-"`
-	TotalSize   int      `json:"totalSizeBytes" db:"upload_size"`
+	TotalSize   int64    `json:"totalSizeBytes" db:"upload_size"`
 }
 
 // GetFiles retrieves the list of files associated with this accession
@@ -52,13 +57,17 @@ func (da *DigitalAccession) TableName() string {
 
 // InventoryItem contains data to describe a physical inventory item
 type InventoryItem struct {
-	ID              int    `json:"-"`
-	PhysAccessionID int    `json:"-" db:"physical_accession_id"`
-	BoxNumber       string `json:"boxNum" db:"box_number"`
-	RecordGroup     string `json:"recordGroup" db:"record_group_number"`
-	Title           string `json:"title" db:"box_title"`
-	Description     string `json:"description" db:"description"`
-	Dates           string `json:"dates" db:"dates"`
+	ID              int        `json:"-"`
+	PhysAccessionID int        `json:"-" db:"physical_accession_id"`
+	BoxNumber       string     `json:"boxNum" db:"box_number"`
+	RecordGroup     string     `json:"recordGroup" db:"record_group_number"`
+	Title           string     `json:"title" db:"box_title"`
+	Description     string     `json:"description" db:"description"`
+	Dates           string     `json:"dates" db:"dates"`
+	Status          string     `json:"status" db:"status"`
+	Note            string     `json:"note" db:"note"`
+	CheckedAt       *time.Time `json:"checkedAt" db:"checked_at"`
+	CheckedBy       *int       `json:"checkedBy" db:"checked_by"`
 }
 
 // TableName defines the expected DB table name that holds data for inventory items
@@ -81,6 +90,10 @@ type PhysicalAccession struct {
 	MediaCount       string          `json:"mediaCount" db:"media_counts"`
 	HasSoftware      string          `json:"hasSoftware" db:"has_software"`
 	Inventory        []InventoryItem `json:"inventory" db:"-"`
+	ReceivedAt       *time.Time      `json:"receivedAt" db:"received_at"`
+	ReceivedByID     *int            `json:"-" db:"received_by"`
+	ReceivedBy       string          `json:"receivedBy" db:"-"`
+	ReceiptClosedAt  *time.Time      `json:"receiptClosedAt" db:"receipt_closed_at"`
 }
 
 // TableName defines the expected DB table name that holds data for physical accessions
@@ -127,20 +140,44 @@ func (pa *PhysicalAccession) GetInventory(db *dbx.DB) {
 // NOTE: block out lists and associated structures so there is no attempt to write
 // the to the DB when the Accession is written. Handle them as separate commits / reads
 type Accession struct {
-	ID               int               `json:"id" db:"id"`
-	Identifier       string            `json:"identifier" db:"identifier"`
-	UserID           int               `json:"-" db:"user_id"`
-	User             User              `json:"user" db:"-"`
-	Summary          string            `json:"summary" binding:"required" db:"description"`
-	Activities       *string           `json:"activities" db:"activities"`
-	Creator          *string           `json:"creator" db:"creator"`
-	Genres           []string          `json:"genres" db:"-"`
-	Type             string            `json:"accessionType" db:"accession_type"`
-	CreatedAt        time.Time         `json:"createdAt" db:"created_at"`
-	DigitalTransfer  bool              `json:"digitalTransfer" db:"-"`
-	Digital          DigitalAccession  `json:"digital" db:"-"`
-	PhysicalTransfer bool              `json:"physicalTransfer" db:"-"`
-	Physical         PhysicalAccession `json:"physical" db:"-"`
+	ID                  int                `json:"id" db:"id"`
+	Identifier          string             `json:"identifier" db:"identifier"`
+	AccessionNumber     string             `json:"accessionNumber" db:"accession_number"`
+	UserID              int                `json:"-" db:"user_id"`
+	User                User               `json:"user" db:"-"`
+	OwnerID             *int               `json:"-" db:"owner_id"`
+	Owner               *User              `json:"owner" db:"-" binding:"-"`
+	UnitID              *int               `json:"unitID" db:"unit_id"`
+	Unit                string             `json:"unit" db:"-"`
+	Summary             string             `json:"summary" binding:"required" db:"description"`
+	Activities          *string            `json:"activities" db:"activities"`
+	Creator             *string            `json:"creator" db:"creator"`
+	Genres              []string           `json:"genres" db:"-"`
+	Type                string             `json:"accessionType" db:"accession_type"`
+	Status              string             `json:"status" db:"status"`
+	DueAt               *time.Time         `json:"dueAt" db:"due_at"`
+	DueStatus           string             `json:"dueStatus" db:"due_status"`
+	RemindedAt          *time.Time         `json:"-" db:"reminded_at"`
+	RequestHash         string             `json:"-" db:"request_hash"`
+	AccessToken         string             `json:"-" db:"access_token"`
+	RetentionScheduleID *int               `json:"retentionScheduleID" db:"retention_schedule_id"`
+	RetentionSchedule   *RetentionSchedule `json:"retentionSchedule" db:"-"`
+	DispositionDate     *time.Time         `json:"dispositionDate" db:"disposition_date"`
+	CreatedAt           time.Time          `json:"createdAt" db:"created_at"`
+	DigitalTransfer     bool               `json:"digitalTransfer" db:"-"`
+	Digital             DigitalAccession   `json:"digital" db:"-"`
+	PhysicalTransfer    bool               `json:"physicalTransfer" db:"-"`
+	Physical            PhysicalAccession  `json:"physical" db:"-"`
+	Links               []AccessionLink    `json:"links" db:"-"`
+	Assignments         []Assignment       `json:"assignments,omitempty" db:"-"`
+	StatusHistory       []StatusChange     `json:"statusHistory,omitempty" db:"-"`
+	Attachments         []Attachment       `json:"attachments,omitempty" db:"-"`
+	Events              []AccessionEvent   `json:"events,omitempty" db:"-"`
+	AccrualChain        []AccrualSummary   `json:"accrualChain,omitempty" db:"-"`
+	AgreementID         int                `json:"agreementID" db:"-"`
+	AgreementAccepted   bool               `json:"agreementAccepted" db:"-"`
+	Agreement           *AcceptedAgreement `json:"agreement" db:"-"`
+	Restriction         *Restriction       `json:"restriction" db:"-"`
 }
 
 // TableName defines the expected DB table name that holds data for users
@@ -148,6 +185,148 @@ func (a *Accession) TableName() string {
 	return "accessions"
 }
 
+// LoadAccession reads an accession and all of its transfer details from the DB. Note that
+// vocabulary fields (genres, record types, carriers) are loaded as names rather than IDs
+func LoadAccession(db *dbx.DB, ID interface{}) (*Accession, error) {
+	q := db.NewQuery("select * from accessions where id={:id}")
+	q.Bind((dbx.Params{"id": ID}))
+	var accession Accession
+	err := q.One(&accession)
+	if err != nil {
+		return nil, err
+	}
+	err = db.Select().Model(accession.UserID, &accession.User)
+	if err != nil {
+		log.Printf("WARN: Unable to get submitter %d for accession %d: %s", accession.UserID, accession.ID, err.Error())
+	}
+	if accession.OwnerID != nil {
+		var owner User
+		err = db.Select().Model(*accession.OwnerID, &owner)
+		if err != nil {
+			log.Printf("WARN: Unable to get owner %d for accession %d: %s", *accession.OwnerID, accession.ID, err.Error())
+		} else {
+			accession.Owner = &owner
+		}
+	}
+	accession.GetGenres(db)
+	accession.GetDigitalTransferDetail(db)
+	accession.GetPhysicalTransferDetail(db)
+	accession.GetLinks(db)
+	accession.GetAgreement(db)
+	accession.GetRestriction(db)
+	accession.GetRetentionSchedule(db)
+	accession.Unit = GetUnitName(db, accession.UnitID)
+	return &accession, nil
+}
+
+// LoadAccessionByToken reads an accession and all of its transfer details based on the
+// access token in the submitter's download links
+func LoadAccessionByToken(db *dbx.DB, token string) (*Accession, error) {
+	var acc struct{ ID int }
+	q := db.NewQuery("select id from accessions where access_token={:token} and access_token != ''")
+	q.Bind(dbx.Params{"token": token})
+	err := q.One(&acc)
+	if err != nil {
+		return nil, err
+	}
+	return LoadAccession(db, acc.ID)
+}
+
+// FormatAccessionNumber builds a human readable accession number from a pattern. The
+// pattern may contain {YYYY} or {YY} for the year and a run of N in braces, such as
+// {NNNN}, for the zero padded sequence number.
+func FormatAccessionNumber(pattern string, year int, seq int) string {
+	out := strings.Replace(pattern, "{YYYY}", fmt.Sprintf("%04d", year), -1)
+	out = strings.Replace(out, "{YY}", fmt.Sprintf("%02d", year%100), -1)
+	return accessionSeqRegex.ReplaceAllStringFunc(out, func(token string) string {
+		return fmt.Sprintf("%0*d", len(token)-2, seq)
+	})
+}
+
+var accessionSeqRegex = regexp.MustCompile(`\{N+\}`)
+
+// IsAccessionNumber returns true if the text has the shape of an accession number made
+// with the pattern, such as UA-2026-0042 for UA-{YYYY}-{NNNN}
+func IsAccessionNumber(pattern string, text string) bool {
+	expr := regexp.QuoteMeta(pattern)
+	expr = strings.Replace(expr, regexp.QuoteMeta("{YYYY}"), `\d{4}`, -1)
+	expr = strings.Replace(expr, regexp.QuoteMeta("{YY}"), `\d{2}`, -1)
+	expr = regexp.MustCompile(`\\\{N+\\\}`).ReplaceAllString(expr, `\d+`)
+	re, err := regexp.Compile("(?i)^" + expr + "$")
+	return err == nil && re.MatchString(text)
+}
+
+// AssignAccessionNumber takes the next number in the sequence for the current year and
+// formats it as the accession number. This must be called in the submission transaction;
+// the sequence row stays locked until commit so concurrent submissions can't share a number
+func (a *Accession) AssignAccessionNumber(tx *dbx.Tx, pattern string) error {
+	num, err := nextAccessionNumber(tx, pattern, time.Now().Year())
+	if err != nil {
+		return err
+	}
+	a.AccessionNumber = num
+	log.Printf("Assigned accession number %s to %s", a.AccessionNumber, a.Identifier)
+	return nil
+}
+
+// nextAccessionNumber takes the next number in the sequence for a year and formats it
+// with the accession number pattern
+func nextAccessionNumber(tx *dbx.Tx, pattern string, year int) (string, error) {
+	q := tx.NewQuery(`insert into accession_sequences (year, last_number) values ({:year}, 1)
+		on duplicate key update last_number=last_number+1`)
+	q.Bind(dbx.Params{"year": year})
+	_, err := q.Execute()
+	if err != nil {
+		return "", err
+	}
+	var seq int
+	sq := tx.NewQuery("select last_number from accession_sequences where year={:year}")
+	sq.Bind(dbx.Params{"year": year})
+	err = sq.Row(&seq)
+	if err != nil {
+		return "", err
+	}
+	return FormatAccessionNumber(pattern, year, seq), nil
+}
+
+// NumberAccessions assigns accession numbers to the accessions submitted before numbers
+// were introduced. They are numbered in submission order within the year submitted
+func (svc *ServiceContext) NumberAccessions() error {
+	var accs []struct {
+		ID        int       `db:"id"`
+		CreatedAt time.Time `db:"created_at"`
+	}
+	q := svc.DB.NewQuery("select id, created_at from accessions where accession_number is null order by created_at, id")
+	err := q.All(&accs)
+	if err != nil {
+		return err
+	}
+	for _, acc := range accs {
+		tx, _ := svc.DB.Begin()
+		num, err := nextAccessionNumber(tx, svc.AccessionNumberFormat, acc.CreatedAt.Year())
+		if err == nil {
+			_, err = tx.Update("accessions", dbx.Params{"accession_number": num}, dbx.HashExp{"id": acc.ID}).Execute()
+		}
+		if err != nil {
+			tx.Rollback()
+			return err
+		}
+		err = tx.Commit()
+		if err != nil {
+			return err
+		}
+		log.Printf("Assigned accession number %s to accession %d", num, acc.ID)
+	}
+	return nil
+}
+
+// GenerateRequestHash returns a fingerprint of the submitted accession data. It is used to
+// tell a retry of an identical submission apart from a conflicting one
+func (a *Accession) GenerateRequestHash() string {
+	data, _ := json.Marshal(a)
+	return fmt.Sprintf("%x", sha256.Sum256(data))
+}
+
 // WriteGenres writes genre info for an accession to the DB
 func (a *Accession) WriteGenres(tx *dbx.Tx) {
 	log.Printf("Commmit genres")
@@ -206,6 +385,13 @@ func (a *Accession) GetPhysicalTransferDetail(db *dbx.DB) {
 	a.Physical.GetMediaCarriers(db)
 	a.Physical.GetInventory(db)
 	a.Physical.TransferMethod = GetVocabName(db, "transfer_methods", a.Physical.TransferMethodID)
+	if a.Physical.ReceivedByID != nil {
+		var staff User
+		err = db.Select().Model(*a.Physical.ReceivedByID, &staff)
+		if err == nil {
+			a.Physical.ReceivedBy = staff.FullName()
+		}
+	}
 }
 
 // WriteDigitalTransfer writes digital xfer info for an accession to the DB
@@ -248,7 +434,7 @@ func (a *Accession) WriteDigitalTransfer(tx *dbx.Tx) error {
 func (a *Accession) WritePhysicalTransfer(tx *dbx.Tx) error {
 	log.Printf("Commmit physical transfer details")
 	a.Physical.AccessionID = a.ID
-	err := tx.Model(&a.Physical).Insert()
+	err := tx.Model(&a.Physical).Exclude("ReceivedAt", "ReceivedByID", "ReceiptClosedAt").Insert()
 	if err != nil {
 		return err
 	}
@@ -281,7 +467,8 @@ func (a *Accession) WritePhysicalTransfer(tx *dbx.Tx) error {
 	log.Printf("Commmit physical inventory")
 	for _, item := range a.Physical.Inventory {
 		item.PhysAccessionID = a.Physical.ID
-		err = tx.Model(&item).Insert()
+		item.Status = "expected"
+		err = tx.Model(&item).Exclude("Note", "CheckedAt", "CheckedBy").Insert()
 		if err != nil {
 			log.Printf("WARN: Unable to attach inventory %+v to physical accession %d", item, a.Physical.ID)
 		}
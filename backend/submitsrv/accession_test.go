@@ -0,0 +1,72 @@
+package main
+
+import "testing"
+
+func TestFormatAccessionNumber(t *testing.T) {
+	tests := []struct {
+		pattern string
+		year    int
+		seq     int
+		want    string
+	}{
+		{"{YYYY}-{NNNN}", 2026, 42, "2026-0042"},
+		{"UA-{YY}-{NNN}", 2026, 7, "UA-26-007"},
+		{"{YYYY}-{NNNN}", 2026, 9999, "2026-9999"},
+		{"{YYYY}-{NN}", 2026, 123, "2026-123"},
+		{"{YYYY}-{NNNN}", 2027, 1, "2027-0001"},
+		{"{YY}{NN}", 2100, 3, "0003"},
+		{"{YY}{NN}", 2009, 3, "0903"},
+		{"ACC-{YYYY}", 2026, 5, "ACC-2026"},
+		{"{N}/{NNN}", 2026, 5, "5/005"},
+		{"{YYYY}.{n}", 2026, 5, "2026.{n}"},
+		{"{YYYY}-{}", 2026, 5, "2026-{}"},
+		{"RG {YYYY} {YY} {NNNN}", 2026, 12, "RG 2026 26 0012"},
+	}
+	for _, tt := range tests {
+		got := FormatAccessionNumber(tt.pattern, tt.year, tt.seq)
+		if got != tt.want {
+			t.Errorf("FormatAccessionNumber(%q, %d, %d) = %q, want %q", tt.pattern, tt.year, tt.seq, got, tt.want)
+		}
+	}
+}
+
+func TestIsAccessionNumber(t *testing.T) {
+	tests := []struct {
+		pattern string
+		text    string
+		want    bool
+	}{
+		{"UA-{YYYY}-{NNNN}", "UA-2026-0042", true},
+		{"UA-{YYYY}-{NNNN}", "ua-2026-0042", true},
+		{"UA-{YYYY}-{NNNN}", "UA-2026-10000", true},
+		{"UA-{YYYY}-{NNNN}", "UA-2026-0042x", false},
+		{"UA-{YYYY}-{NNNN}", "xUA-2026-0042", false},
+		{"UA-{YYYY}-{NNNN}", "UA-2026-0042 letters", false},
+		{"UA-{YYYY}-{NNNN}", "UA-26-0042", false},
+		{"UA-{YYYY}-{NNNN}", "UA-2026-", false},
+		{"UA-{YYYY}-{NNNN}", "UA-2026-00a2", false},
+		{"UA-{YYYY}-{NNNN}", "", false},
+		{"{YYYY}-{NNNN}", "2026-0042", true},
+		{"{YYYY}-{NNNN}", "letters", false},
+		{"A.{YY}.{NNN}", "A.26.001", true},
+		{"A.{YY}.{NNN}", "AX26Y001", false},
+		{"({YY})+{NN}", "(26)+01", true},
+		{"({YY})+{NN}", "2626601", false},
+	}
+	for _, tt := range tests {
+		got := IsAccessionNumber(tt.pattern, tt.text)
+		if got != tt.want {
+			t.Errorf("IsAccessionNumber(%q, %q) = %t, want %t", tt.pattern, tt.text, got, tt.want)
+		}
+	}
+
+	// every number the pattern makes must be recognized as one
+	for _, pattern := range []string{"{YYYY}-{NNNN}", "UA-{YY}-{NNN}", "A.{YYYY}.{N}"} {
+		for _, seq := range []int{1, 42, 999, 12345} {
+			num := FormatAccessionNumber(pattern, 2026, seq)
+			if IsAccessionNumber(pattern, num) == false {
+				t.Errorf("IsAccessionNumber(%q, %q) = false for a formatted number", pattern, num)
+			}
+		}
+	}
+}
@@ -4,6 +4,7 @@ import (
 	"fmt"
 	"log"
 	"net/http"
+	"net/url"
 	"strconv"
 	"strings"
 	"time"
@@ -12,126 +13,343 @@ import (
 	dbx "github.com/go-ozzo/ozzo-dbx"
 )
 
-// GetAccessions is an asmin API call that returns a paged list of accessions
-func (svc *ServiceContext) GetAccessions(c *gin.Context) {
-	pageStr := c.Query("page")
-	const pageSize = 50
-	page := 1
-	if pageStr != "" {
-		pageInt, err := strconv.Atoi(pageStr)
-		if err == nil {
-			page = pageInt
-		}
-	}
-	start := (page - 1) * pageSize
-
-	type AccessionRow struct {
-		ID          int       `json:"id" db:"id"`
-		AccessionID string    `json:"accessionID" db:"identifier"`
-		Submitter   string    `json:"submitter" db:"submitter"`
-		Description string    `json:"description" db:"description"`
-		Type        string    `json:"type" db:"accession_type"`
-		Genres      string    `json:"genres" db:"genres"`
-		Digital     bool      `json:"digital" db:"digital"`
-		Physical    bool      `json:"physical" db:"physical"`
-		Notes       int       `json:"notes" db:"notes"`
-		SubmittedAt time.Time `json:"submittedAt" db:"created_at"`
-	}
-	type SubmissionsPage struct {
-		Total         int            `json:"total"`
-		FilteredTotal int            `json:"filteredTotal"`
-		Page          int            `json:"page"`
-		PageSize      int            `json:"pageSize"`
-		Accessions    []AccessionRow `json:"accessions"`
-	}
-	out := SubmissionsPage{Total: 0, Page: page, PageSize: pageSize}
-
-	log.Printf("Get total accessions")
-	tq := svc.DB.NewQuery("select count(*) as total from accessions")
-	tq.One(&out)
+// AccessionRow is one row of the admin accession list
+type AccessionRow struct {
+	ID                int             `json:"id" db:"id"`
+	AccessionID       string          `json:"accessionID" db:"identifier"`
+	AccessionNumber   string          `json:"accessionNumber" db:"accession_number"`
+	Submitter         string          `json:"submitter" db:"submitter"`
+	Owner             string          `json:"owner" db:"owner"`
+	Unit              string          `json:"unit" db:"unit"`
+	Description       string          `json:"description" db:"description"`
+	Type              string          `json:"type" db:"accession_type"`
+	Genres            string          `json:"genres" db:"genres"`
+	Status            string          `json:"status" db:"status"`
+	Assignees         string          `json:"assignees" db:"assignees"`
+	Digital           bool            `json:"digital" db:"digital"`
+	Physical          bool            `json:"physical" db:"physical"`
+	Notes             int             `json:"notes" db:"notes"`
+	Accruals          int             `json:"accruals" db:"accruals"`
+	UnreadMessages    int             `json:"unreadMessages" db:"unread_messages"`
+	Restriction       string          `json:"restriction" db:"restriction"`
+	RestrictionStatus string          `json:"restrictionStatus" db:"restriction_status"`
+	RestrictedUntil   *time.Time      `json:"restrictedUntil" db:"restricted_until"`
+	ScheduleNumber    string          `json:"scheduleNumber" db:"schedule_number"`
+	DispositionDate   *time.Time      `json:"dispositionDate" db:"disposition_date"`
+	DueAt             *time.Time      `json:"dueAt" db:"due_at"`
+	DueStatus         string          `json:"dueStatus" db:"due_status"`
+	Overdue           bool            `json:"overdue" db:"overdue"`
+	SubmittedAt       time.Time       `json:"submittedAt" db:"created_at"`
+	Score             float64         `json:"score" db:"score"`
+	Snippets          []SearchSnippet `json:"snippets,omitempty" db:"-"`
+}
 
-	sort := strings.TrimSpace(c.Query("sort"))
-	sortBy := strings.Split(sort, ":")[0]
-	sortDir := strings.Split(sort, ":")[1]
-	if sortBy == "" || sortBy == "submittedAt" {
-		sortBy = "created_at"
-	}
-	if sortDir == "" {
-		sortBy = "desc"
-	}
+// accessionQuery is the SQL for the admin accession list with the filters and sort
+// from the request query params applied. It is shared by the paged list and exports
+type accessionQuery struct {
+	where      []string
+	having     string
+	genre      string
+	sortColumn string
+	sortDir    string
+	search     []searchTerm
+	facets     map[string]*facetSelection
+	params     dbx.Params
+	filtered   bool
+}
 
-	selQS := fmt.Sprintf(`select a.id as id, identifier, concat(u.last_name, ', ', u.first_name) as submitter, 
-		a.description as description, accession_type, group_concat(g.name) genres, 
+const accessionSelectQS = `select a.id as id, identifier, accession_number, concat(u.last_name, ', ', u.first_name) as submitter, 
+		coalesce((select concat(o.last_name, ', ', o.first_name) from users o where o.id=a.owner_id), "") as owner,
+		coalesce((select ou.name from org_units ou where ou.id=a.unit_id), "") as unit,
+		a.description as description, accession_type, group_concat(g.name) genres, a.status as status,
+		coalesce((select group_concat(concat(su.first_name, ' ', su.last_name) separator ', ') from accession_assignments s
+			inner join users su on su.id=s.user_id where s.accession_id=a.id and s.unassigned_at is null), "") as assignees,
 		(select count(*) from digital_accessions da where da.accession_id=a.id) as digital,
 		(select count(*) from physical_accessions pa where pa.accession_id=a.id) as physical,
 		(select count(*) from accession_notes an where an.accession_id=a.id) as notes,
-		a.created_at`)
-	fromQS := ` from accessions a 
+		(select count(*) from accession_links al where al.related_accession_id=a.id and al.link_type="accrual") as accruals,
+		(select count(*) from accession_messages am where am.accession_id=a.id and am.from_staff=0 and am.read_at is null) as unread_messages,
+		coalesce((select rc.name from accession_restrictions ar inner join restriction_categories rc on rc.id=ar.category_id
+			where ar.accession_id=a.id), "") as restriction,
+		coalesce((select ar.status from accession_restrictions ar where ar.accession_id=a.id), "") as restriction_status,
+		(select ar.restricted_until from accession_restrictions ar where ar.accession_id=a.id) as restricted_until,
+		coalesce((select rs.schedule_number from retention_schedules rs where rs.id=a.retention_schedule_id), "") as schedule_number,
+		a.disposition_date, a.due_at, a.due_status, (a.due_at is not null and a.due_at < now()) as overdue,
+		a.created_at`
+
+const accessionFromQS = ` from accessions a 
 			inner join users u on u.id = user_id
 			inner join accession_genres ag on ag.accession_id = a.id
 			inner join genres g on g.id = ag.genre_id
 			left outer join digital_accessions da on da.accession_id = a.id
 			 left outer join physical_accessions pa on pa.accession_id = a.id`
-	qs := selQS + fromQS
-	groupQS := " group by a.id"
-	pageQS := fmt.Sprintf(" order by %s %s limit %d,%d", sortBy, sortDir, start, pageSize)
-
-	// Check for and apply and filter / query params
-	qParam := strings.TrimSpace(c.Query("q"))
-	qQuery := ""
-	if qParam != "" {
-		log.Printf("Filter accessions by query string [%s]", qParam)
-		qParam = "%" + qParam + "%"
-		qQuery = ` where (a.description like {:q} or da.description like {:q} or tech_description like {:q}
-			or first_name like {:q} or last_name like {:q}
-			or pa.date_range like {:q} or da.date_range like {:q} or a.created_at like {:q})`
-		qs += qQuery
-	}
-
-	gParam := strings.TrimSpace(c.Query("g"))
-	if gParam != "" {
-		log.Printf("Filter submission by genre [%s]", gParam)
+
+// newAccessionQuery builds the accession list query from list query params. The user
+// is the admin the assigned=me filter refers to
+func (svc *ServiceContext) newAccessionQuery(query url.Values, userID int) (*accessionQuery, error) {
+	aq := accessionQuery{params: dbx.Params{}}
+
+	// Check for and apply and filter / query params. The query is a full text search,
+	// and results are in order of relevance unless another sort is requested. An
+	// accession number is too short for the full text index, so it is matched exactly
+	qParam := strings.TrimSpace(query.Get("q"))
+	if IsAccessionNumber(svc.AccessionNumberFormat, qParam) {
+		log.Printf("Search accessions for number [%s]", qParam)
+		aq.params["number"] = qParam
+		aq.where = append(aq.where, "a.accession_number={:number}")
+	} else if aq.search = parseSearchQuery(qParam); len(aq.search) > 0 {
+		log.Printf("Search accessions for [%s]", qParam)
+		aq.params["q"] = rankQuery(aq.search)
+		aq.where = append(aq.where, searchConditions(aq.search, aq.params)...)
+	}
+	err := aq.parseSort(strings.TrimSpace(query.Get("sort")))
+	if err != nil {
+		return nil, err
+	}
+	err = aq.parseFilters(query)
+	if err != nil {
+		return nil, err
+	}
+
+	// When grouping accruals, only the original accessions are listed. The accruals
+	// column of each row counts the accruals made to it
+	if query.Get("accruals") == "group" {
+		log.Printf("Group accruals under their original accession")
+		aq.where = append(aq.where, `not exists (select 1 from accession_links al
+			where al.accession_id=a.id and al.link_type="accrual")`)
+	}
+
+	if status := strings.TrimSpace(query.Get("status")); status != "" {
+		log.Printf("Filter accessions by status [%s]", status)
+		aq.params["status"] = status
+		aq.where = append(aq.where, "a.status={:status}")
+	}
+
+	if query.Get("overdue") == "true" {
+		log.Printf("Filter overdue accessions")
+		aq.where = append(aq.where, "a.due_at < now()")
+	}
+
+	// Assignment filters are me, for the current admin, or none for unassigned accessions
+	switch query.Get("assigned") {
+	case "me":
+		log.Printf("Filter accessions assigned to the current user")
+		aq.params["assignee"] = userID
+		aq.where = append(aq.where, `exists (select 1 from accession_assignments s
+			where s.accession_id=a.id and s.user_id={:assignee} and s.unassigned_at is null)`)
+	case "none":
+		log.Printf("Filter unassigned accessions")
+		aq.where = append(aq.where, `not exists (select 1 from accession_assignments s
+			where s.accession_id=a.id and s.unassigned_at is null)`)
+	}
+
+	// Filtering by unit includes the accessions of all units below it
+	if unitParam := strings.TrimSpace(query.Get("unit")); unitParam != "" {
+		log.Printf("Filter accessions by unit [%s]", unitParam)
+		unitID, err := strconv.Atoi(unitParam)
+		if err != nil {
+			return nil, filterError{fmt.Errorf("invalid unit %s", unitParam)}
+		}
+		unitIDs, err := getUnitTree(svc.DB, unitID)
+		if err != nil {
+			return nil, err
+		}
+		idStrs := make([]string, 0, len(unitIDs))
+		for _, id := range unitIDs {
+			idStrs = append(idStrs, strconv.Itoa(id))
+		}
+		aq.where = append(aq.where, fmt.Sprintf("a.unit_id in (%s)", strings.Join(idStrs, ",")))
+	}
+
+	aq.genre = strings.TrimSpace(query.Get("g"))
+	if aq.genre != "" {
+		log.Printf("Filter submission by genre [%s]", aq.genre)
 		// To ensure all tags are included in result, can't use where clause.
 		// If used, only the single matching tag is returned. Instead add a having
 		// clause to the group by. The is leaves all tags in the results and matches
 		// on the CSV tag list instead.
-		groupQS += " having Find_In_Set({:g}, genres)"
+		aq.params["g"] = aq.genre
+		aq.having = "Find_In_Set({:g}, genres)"
 	}
 
-	if qParam != "" || gParam != "" {
-		countQS := "select count(distinct a.id) as filtered_cnt " + fromQS
-		if qQuery != "" {
-			countQS += qQuery
-		}
+	aq.parseFacets(query)
+	aq.filtered = len(aq.where) > 0 || len(aq.facets) > 0 || aq.genre != ""
+	return &aq, nil
+}
 
-		// Since all of the tags are not required for a simple match count,
-		// the weird group by and having find_in_set is not needed.
-		// Just a simple where will work.
-		if gParam != "" {
-			if strings.Contains(countQS, " where ") {
-				countQS += " and "
-			} else {
-				countQS += " where "
-			}
-			countQS += " g.name={:t}"
+// accessionQueryError sends the error response for a failure to build an accession
+// query. Invalid filters are bad requests
+func (svc *ServiceContext) accessionQueryError(c *gin.Context, err error) {
+	if _, ok := err.(filterError); ok {
+		c.String(http.StatusBadRequest, err.Error())
+		return
+	}
+	log.Printf("ERROR: Unable to build accession query: %s", err.Error())
+	c.String(http.StatusInternalServerError, err.Error())
+}
+
+// whereQS returns the where clause for the query plus any extra conditions
+func (aq *accessionQuery) whereQS(extra ...string) string {
+	conds := append(append(append([]string{}, aq.where...), aq.facetWhere("")...), extra...)
+	if len(conds) == 0 {
+		return ""
+	}
+	return " where " + strings.Join(conds, " and ")
+}
+
+// scoreQS returns the relevance score column for a search, or nothing if there is none
+func (aq *accessionQuery) scoreQS() string {
+	if len(aq.search) == 0 {
+		return ""
+	}
+	return `, (select sum(match(s.content) against ({:q} in boolean mode)) from accession_search s
+		where s.accession_id=a.id) as score`
+}
+
+// listQS returns the SQL that selects all of the matching accession rows in order
+func (aq *accessionQuery) listQS() string {
+	return aq.pageQS(nil)
+}
+
+// pageQS returns the SQL that selects one page of the matching accession rows. The
+// keyset condition is in the having clause since the sort may be on a computed column
+func (aq *accessionQuery) pageQS(pr *pageRequest) string {
+	having := make([]string, 0)
+	if aq.having != "" {
+		having = append(having, aq.having)
+	}
+	limit := ""
+	if pr != nil {
+		if keyset := pr.keysetQS(aq.sortColumn, "id", aq.sortDir, aq.params); keyset != "" {
+			having = append(having, keyset)
 		}
+		limit = pr.limitQS()
+	}
+	qs := accessionSelectQS + aq.scoreQS() + accessionFromQS + aq.whereQS() + " group by a.id"
+	if len(having) > 0 {
+		qs += " having " + strings.Join(having, " and ")
+	}
+	return qs + fmt.Sprintf(" order by %s %s, id %s", aq.sortColumn, aq.sortDir, aq.sortDir) + limit
+}
+
+// sortValue returns the value of the sort column of an accession row
+func (row *AccessionRow) sortValue(column string) interface{} {
+	switch column {
+	case "accession_number":
+		return row.AccessionNumber
+	case "accession_type":
+		return row.Type
+	case "submitter":
+		return row.Submitter
+	case "owner":
+		return row.Owner
+	case "unit":
+		return row.Unit
+	case "status":
+		return row.Status
+	case "physical":
+		return row.Physical
+	case "digital":
+		return row.Digital
+	case "notes":
+		return row.Notes
+	case "accruals":
+		return row.Accruals
+	case "due_at":
+		return row.DueAt
+	case "disposition_date":
+		return row.DispositionDate
+	case "score":
+		return row.Score
+	}
+	return row.SubmittedAt
+}
+
+// countQS returns the SQL that counts the matching accessions, with any extra conditions
+func (aq *accessionQuery) countQS(extra ...string) string {
+	// Since all of the tags are not required for a simple match count,
+	// the weird group by and having find_in_set is not needed.
+	// Just a simple where will work.
+	if aq.genre != "" {
+		extra = append(extra, "g.name={:g}")
+	}
+	return "select count(distinct a.id) as filtered_cnt " + accessionFromQS + aq.whereQS(extra...)
+}
+
+// GetAccessions is an asmin API call that returns a page of accessions. Pages are
+// requested with the cursor from the previous page. Totals are only counted when the
+// total param is true, and facets only for the first page. The search param runs a
+// saved search in place of the filters in the request
+func (svc *ServiceContext) GetAccessions(c *gin.Context) {
+	type SubmissionsPage struct {
+		Total         *int           `json:"total,omitempty"`
+		FilteredTotal *int           `json:"filteredTotal,omitempty"`
+		PageSize      int            `json:"pageSize"`
+		NextCursor    string         `json:"nextCursor"`
+		Accessions    []AccessionRow `json:"accessions"`
+		Facets        []Facet        `json:"facets,omitempty"`
+	}
+
+	aq, err := svc.listAccessionQuery(c)
+	if err != nil {
+		svc.accessionQueryError(c, err)
+		return
+	}
+	pr, err := svc.parsePageRequest(c)
+	if err != nil {
+		svc.accessionQueryError(c, err)
+		return
+	}
+	out := SubmissionsPage{PageSize: pr.size}
 
-		log.Printf("Get filtered total")
-		cq := svc.DB.NewQuery(countQS)
-		cq.Bind(dbx.Params{"q": qParam, "g": gParam})
-		cq.Row(&out.FilteredTotal)
+	if c.Query("total") == "true" {
+		log.Printf("Get total accessions")
+		total, err := svc.Counts.getCount(svc.DB, "select count(*) from accessions", dbx.Params{})
+		if err == nil && aq.filtered {
+			log.Printf("Get filtered total")
+			filtered, ferr := svc.Counts.getCount(svc.DB, aq.countQS(), aq.params)
+			out.FilteredTotal, err = &filtered, ferr
+		}
+		if err != nil {
+			log.Printf("ERROR: Unable to count accessions: %s", err.Error())
+			c.String(http.StatusInternalServerError, err.Error())
+			return
+		}
+		out.Total = &total
 	}
 
 	log.Printf("Get one page of submission data")
-	qs = qs + groupQS + pageQS
-	q := svc.DB.NewQuery(qs)
-	q.Bind(dbx.Params{"q": qParam, "g": gParam})
-	err := q.All(&out.Accessions)
+	q := svc.DB.NewQuery(aq.pageQS(pr))
+	q.Bind(aq.params)
+	err = q.All(&out.Accessions)
 	if err != nil {
 		log.Printf("ERROR: Unable to get accessions: %s", err.Error())
 		c.String(http.StatusInternalServerError, err.Error())
 		return
 	}
+	if len(out.Accessions) > pr.size {
+		out.Accessions = out.Accessions[:pr.size]
+		last := out.Accessions[pr.size-1]
+		out.NextCursor = nextCursor(cursorValue(last.sortValue(aq.sortColumn)), last.ID)
+	}
+	if pr.cursor == nil {
+		out.Facets, err = aq.getFacets(svc.DB)
+		if err != nil {
+			log.Printf("ERROR: Unable to get accession facets: %s", err.Error())
+			c.String(http.StatusInternalServerError, err.Error())
+			return
+		}
+	}
+	if len(aq.search) > 0 {
+		ids := make([]int, 0, len(out.Accessions))
+		for _, row := range out.Accessions {
+			ids = append(ids, row.ID)
+		}
+		snippets := getSearchSnippets(svc.DB, ids, aq.search)
+		for idx := range out.Accessions {
+			out.Accessions[idx].Snippets = snippets[out.Accessions[idx].ID]
+		}
+	}
 
 	c.JSON(http.StatusOK, out)
 }
@@ -139,17 +357,17 @@ func (svc *ServiceContext) GetAccessions(c *gin.Context) {
 // GetAccessionDetail is an admin API call that returns the full detail of an accession
 func (svc *ServiceContext) GetAccessionDetail(c *gin.Context) {
 	ID := c.Param("id")
-	q := svc.DB.NewQuery("select * from accessions where id={:id}")
-	q.Bind((dbx.Params{"id": ID}))
-	var accession Accession
-	err := q.One(&accession)
+	accession, err := LoadAccession(svc.DB, ID)
 	if err != nil {
 		log.Printf("ERROR: Unable to get accession %s: %s", ID, err.Error())
+		c.String(http.StatusNotFound, "accession %s not found", ID)
 		return
 	}
-	accession.GetGenres(svc.DB)
-	accession.GetDigitalTransferDetail(svc.DB)
-	accession.GetPhysicalTransferDetail(svc.DB)
+	accession.AccrualChain = accession.GetAccrualChain(svc.DB)
+	accession.Attachments = accession.GetAttachments(svc.DB)
+	accession.Assignments = accession.GetAssignments(svc.DB)
+	accession.StatusHistory = accession.GetStatusHistory(svc.DB)
+	accession.Events = accession.GetEvents(svc.DB)
 
 	c.JSON(http.StatusOK, accession)
 }
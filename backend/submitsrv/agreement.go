@@ -0,0 +1,204 @@
+package main
+
+import (
+	"database/sql"
+	"log"
+	"net/http"
+	"time"
+
+	"github.com/gin-gonic/gin"
+	dbx "github.com/go-ozzo/ozzo-dbx"
+)
+
+// Agreement is one version of the transfer agreement / deed of gift text. Agreements
+// are never edited once created; a change in the text is a new version
+type Agreement struct {
+	ID          int       `json:"id" db:"id"`
+	Version     string    `json:"version" db:"version" binding:"required"`
+	Title       string    `json:"title" db:"title" binding:"required"`
+	Body        string    `json:"body" db:"body" binding:"required"`
+	EffectiveAt time.Time `json:"effectiveAt" db:"effective_at"`
+	CreatedBy   *int      `json:"-" db:"created_by"`
+	CreatedAt   time.Time `json:"createdAt" db:"created_at"`
+}
+
+// TableName defines the expected DB table name that holds data for agreements
+func (ag *Agreement) TableName() string {
+	return "agreements"
+}
+
+// AcceptedAgreement records the acceptance of an agreement version for an accession
+type AcceptedAgreement struct {
+	ID          int       `json:"-" db:"id"`
+	AccessionID int       `json:"-" db:"accession_id"`
+	AgreementID int       `json:"agreementID" db:"agreement_id"`
+	UserID      int       `json:"userID" db:"user_id"`
+	IPAddress   string    `json:"ipAddress" db:"ip_address"`
+	AcceptedAt  time.Time `json:"acceptedAt" db:"accepted_at"`
+	Version     string    `json:"version" db:"version"`
+	Title       string    `json:"title" db:"title"`
+	Body        string    `json:"body" db:"body"`
+}
+
+// TableName defines the expected DB table name that holds data for agreement acceptance
+func (aa *AcceptedAgreement) TableName() string {
+	return "accession_agreements"
+}
+
+// GetCurrentAgreement returns the agreement version currently in effect. Returns
+// nil if no agreement has been defined
+func GetCurrentAgreement(db *dbx.DB) (*Agreement, error) {
+	var agreement Agreement
+	q := db.NewQuery(`select * from agreements where effective_at <= {:now}
+		order by effective_at desc, id desc limit 1`)
+	q.Bind(dbx.Params{"now": time.Now()})
+	err := q.One(&agreement)
+	if err != nil {
+		if err == sql.ErrNoRows {
+			return nil, nil
+		}
+		return nil, err
+	}
+	return &agreement, nil
+}
+
+// GetAgreementUser returns the verified user accepting the agreement for a submission:
+// the user signed in to the submitter session, or the verified account with the
+// submitted email address. Returns nil if there is no such user; the user in the
+// submission itself is not trusted for this
+func GetAgreementUser(db *dbx.DB, submitter *User, email string) (*User, error) {
+	if submitter != nil {
+		return submitter, nil
+	}
+	var user User
+	err := user.FindByEmail(db, email)
+	if err != nil {
+		if err == sql.ErrNoRows {
+			return nil, nil
+		}
+		return nil, err
+	}
+	if user.Verified == false {
+		return nil, nil
+	}
+	return &user, nil
+}
+
+// WriteAgreement records the acceptance of the transfer agreement by a verified user
+func (a *Accession) WriteAgreement(tx *dbx.Tx, acceptedBy *User, ipAddress string) error {
+	if a.AgreementAccepted == false || a.AgreementID == 0 {
+		return nil
+	}
+	log.Printf("Record acceptance of agreement %d for accession %d by %s from %s", a.AgreementID, a.ID, acceptedBy.Email, ipAddress)
+	_, err := tx.Insert("accession_agreements", dbx.Params{
+		"accession_id": a.ID,
+		"agreement_id": a.AgreementID,
+		"user_id":      acceptedBy.ID,
+		"ip_address":   ipAddress,
+		"accepted_at":  time.Now(),
+	}).Execute()
+	return err
+}
+
+// GetAgreement loads the agreement that was accepted for this accession, if any
+func (a *Accession) GetAgreement(db *dbx.DB) {
+	var accepted AcceptedAgreement
+	q := db.NewQuery(`select aa.*, ag.version, ag.title, ag.body from accession_agreements aa
+		inner join agreements ag on ag.id = aa.agreement_id where aa.accession_id={:id}`)
+	q.Bind(dbx.Params{"id": a.ID})
+	if q.One(&accepted) == nil {
+		a.Agreement = &accepted
+	}
+}
+
+// GetAgreement returns the transfer agreement currently in effect as JSON
+func (svc *ServiceContext) GetAgreement(c *gin.Context) {
+	agreement, err := GetCurrentAgreement(svc.DB)
+	if err != nil {
+		log.Printf("ERROR: Unable to get current agreement: %s", err.Error())
+		c.String(http.StatusInternalServerError, err.Error())
+		return
+	}
+	if agreement == nil {
+		c.String(http.StatusNotFound, "no agreement defined")
+		return
+	}
+	c.JSON(http.StatusOK, agreement)
+}
+
+// GetAgreements is an admin API call that lists the agreement versions a page at a time,
+// newest first
+func (svc *ServiceContext) GetAgreements(c *gin.Context) {
+	pr, err := svc.parsePageRequest(c)
+	if err != nil {
+		c.String(http.StatusBadRequest, err.Error())
+		return
+	}
+	params := dbx.Params{}
+	keyset := pr.keysetQS("effective_at", "id", "desc", params)
+	if keyset != "" {
+		keyset = " where " + keyset
+	}
+	q := svc.DB.NewQuery("select * from agreements" + keyset + " order by effective_at desc, id desc" + pr.limitQS())
+	q.Bind(params)
+	type AgreementsPage struct {
+		PageSize   int         `json:"pageSize"`
+		NextCursor string      `json:"nextCursor"`
+		Agreements []Agreement `json:"agreements"`
+	}
+	out := AgreementsPage{PageSize: pr.size, Agreements: make([]Agreement, 0)}
+	err = q.All(&out.Agreements)
+	if err != nil {
+		log.Printf("ERROR: Unable to get agreements: %s", err.Error())
+		c.String(http.StatusInternalServerError, err.Error())
+		return
+	}
+	if len(out.Agreements) > pr.size {
+		out.Agreements = out.Agreements[:pr.size]
+		last := out.Agreements[pr.size-1]
+		out.NextCursor = nextCursor(cursorValue(last.EffectiveAt), last.ID)
+	}
+	c.JSON(http.StatusOK, out)
+}
+
+// AddAgreement is an admin API call that adds a new agreement version. It takes effect
+// immediately unless a later effective date is provided. Accessions that accepted
+// an earlier version keep their link to it
+func (svc *ServiceContext) AddAgreement(c *gin.Context) {
+	var agreement Agreement
+	err := c.ShouldBindJSON(&agreement)
+	if err != nil {
+		c.String(http.StatusBadRequest, err.Error())
+		return
+	}
+	staff := GetAuthUser(c)
+	agreement.ID = 0
+	agreement.CreatedBy = &staff.ID
+	agreement.CreatedAt = time.Now()
+	if agreement.EffectiveAt.IsZero() {
+		agreement.EffectiveAt = agreement.CreatedAt
+	}
+
+	var count int
+	q := svc.DB.NewQuery("select count(*) from agreements where version={:version}")
+	q.Bind(dbx.Params{"version": agreement.Version})
+	err = q.Row(&count)
+	if err != nil {
+		log.Printf("ERROR: Unable to check for agreement %s: %s", agreement.Version, err.Error())
+		c.String(http.StatusInternalServerError, err.Error())
+		return
+	}
+	if count > 0 {
+		c.String(http.StatusConflict, "agreement version %s already exists", agreement.Version)
+		return
+	}
+
+	log.Printf("%s added agreement version %s", staff.Email, agreement.Version)
+	err = svc.DB.Model(&agreement).Insert()
+	if err != nil {
+		log.Printf("ERROR: Unable to add agreement %s: %s", agreement.Version, err.Error())
+		c.String(http.StatusInternalServerError, err.Error())
+		return
+	}
+	c.JSON(http.StatusOK, agreement)
+}
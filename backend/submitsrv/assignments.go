@@ -0,0 +1,228 @@
+package main
+
+import (
+	"fmt"
+	"log"
+	"net/http"
+	"time"
+
+	"github.com/gin-gonic/gin"
+	dbx "github.com/go-ozzo/ozzo-dbx"
+)
+
+// Assignment records an admin user assigned to process an accession. Assignments are
+// kept after they end, with UnassignedAt set, so they form the assignment history
+type Assignment struct {
+	ID           int        `json:"id" db:"id"`
+	AccessionID  int        `json:"-" db:"accession_id"`
+	UserID       int        `json:"userID" db:"user_id"`
+	UserName     string     `json:"userName" db:"user_name"`
+	AssignedBy   *int       `json:"assignedBy" db:"assigned_by"`
+	AssignedAt   time.Time  `json:"assignedAt" db:"assigned_at"`
+	UnassignedAt *time.Time `json:"unassignedAt" db:"unassigned_at"`
+	NotifiedAt   *time.Time `json:"-" db:"notified_at"`
+}
+
+// TableName defines the expected DB table name that holds data for assignments
+func (asg *Assignment) TableName() string {
+	return "accession_assignments"
+}
+
+// GetAssignments returns the full assignment history of an accession, oldest first
+func (a *Accession) GetAssignments(db *dbx.DB) []Assignment {
+	q := db.NewQuery(`select s.*, concat(u.first_name,' ',u.last_name) as user_name
+		from accession_assignments s inner join users u on u.id = s.user_id
+		where s.accession_id={:id} order by s.assigned_at asc, s.id asc`)
+	q.Bind(dbx.Params{"id": a.ID})
+	out := make([]Assignment, 0)
+	err := q.All(&out)
+	if err != nil {
+		log.Printf("ERROR: Unable to get assignments for accession %d: %s", a.ID, err.Error())
+	}
+	return out
+}
+
+// assignmentJob emails admins about accessions newly assigned to them
+func assignmentJob(svc *ServiceContext, accession *Accession) error {
+	var pending []Assignment
+	q := svc.DB.NewQuery(`select s.*, "" as user_name from accession_assignments s
+		where s.accession_id={:id} and s.notified_at is null and s.unassigned_at is null`)
+	q.Bind(dbx.Params{"id": accession.ID})
+	err := q.All(&pending)
+	if err != nil {
+		return err
+	}
+	for _, asg := range pending {
+		var assignee User
+		err = svc.DB.Select().Model(asg.UserID, &assignee)
+		if err != nil {
+			return err
+		}
+		data := struct {
+			Accession *Accession
+			Assignee  User
+			URL       string
+		}{Accession: accession, Assignee: assignee,
+			URL: fmt.Sprintf("https://%s/admin/accessions/%d", svc.Hostname, accession.ID)}
+		body, err := RenderEmailTemplate("assignment_email.html", data)
+		if err != nil {
+			log.Printf("ERROR: Unable to render assignment email: %s", err.Error())
+			return err
+		}
+		subject := fmt.Sprintf("UVA Archives Transfer %s Assigned to You", accession.AccessionNumber)
+		err = svc.SMTP.SendEmail(EmailRequest{Subject: subject, To: []string{assignee.Email}, Body: body})
+		if err != nil {
+			return err
+		}
+		_, err = svc.DB.Update("accession_assignments", dbx.Params{"notified_at": time.Now()},
+			dbx.HashExp{"id": asg.ID}).Execute()
+		if err != nil {
+			return err
+		}
+	}
+	return nil
+}
+
+// AddAssignment is an admin API call that assigns an admin user to an accession
+func (svc *ServiceContext) AddAssignment(c *gin.Context) {
+	accessionID := c.Param("id")
+	var req struct {
+		UserID int `json:"userID" binding:"required"`
+	}
+	err := c.ShouldBindJSON(&req)
+	if err != nil {
+		c.String(http.StatusBadRequest, err.Error())
+		return
+	}
+	accession, err := LoadAccession(svc.DB, accessionID)
+	if err != nil {
+		c.String(http.StatusNotFound, "accession %s not found", accessionID)
+		return
+	}
+	var assignee User
+	err = svc.DB.Select().Model(req.UserID, &assignee)
+	if err != nil || assignee.Admin == false {
+		c.String(http.StatusBadRequest, "user %d is not an admin", req.UserID)
+		return
+	}
+	for _, asg := range accession.GetAssignments(svc.DB) {
+		if asg.UserID == req.UserID && asg.UnassignedAt == nil {
+			c.String(http.StatusBadRequest, "%s is already assigned", assignee.FullName())
+			return
+		}
+	}
+
+	staff := GetAuthUser(c)
+	tx, _ := svc.DB.Begin()
+	_, err = tx.Insert("accession_assignments", dbx.Params{
+		"accession_id": accession.ID,
+		"user_id":      assignee.ID,
+		"assigned_by":  staff.ID,
+		"assigned_at":  time.Now(),
+	}).Execute()
+	if err == nil {
+		err = EnqueueJob(tx, accession.ID, "assignment")
+	}
+	if err != nil {
+		log.Printf("ERROR: Unable to assign accession %d: %s", accession.ID, err.Error())
+		tx.Rollback()
+		c.String(http.StatusInternalServerError, err.Error())
+		return
+	}
+	LogEvent(tx, accession.ID, staff, "assignment", fmt.Sprintf("Assigned to %s", assignee.FullName()))
+	tx.Commit()
+	log.Printf("%s assigned accession %d to %s", staff.Email, accession.ID, assignee.Email)
+	c.JSON(http.StatusOK, accession.GetAssignments(svc.DB))
+}
+
+// DeleteAssignment is an admin API call that ends the assignment of a user to an accession
+func (svc *ServiceContext) DeleteAssignment(c *gin.Context) {
+	accessionID := c.Param("id")
+	userID := c.Param("user")
+	accession, err := LoadAccession(svc.DB, accessionID)
+	if err != nil {
+		c.String(http.StatusNotFound, "accession %s not found", accessionID)
+		return
+	}
+	var assignee User
+	err = svc.DB.Select().Model(userID, &assignee)
+	if err != nil {
+		c.String(http.StatusNotFound, "user %s not found", userID)
+		return
+	}
+	staff := GetAuthUser(c)
+	tx, _ := svc.DB.Begin()
+	q := tx.NewQuery(`update accession_assignments set unassigned_at={:now}
+		where accession_id={:id} and user_id={:user} and unassigned_at is null`)
+	q.Bind(dbx.Params{"now": time.Now(), "id": accession.ID, "user": assignee.ID})
+	res, err := q.Execute()
+	if err != nil {
+		log.Printf("ERROR: Unable to unassign accession %d: %s", accession.ID, err.Error())
+		tx.Rollback()
+		c.String(http.StatusInternalServerError, err.Error())
+		return
+	}
+	if cnt, _ := res.RowsAffected(); cnt == 0 {
+		tx.Rollback()
+		c.String(http.StatusNotFound, "%s is not assigned", assignee.FullName())
+		return
+	}
+	LogEvent(tx, accession.ID, staff, "assignment", fmt.Sprintf("Unassigned %s", assignee.FullName()))
+	tx.Commit()
+	log.Printf("%s unassigned %s from accession %d", staff.Email, assignee.Email, accession.ID)
+	c.JSON(http.StatusOK, accession.GetAssignments(svc.DB))
+}
+
+// GetWorkload is an admin API call that counts the open accessions assigned to each
+// admin by status. Accessions with no current assignee are counted under unassigned
+func (svc *ServiceContext) GetWorkload(c *gin.Context) {
+	type Workload struct {
+		UserID   *int           `json:"userID"`
+		UserName string         `json:"userName"`
+		Total    int            `json:"total"`
+		ByStatus map[string]int `json:"byStatus"`
+	}
+	var rows []struct {
+		UserID   *int   `db:"user_id"`
+		UserName string `db:"user_name"`
+		Status   string `db:"status"`
+		Total    int    `db:"total"`
+	}
+	q := svc.DB.NewQuery(`select u.id as user_id, concat(u.first_name,' ',u.last_name) as user_name,
+			coalesce(a.status, "") as status, count(a.id) as total
+		from users u
+			left outer join accession_assignments s on s.user_id = u.id and s.unassigned_at is null
+			left outer join accessions a on a.id = s.accession_id and a.status != "completed"
+		where u.admin = 1 group by u.id, a.status
+		union all
+		select null, "Unassigned", a.status, count(*) from accessions a
+		where a.status != "completed" and not exists (select 1 from accession_assignments s
+			where s.accession_id = a.id and s.unassigned_at is null)
+		group by a.status`)
+	err := q.All(&rows)
+	if err != nil {
+		log.Printf("ERROR: Unable to get workload: %s", err.Error())
+		c.String(http.StatusInternalServerError, err.Error())
+		return
+	}
+
+	out := make([]*Workload, 0)
+	byName := make(map[string]*Workload)
+	for _, row := range rows {
+		key := row.UserName
+		if row.UserID != nil {
+			key = fmt.Sprintf("%d", *row.UserID)
+		}
+		wl, ok := byName[key]
+		if ok == false {
+			wl = &Workload{UserID: row.UserID, UserName: row.UserName, ByStatus: make(map[string]int)}
+			byName[key] = wl
+			out = append(out, wl)
+		}
+		if row.Status != "" {
+			wl.ByStatus[row.Status] = row.Total
+			wl.Total += row.Total
+		}
+	}
+	c.JSON(http.StatusOK, out)
+}
@@ -0,0 +1,209 @@
+package main
+
+import (
+	"fmt"
+	"io"
+	"log"
+	"net/http"
+	"os"
+	"path/filepath"
+	"strings"
+	"time"
+
+	"github.com/gin-gonic/gin"
+	dbx "github.com/go-ozzo/ozzo-dbx"
+)
+
+// Attachment is a supplemental document that staff have attached to an accession, such
+// as correspondence or an appraisal report. Attachments are stored apart from the
+// transferred files in the attachments area of the upload directory
+type Attachment struct {
+	ID           int       `json:"id" db:"id"`
+	AccessionID  int       `json:"-" db:"accession_id"`
+	Title        string    `json:"title" db:"title"`
+	Type         string    `json:"type" db:"attachment_type"`
+	Filename     string    `json:"filename" db:"filename"`
+	ContentType  string    `json:"contentType" db:"content_type"`
+	Size         int64     `json:"size" db:"size"`
+	UploadedBy   int       `json:"uploadedBy" db:"uploaded_by"`
+	UploaderName string    `json:"uploaderName" db:"uploader_name"`
+	CreatedAt    time.Time `json:"createdAt" db:"created_at"`
+}
+
+// TableName defines the expected DB table name that holds data for attachments
+func (att *Attachment) TableName() string {
+	return "accession_attachments"
+}
+
+// IsAttachmentType returns true if the type is a supported attachment type
+func IsAttachmentType(attType string) bool {
+	switch attType {
+	case "correspondence", "transfer_form", "appraisal", "other":
+		return true
+	}
+	return false
+}
+
+// attachmentDir returns the directory that holds the attachments of an accession
+func (svc *ServiceContext) attachmentDir(a *Accession) string {
+	return filepath.Join(svc.UploadDir, "attachments", a.Identifier)
+}
+
+// GetAttachments returns the supplemental attachments of an accession
+func (a *Accession) GetAttachments(db *dbx.DB) []Attachment {
+	q := db.NewQuery(`select t.*, concat(u.first_name,' ',u.last_name) as uploader_name
+		from accession_attachments t inner join users u on u.id = t.uploaded_by
+		where t.accession_id={:id} order by t.created_at asc`)
+	q.Bind(dbx.Params{"id": a.ID})
+	out := make([]Attachment, 0)
+	err := q.All(&out)
+	if err != nil {
+		log.Printf("ERROR: Unable to get attachments for accession %d: %s", a.ID, err.Error())
+	}
+	return out
+}
+
+// getAttachment loads an accession and one of its attachments from the request params.
+// An error response is sent and nil returned if either is not found
+func (svc *ServiceContext) getAttachment(c *gin.Context) (*Accession, *Attachment) {
+	accessionID := c.Param("id")
+	accession, err := LoadAccession(svc.DB, accessionID)
+	if err != nil {
+		c.String(http.StatusNotFound, "accession %s not found", accessionID)
+		return nil, nil
+	}
+	var att Attachment
+	q := svc.DB.NewQuery(`select t.*, "" as uploader_name from accession_attachments t
+		where t.id={:att} and t.accession_id={:id}`)
+	q.Bind(dbx.Params{"att": c.Param("attachment"), "id": accession.ID})
+	err = q.One(&att)
+	if err != nil {
+		c.String(http.StatusNotFound, "attachment %s not found", c.Param("attachment"))
+		return nil, nil
+	}
+	return accession, &att
+}
+
+// AddAttachment is an admin API call that uploads a supplemental document to an
+// accession. The multipart form has the file along with its title and type
+func (svc *ServiceContext) AddAttachment(c *gin.Context) {
+	accessionID := c.Param("id")
+	accession, err := LoadAccession(svc.DB, accessionID)
+	if err != nil {
+		c.String(http.StatusNotFound, "accession %s not found", accessionID)
+		return
+	}
+	title := strings.TrimSpace(c.PostForm("title"))
+	attType := c.DefaultPostForm("type", "other")
+	if title == "" {
+		c.String(http.StatusBadRequest, "title is required")
+		return
+	}
+	if IsAttachmentType(attType) == false {
+		c.String(http.StatusBadRequest, "invalid attachment type %s", attType)
+		return
+	}
+	file, err := c.FormFile("file")
+	if err != nil {
+		c.String(http.StatusBadRequest, "a file is required: %s", err.Error())
+		return
+	}
+
+	src, err := file.Open()
+	if err != nil {
+		c.String(http.StatusBadRequest, "unable to read %s: %s", file.Filename, err.Error())
+		return
+	}
+	contentType := sniffContentType(src)
+	src.Close()
+
+	staff := GetAuthUser(c)
+	att := Attachment{AccessionID: accession.ID, Title: title, Type: attType,
+		Filename: filepath.Base(file.Filename), ContentType: contentType,
+		Size: file.Size, UploadedBy: staff.ID, UploaderName: staff.FullName(), CreatedAt: time.Now()}
+
+	// the record is written first so its ID can keep stored file names unique
+	tx, _ := svc.DB.Begin()
+	err = tx.Model(&att).Exclude("UploaderName").Insert()
+	if err != nil {
+		log.Printf("ERROR: Unable to add attachment to accession %d: %s", accession.ID, err.Error())
+		tx.Rollback()
+		c.String(http.StatusInternalServerError, err.Error())
+		return
+	}
+	destDir := svc.attachmentDir(accession)
+	os.MkdirAll(destDir, 0777)
+	dest := filepath.Join(destDir, fmt.Sprintf("%d-%s", att.ID, att.Filename))
+	err = c.SaveUploadedFile(file, dest)
+	if err != nil {
+		log.Printf("ERROR: Unable to save attachment %s: %s", dest, err.Error())
+		tx.Rollback()
+		c.String(http.StatusInternalServerError, err.Error())
+		return
+	}
+	LogEvent(tx, accession.ID, staff, "attachment", fmt.Sprintf("Attached %s: %s", att.Type, att.Title))
+	if err := EnqueueJob(tx, accession.ID, "index"); err != nil {
+		log.Printf("ERROR: Unable to queue index job for accession %d: %s", accession.ID, err.Error())
+	}
+	tx.Commit()
+	log.Printf("%s attached %s to accession %d", staff.Email, dest, accession.ID)
+	c.JSON(http.StatusOK, att)
+}
+
+// GetAttachment is an admin API call that downloads an attachment
+func (svc *ServiceContext) GetAttachment(c *gin.Context) {
+	accession, att := svc.getAttachment(c)
+	if att == nil {
+		return
+	}
+	src := filepath.Join(svc.attachmentDir(accession), fmt.Sprintf("%d-%s", att.ID, att.Filename))
+	file, err := os.Open(src)
+	if err != nil {
+		log.Printf("ERROR: Attachment file %s not found: %s", src, err.Error())
+		c.String(http.StatusNotFound, "attachment file %s not found", att.Filename)
+		return
+	}
+	contentType := sniffContentType(file)
+	file.Close()
+
+	// the type is detected from the file itself and the file is always downloaded, so an
+	// uploaded HTML or SVG file is never rendered by the browser
+	c.Header("Content-Type", contentType)
+	c.Header("X-Content-Type-Options", "nosniff")
+	c.FileAttachment(src, att.Filename)
+}
+
+// sniffContentType detects the content type of a file from its first bytes
+func sniffContentType(file io.Reader) string {
+	head := make([]byte, 512)
+	n, _ := io.ReadFull(file, head)
+	return http.DetectContentType(head[:n])
+}
+
+// DeleteAttachment is an admin API call that removes an attachment and its file
+func (svc *ServiceContext) DeleteAttachment(c *gin.Context) {
+	accession, att := svc.getAttachment(c)
+	if att == nil {
+		return
+	}
+	staff := GetAuthUser(c)
+	tx, _ := svc.DB.Begin()
+	_, err := tx.Delete("accession_attachments", dbx.HashExp{"id": att.ID}).Execute()
+	if err != nil {
+		log.Printf("ERROR: Unable to delete attachment %d: %s", att.ID, err.Error())
+		tx.Rollback()
+		c.String(http.StatusInternalServerError, err.Error())
+		return
+	}
+	LogEvent(tx, accession.ID, staff, "attachment", fmt.Sprintf("Removed %s: %s", att.Type, att.Title))
+	if err := EnqueueJob(tx, accession.ID, "index"); err != nil {
+		log.Printf("ERROR: Unable to queue index job for accession %d: %s", accession.ID, err.Error())
+	}
+	tx.Commit()
+	src := filepath.Join(svc.attachmentDir(accession), fmt.Sprintf("%d-%s", att.ID, att.Filename))
+	if err := os.Remove(src); err != nil {
+		log.Printf("WARN: Unable to remove attachment file %s: %s", src, err.Error())
+	}
+	log.Printf("%s removed attachment %d from accession %d", staff.Email, att.ID, accession.ID)
+	c.String(http.StatusOK, "deleted")
+}
@@ -43,7 +43,9 @@ func (svc *ServiceContext) Authenticate(c *gin.Context) {
 	log.Printf("Authentication successful for %s", computingID)
 	json, _ := json.Marshal(user)
 
-	if strings.Index(tgtURL, "submit") == -1 {
+	if strings.Index(tgtURL, "submit") != -1 || strings.Index(tgtURL, "transfers") != -1 {
+		svc.startSubmitterSession(c, &user)
+	} else {
 		log.Printf("Adding API Access token to user")
 		user.APIToken = xid.New().String()
 		svc.DB.Model(&user).Update("APIToken")
@@ -64,30 +66,85 @@ func (svc *ServiceContext) Authenticate(c *gin.Context) {
 	c.Redirect(http.StatusFound, tgtURL)
 }
 
+// submitterSessionCookie holds the session of a signed in submitter or records owner.
+// It is kept apart from the admin session so that signing in to submit records never
+// grants access to the admin API
+const submitterSessionCookie = "archives_xfer_user_session"
+
+// startSubmitterSession signs a submitter in once they have proven who they are, either
+// through NetBadge or by following the link in their verification email. The session
+// uses a submit token of its own; the API token is only ever used by admin sessions
+func (svc *ServiceContext) startSubmitterSession(c *gin.Context, user *User) {
+	log.Printf("Adding submit token to user")
+	user.SubmitToken = xid.New().String()
+	svc.DB.Model(user).Update("SubmitToken")
+	sess := fmt.Sprintf("%s|%s", user.SubmitToken, user.Email)
+	c.SetCookie(submitterSessionCookie, sess, 0, "/", "", svc.DevAuthUser == "", true)
+}
+
 // AuthMiddleware sits in front of all admin API calls and makes the auth token generated
-// by the shibboleth-fronted authenticate handler is present and valid
+// by the shibboleth-fronted authenticate handler is present and valid for an admin user
 func (svc *ServiceContext) AuthMiddleware(c *gin.Context) {
-	log.Printf("AuthMiddleware is checking for access cookie")
-	cookieStr, err := c.Cookie("archives_xfer_api_session")
+	user, err := svc.sessionUser(c, "archives_xfer_api_session")
+	if err == nil && user.Admin == false {
+		err = fmt.Errorf("%s is not an admin", user.Email)
+	}
+	svc.checkSession(c, user, err)
+}
+
+// SubmitterMiddleware sits in front of the API calls for a signed in submitter and makes
+// sure their session is present and valid
+func (svc *ServiceContext) SubmitterMiddleware(c *gin.Context) {
+	user, err := svc.sessionUser(c, submitterSessionCookie)
+	svc.checkSession(c, user, err)
+}
+
+// checkSession authorizes the request for the session user, or rejects it if the
+// session could not be verified
+func (svc *ServiceContext) checkSession(c *gin.Context, user *User, err error) {
 	if err != nil {
-		log.Printf("ERROR: unable to retrieve access cookie")
+		log.Printf("%s. Not authorized.", err.Error())
 		c.AbortWithStatus(http.StatusForbidden)
 		return
 	}
-	log.Printf("AuthMiddleware found cookie %s; verifying", cookieStr)
+	log.Printf("User %s is authorized for %s", user.Email, c.Request.RequestURI)
+	c.Set("user", user)
+	c.Next()
+}
+
+// sessionUser returns the user whose email and token are held in the named session
+// cookie. Admin sessions are matched on the API token, submitter sessions on the
+// submit token
+func (svc *ServiceContext) sessionUser(c *gin.Context, cookieName string) (*User, error) {
+	log.Printf("Checking for access cookie %s", cookieName)
+	cookieStr, err := c.Cookie(cookieName)
+	if err != nil {
+		return nil, fmt.Errorf("Unable to retrieve access cookie %s", cookieName)
+	}
+	parts := strings.Split(cookieStr, "|")
+	if parts[0] == "" {
+		return nil, fmt.Errorf("Access cookie %s has no token", cookieName)
+	}
 	user := User{}
-	err = user.FindByAPIToken(svc.DB, strings.Split(cookieStr, "|")[0])
+	if cookieName == submitterSessionCookie {
+		err = user.FindBySubmitToken(svc.DB, parts[0])
+	} else {
+		err = user.FindByAPIToken(svc.DB, parts[0])
+	}
 	if err != nil {
-		log.Printf("No user record found for token. Not authorized.")
-		c.AbortWithStatus(http.StatusForbidden)
-		return
+		return nil, fmt.Errorf("No user record found for token")
 	}
-
-	if user.Email != strings.Split(cookieStr, "|")[1] {
-		log.Printf("Email / token mismatch. Not authorized.")
-		c.AbortWithStatus(http.StatusForbidden)
+	if len(parts) < 2 || user.Email != parts[1] {
+		return nil, fmt.Errorf("Email / token mismatch")
 	}
+	return &user, nil
+}
 
-	log.Printf("User %s is authorized for %s", user.Email, c.Request.RequestURI)
-	c.Next()
+// GetAuthUser returns the user that was authorized by AuthMiddleware or SubmitterMiddleware
+func GetAuthUser(c *gin.Context) *User {
+	val, ok := c.Get("user")
+	if ok == false {
+		return nil
+	}
+	return val.(*User)
 }
@@ -17,15 +17,19 @@ type SMTPConfig struct {
 
 // ServiceConfig defines all of the archives transfer service configuration paramaters
 type ServiceConfig struct {
-	DBHost      string
-	DBName      string
-	DBUser      string
-	DBPass      string
-	Port        int
-	UploadDir   string
-	DevAuthUser string
-	Hostname    string
-	SMTP        SMTPConfig
+	DBHost                string
+	DBName                string
+	DBUser                string
+	DBPass                string
+	Port                  int
+	UploadDir             string
+	DevAuthUser           string
+	Hostname              string
+	SMTP                  SMTPConfig
+	JobWorkers            int
+	ScanCommand           string
+	AccessionNumberFormat string
+	MaxPageSize           int
 }
 
 // Load will load the service configuration from env/cmdline
@@ -47,6 +51,10 @@ func (cfg *ServiceConfig) Load() {
 	flag.StringVar(&cfg.Hostname, "host", "transfer-archives.lib.virginia.edu", "Transfer Service Hostname")
 	flag.IntVar(&cfg.Port, "port", 8080, "Service port (default 8080)")
 	flag.StringVar(&cfg.UploadDir, "upload", "./uploads", "Upload directory")
+	flag.IntVar(&cfg.JobWorkers, "workers", 2, "Number of post-submit job workers")
+	flag.StringVar(&cfg.ScanCommand, "scancmd", "", "Virus scan command, such as clamscan (disabled if blank)")
+	flag.StringVar(&cfg.AccessionNumberFormat, "accnum", "UA-{YYYY}-{NNNN}", "Accession number pattern")
+	flag.IntVar(&cfg.MaxPageSize, "maxpage", 200, "Largest page size of admin lists")
 
 	flag.Parse()
 	log.Printf("%#v", cfg)
@@ -0,0 +1,131 @@
+package main
+
+import (
+	"bytes"
+	"encoding/base64"
+	"fmt"
+	"html/template"
+	"log"
+	"net/smtp"
+	"strings"
+
+	dbx "github.com/go-ozzo/ozzo-dbx"
+	"github.com/rs/xid"
+)
+
+// EmailAttachment is a file attached to an email message
+type EmailAttachment struct {
+	Name        string
+	ContentType string
+	Data        []byte
+}
+
+// EmailRequest describes an HTML email message. Recipients in To are listed in
+// the message header; recipients in BCC get a copy but are not listed
+type EmailRequest struct {
+	Subject     string
+	To          []string
+	BCC         []string
+	Body        string
+	Attachments []EmailAttachment
+}
+
+// RenderEmailTemplate renders the named template from the templates directory
+func RenderEmailTemplate(name string, data interface{}) (string, error) {
+	log.Printf("Rendering %s email body", name)
+	tpl, err := template.ParseFiles(fmt.Sprintf("templates/%s", name))
+	if err != nil {
+		return "", err
+	}
+	var renderedEmail bytes.Buffer
+	err = tpl.Execute(&renderedEmail, data)
+	if err != nil {
+		return "", err
+	}
+	return renderedEmail.String(), nil
+}
+
+// GetAdminEmails returns the email addresses of all admin users
+func GetAdminEmails(db *dbx.DB) []string {
+	out := make([]string, 0)
+	q := db.NewQuery(`select email from users where admin=1`)
+	rows, err := q.Rows()
+	if err != nil {
+		log.Printf("ERROR: Unable to get admin emails: %s", err.Error())
+		return out
+	}
+	for rows.Next() {
+		var email string
+		rows.Scan(&email)
+		out = append(out, email)
+	}
+	return out
+}
+
+// SendEmail sends an email request, or logs it if SMTP is in dev mode
+func (smtpCfg *SMTPConfig) SendEmail(req EmailRequest) error {
+	log.Printf("Generate SMTP message")
+	subject := fmt.Sprintf("Subject: %s\n", req.Subject)
+	toHdr := fmt.Sprintf("To: %s\n", strings.Join(req.To, ","))
+	var msg []byte
+	if len(req.Attachments) == 0 {
+		mime := "MIME-version: 1.0;\nContent-Type: text/html; charset=\"UTF-8\";\n\n"
+		msg = []byte(subject + toHdr + mime + req.Body)
+	} else {
+		msg = []byte(subject + toHdr + req.multipartBody())
+	}
+
+	if smtpCfg.DevMode {
+		log.Printf("Email is in dev mode. Logging message instead of sending")
+		log.Printf("==================================================")
+		if len(req.Attachments) > 0 {
+			// attachments are base64 blobs; no point in logging them
+			log.Printf("%s%s", subject+toHdr, req.Body)
+			for _, att := range req.Attachments {
+				log.Printf("[attachment %s, %d bytes]", att.Name, len(att.Data))
+			}
+		} else {
+			log.Printf("%s", msg)
+		}
+		log.Printf("==================================================")
+		return nil
+	}
+
+	// NOTES: per docs, to make a recipient BCC'd, include them in the to
+	// param in the SendMail call, but omit them in the message above.
+	to := append(append([]string{}, req.To...), req.BCC...)
+	log.Printf("Sending %s email to %s", req.Subject, strings.Join(to, ","))
+	err := smtp.SendMail(fmt.Sprintf("%s:%d", smtpCfg.Host, smtpCfg.Port), nil, "no-reply@virginia.edu", to, msg)
+	if err != nil {
+		log.Printf("ERROR: Unable to send %s email: %s", req.Subject, err.Error())
+		return err
+	}
+	return nil
+}
+
+// multipartBody generates a multipart/mixed MIME body containing the HTML message
+// followed by each of the attachments
+func (req *EmailRequest) multipartBody() string {
+	boundary := fmt.Sprintf("archives-%s", xid.New().String())
+	var out strings.Builder
+	out.WriteString("MIME-version: 1.0;\n")
+	out.WriteString(fmt.Sprintf("Content-Type: multipart/mixed; boundary=\"%s\"\n\n", boundary))
+	out.WriteString(fmt.Sprintf("--%s\n", boundary))
+	out.WriteString("Content-Type: text/html; charset=\"UTF-8\"\n\n")
+	out.WriteString(req.Body)
+	out.WriteString("\n")
+	for _, att := range req.Attachments {
+		out.WriteString(fmt.Sprintf("--%s\n", boundary))
+		out.WriteString(fmt.Sprintf("Content-Type: %s; name=\"%s\"\n", att.ContentType, att.Name))
+		out.WriteString("Content-Transfer-Encoding: base64\n")
+		out.WriteString(fmt.Sprintf("Content-Disposition: attachment; filename=\"%s\"\n\n", att.Name))
+		encoded := base64.StdEncoding.EncodeToString(att.Data)
+		for len(encoded) > 76 {
+			out.WriteString(encoded[:76] + "\n")
+			encoded = encoded[76:]
+		}
+		out.WriteString(encoded + "\n")
+	}
+	out.WriteString(fmt.Sprintf("--%s--\n", boundary))
+	return out.String()
+}
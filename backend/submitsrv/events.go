@@ -0,0 +1,71 @@
+package main
+
+import (
+	"log"
+	"net/http"
+	"time"
+
+	"github.com/gin-gonic/gin"
+	dbx "github.com/go-ozzo/ozzo-dbx"
+)
+
+// AccessionEvent is one entry in the activity history of an accession
+type AccessionEvent struct {
+	ID          int       `json:"id" db:"id"`
+	AccessionID int       `json:"-" db:"accession_id"`
+	UserID      *int      `json:"userID" db:"user_id"`
+	UserName    string    `json:"userName" db:"user_name"`
+	Type        string    `json:"type" db:"event_type"`
+	Description string    `json:"description" db:"description"`
+	CreatedAt   time.Time `json:"createdAt" db:"created_at"`
+}
+
+// TableName defines the expected DB table name that holds data for accession events
+func (e *AccessionEvent) TableName() string {
+	return "accession_events"
+}
+
+// LogEvent adds an entry to the activity history of an accession. It accepts either a
+// DB or a transaction so the event can be written with the change it describes. A
+// nil user is a change made by the system or the submitter. Failures are only logged
+func LogEvent(db dbx.Builder, accessionID int, user *User, eventType string, description string) {
+	var userID *int
+	if user != nil {
+		userID = &user.ID
+	}
+	_, err := db.Insert("accession_events", dbx.Params{
+		"accession_id": accessionID,
+		"user_id":      userID,
+		"event_type":   eventType,
+		"description":  description,
+		"created_at":   time.Now(),
+	}).Execute()
+	if err != nil {
+		log.Printf("ERROR: Unable to log %s event for accession %d: %s", eventType, accessionID, err.Error())
+	}
+}
+
+// GetEvents returns the activity history of an accession, oldest first
+func (a *Accession) GetEvents(db *dbx.DB) []AccessionEvent {
+	q := db.NewQuery(`select e.*, coalesce(concat(u.first_name,' ',u.last_name), "") as user_name
+		from accession_events e left outer join users u on u.id = e.user_id
+		where e.accession_id={:id} order by e.created_at asc, e.id asc`)
+	q.Bind(dbx.Params{"id": a.ID})
+	out := make([]AccessionEvent, 0)
+	err := q.All(&out)
+	if err != nil {
+		log.Printf("ERROR: Unable to get events for accession %d: %s", a.ID, err.Error())
+	}
+	return out
+}
+
+// GetAccessionHistory is an admin API call that returns the activity history of an accession
+func (svc *ServiceContext) GetAccessionHistory(c *gin.Context) {
+	accessionID := c.Param("id")
+	accession, err := LoadAccession(svc.DB, accessionID)
+	if err != nil {
+		c.String(http.StatusNotFound, "accession %s not found", accessionID)
+		return
+	}
+	c.JSON(http.StatusOK, accession.GetEvents(svc.DB))
+}
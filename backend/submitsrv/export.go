@@ -0,0 +1,215 @@
+package main
+
+import (
+	"encoding/csv"
+	"fmt"
+	"log"
+	"net/http"
+	"strings"
+	"time"
+
+	"github.com/gin-gonic/gin"
+	"github.com/xuri/excelize/v2"
+)
+
+// exportWriter writes rows of a spreadsheet export to the response
+type exportWriter interface {
+	WriteRow(values []interface{}) error
+	Close() error
+}
+
+// csvExport writes CSV rows directly to the response as they are produced
+type csvExport struct {
+	c      *gin.Context
+	writer *csv.Writer
+	rows   int
+}
+
+func (ce *csvExport) WriteRow(values []interface{}) error {
+	record := make([]string, len(values))
+	for idx, val := range values {
+		record[idx] = csvCell(val)
+	}
+	ce.rows++
+	err := ce.writer.Write(record)
+	if ce.rows%500 == 0 {
+		ce.writer.Flush()
+		ce.c.Writer.Flush()
+	}
+	return err
+}
+
+func (ce *csvExport) Close() error {
+	ce.writer.Flush()
+	return ce.writer.Error()
+}
+
+// xlsxExport writes rows with the excelize stream writer so large exports are not
+// held in memory cell by cell. The workbook is sent when it is closed
+type xlsxExport struct {
+	c      *gin.Context
+	wb     *excelize.File
+	stream *excelize.StreamWriter
+	row    int
+}
+
+func (xe *xlsxExport) WriteRow(values []interface{}) error {
+	xe.row++
+	cell, _ := excelize.CoordinatesToCellName(1, xe.row)
+	row := make([]interface{}, len(values))
+	for idx, val := range values {
+		switch v := val.(type) {
+		case *time.Time:
+			row[idx] = exportCell(v)
+		default:
+			row[idx] = v
+		}
+	}
+	return xe.stream.SetRow(cell, row)
+}
+
+func (xe *xlsxExport) Close() error {
+	defer xe.wb.Close()
+	err := xe.stream.Flush()
+	if err != nil {
+		return err
+	}
+	return xe.wb.Write(xe.c.Writer)
+}
+
+// exportCell converts an export value to the text written to a CSV cell
+func exportCell(val interface{}) string {
+	switch v := val.(type) {
+	case time.Time:
+		return v.Format("2006-01-02 15:04:05")
+	case *time.Time:
+		if v == nil {
+			return ""
+		}
+		return v.Format("2006-01-02")
+	case bool:
+		if v {
+			return "Yes"
+		}
+		return "No"
+	}
+	return fmt.Sprintf("%v", val)
+}
+
+// csvCell formats a CSV cell value. Text that a spreadsheet would run as a formula is
+// prefixed with a quote so that submitted values open as plain text
+func csvCell(val interface{}) string {
+	out := exportCell(val)
+	switch val.(type) {
+	case int, int64, float64:
+		return out
+	}
+	if out != "" && strings.ContainsRune("=+-@\t\r", rune(out[0])) {
+		return "'" + out
+	}
+	return out
+}
+
+// newExportWriter sets the download headers for an export named filename in the format
+// requested by the format query param (csv, the default, or xlsx) and writes the
+// header row. An error response is sent and nil returned for an unknown format
+func newExportWriter(c *gin.Context, filename string, header []string) exportWriter {
+	headerRow := make([]interface{}, len(header))
+	for idx, name := range header {
+		headerRow[idx] = name
+	}
+
+	var out exportWriter
+	switch c.DefaultQuery("format", "csv") {
+	case "csv":
+		c.Header("Content-Type", "text/csv")
+		c.Header("Content-Disposition", fmt.Sprintf(`attachment; filename="%s.csv"`, filename))
+		out = &csvExport{c: c, writer: csv.NewWriter(c.Writer)}
+	case "xlsx":
+		wb := excelize.NewFile()
+		stream, err := wb.NewStreamWriter(wb.GetSheetName(0))
+		if err != nil {
+			log.Printf("ERROR: Unable to create export workbook: %s", err.Error())
+			c.String(http.StatusInternalServerError, err.Error())
+			return nil
+		}
+		c.Header("Content-Type", "application/vnd.openxmlformats-officedocument.spreadsheetml.sheet")
+		c.Header("Content-Disposition", fmt.Sprintf(`attachment; filename="%s.xlsx"`, filename))
+		out = &xlsxExport{c: c, wb: wb, stream: stream}
+	default:
+		c.String(http.StatusBadRequest, "unsupported export format %s", c.Query("format"))
+		return nil
+	}
+	c.Status(http.StatusOK)
+	out.WriteRow(headerRow)
+	return out
+}
+
+// ExportAccessions is an admin API call that exports every accession matching the
+// current list filters and sort, without paging
+func (svc *ServiceContext) ExportAccessions(c *gin.Context) {
+	aq, err := svc.listAccessionQuery(c)
+	if err != nil {
+		svc.accessionQueryError(c, err)
+		return
+	}
+	q := svc.DB.NewQuery(aq.listQS())
+	q.Bind(aq.params)
+	rows, err := q.Rows()
+	if err != nil {
+		log.Printf("ERROR: Unable to export accessions: %s", err.Error())
+		c.String(http.StatusInternalServerError, err.Error())
+		return
+	}
+	defer rows.Close()
+
+	out := newExportWriter(c, "accessions", []string{"Accession Number", "Identifier", "Submitted", "Submitter",
+		"Records Owner", "Unit", "Type", "Status", "Assigned To", "Description", "Genres", "Digital", "Physical",
+		"Notes", "Accruals", "Restriction", "Restriction Status", "Restricted Until", "Retention Schedule",
+		"Disposition Date", "Due Date", "Overdue"})
+	if out == nil {
+		return
+	}
+	count := 0
+	for rows.Next() {
+		var row AccessionRow
+		err = rows.ScanStruct(&row)
+		if err != nil {
+			log.Printf("ERROR: Unable to read accession for export: %s", err.Error())
+			break
+		}
+		out.WriteRow([]interface{}{row.AccessionNumber, row.AccessionID, row.SubmittedAt, row.Submitter,
+			row.Owner, row.Unit, row.Type, row.Status, row.Assignees, row.Description, row.Genres, row.Digital,
+			row.Physical, row.Notes, row.Accruals, row.Restriction, row.RestrictionStatus, row.RestrictedUntil,
+			row.ScheduleNumber, row.DispositionDate, row.DueAt, row.Overdue})
+		count++
+	}
+	err = out.Close()
+	if err != nil {
+		log.Printf("ERROR: Unable to complete accession export: %s", err.Error())
+		return
+	}
+	log.Printf("%s exported %d accessions", GetAuthUser(c).Email, count)
+}
+
+// ExportInventory is an admin API call that exports the physical inventory of an accession
+func (svc *ServiceContext) ExportInventory(c *gin.Context) {
+	accession := svc.getPhysicalAccession(c)
+	if accession == nil {
+		return
+	}
+	filename := fmt.Sprintf("%s-inventory", accession.AccessionNumber)
+	out := newExportWriter(c, filename, []string{"Box Number", "Record Group #", "Box Title", "Description",
+		"Dates", "Status", "Note", "Checked"})
+	if out == nil {
+		return
+	}
+	for _, item := range accession.Physical.Inventory {
+		out.WriteRow([]interface{}{item.BoxNumber, item.RecordGroup, item.Title, item.Description,
+			item.Dates, item.Status, item.Note, item.CheckedAt})
+	}
+	err := out.Close()
+	if err != nil {
+		log.Printf("ERROR: Unable to complete inventory export for accession %d: %s", accession.ID, err.Error())
+	}
+}
@@ -0,0 +1,41 @@
+package main
+
+import (
+	"testing"
+	"time"
+)
+
+func TestCSVCell(t *testing.T) {
+	submitted := time.Date(2026, 3, 1, 10, 15, 0, 0, time.UTC)
+	var noDate *time.Time
+	tests := []struct {
+		name string
+		val  interface{}
+		want string
+	}{
+		{"plain text", "Board minutes", "Board minutes"},
+		{"empty", "", ""},
+		{"equals", "=HYPERLINK(\"http://evil\")", "'=HYPERLINK(\"http://evil\")"},
+		{"plus", "+1+cmd|' /C calc'!A0", "'+1+cmd|' /C calc'!A0"},
+		{"minus", "-2+3", "'-2+3"},
+		{"at", "@SUM(A1:A9)", "'@SUM(A1:A9)"},
+		{"tab", "\t=1+1", "'\t=1+1"},
+		{"carriage return", "\r=1+1", "'\r=1+1"},
+		{"operator later in text", "Letters = diaries", "Letters = diaries"},
+		{"leading space", " =1+1", " =1+1"},
+		{"negative number text", "-42", "'-42"},
+		{"negative int", -42, "-42"},
+		{"negative int64", int64(-5000000000), "-5000000000"},
+		{"negative float", -1.5, "-1.5"},
+		{"bool", true, "Yes"},
+		{"time", submitted, "2026-03-01 10:15:00"},
+		{"date", &submitted, "2026-03-01"},
+		{"nil date", noDate, ""},
+	}
+	for _, tt := range tests {
+		got := csvCell(tt.val)
+		if got != tt.want {
+			t.Errorf("%s: csvCell(%#v) = %q, want %q", tt.name, tt.val, got, tt.want)
+		}
+	}
+}
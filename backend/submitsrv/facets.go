@@ -0,0 +1,170 @@
+package main
+
+import (
+	"fmt"
+	"log"
+	"net/url"
+	"strings"
+
+	dbx "github.com/go-ozzo/ozzo-dbx"
+)
+
+// facetDef defines a facet of the admin accession list. The values SQL selects the
+// accession_id, value, label and id of every value of the facet held by each accession.
+// The id tells apart vocabulary rows that share a name
+type facetDef struct {
+	Name     string
+	Label    string
+	valuesQS string
+}
+
+// accessionFacets are the facets of the admin accession list, in display order.
+// Selections are made with the f.<name> query param, which may be repeated
+var accessionFacets = []facetDef{
+	{Name: "genre", Label: "Genre", valuesQS: `select ag.accession_id, g.name as value, g.name as label,
+		cast(g.id as char) as id from accession_genres ag inner join genres g on g.id = ag.genre_id`},
+	{Name: "recordType", Label: "Record Type", valuesQS: `select da.accession_id, t.name as value, t.name as label,
+			cast(t.id as char) as id from accession_record_types art
+			inner join record_types t on t.id = art.record_type_id
+			inner join digital_accessions da on da.id = art.accession_id and art.accession_type = "digital"
+		union select pa.accession_id, t.name, t.name, cast(t.id as char)
+			from accession_record_types art inner join record_types t on t.id = art.record_type_id
+			inner join physical_accessions pa on pa.id = art.accession_id and art.accession_type = "physical"`},
+	{Name: "transfer", Label: "Transfer", valuesQS: `select accession_id, "digital" as value, "Digital" as label,
+		"digital" as id from digital_accessions
+		union select accession_id, "physical", "Physical", "physical" from physical_accessions`},
+	{Name: "status", Label: "Status", valuesQS: `select id as accession_id, status as value, status as label,
+		status as id from accessions`},
+	{Name: "unit", Label: "Unit", valuesQS: `select x.id as accession_id, cast(ou.id as char) as value, ou.name as label,
+		cast(ou.id as char) as id from accessions x inner join org_units ou on ou.id = x.unit_id`},
+	{Name: "year", Label: "Year", valuesQS: `select id as accession_id, cast(year(created_at) as char) as value,
+		cast(year(created_at) as char) as label, cast(year(created_at) as char) as id from accessions`},
+	{Name: "transferMethod", Label: "Transfer Method", valuesQS: `select pa.accession_id, tm.name as value, tm.name as label,
+		cast(tm.id as char) as id from physical_accessions pa inner join transfer_methods tm on tm.id = pa.transfer_method_id`},
+	{Name: "mediaCarrier", Label: "Media Carrier", valuesQS: `select pa.accession_id, mc.name as value, mc.name as label,
+		cast(mc.id as char) as id from physical_accessions pa
+		inner join physical_media_carriers pmc on pmc.physical_accession_id = pa.id
+		inner join media_carriers mc on mc.id = pmc.media_carrier_id`},
+}
+
+// Facet is a facet of the accession list with the count of matching accessions for
+// each of its values. Op is or when any selected value matches, and and when all must
+type Facet struct {
+	Name   string       `json:"name"`
+	Label  string       `json:"label"`
+	Op     string       `json:"op"`
+	Values []FacetValue `json:"values"`
+}
+
+// FacetValue is one value of a facet
+type FacetValue struct {
+	Value    string `json:"value" db:"value"`
+	Label    string `json:"label" db:"label"`
+	Count    int    `json:"count" db:"count"`
+	Selected bool   `json:"selected" db:"-"`
+}
+
+// facetSelection is the values selected for one facet of the accession list
+type facetSelection struct {
+	op     string
+	values []string
+	where  string
+}
+
+// parseFacets adds the facet selections of the request to the accession query. Values
+// selected within a facet are combined with OR, unless the f.<name>.op param is and.
+// Selections in different facets are always combined with AND
+func (aq *accessionQuery) parseFacets(query url.Values) {
+	aq.facets = make(map[string]*facetSelection)
+	for _, facet := range accessionFacets {
+		values := make([]string, 0)
+		for _, v := range query["f."+facet.Name] {
+			if v = strings.TrimSpace(v); v != "" {
+				values = append(values, v)
+			}
+		}
+		if len(values) == 0 {
+			continue
+		}
+		sel := facetSelection{op: "or", values: values}
+		if strings.ToLower(query.Get("f."+facet.Name+".op")) == "and" {
+			sel.op = "and"
+		}
+		log.Printf("Filter accessions by %s %s %v", facet.Name, sel.op, values)
+
+		names := make([]string, 0, len(values))
+		for idx, v := range values {
+			name := fmt.Sprintf("f_%s_%d", facet.Name, idx)
+			aq.params[name] = v
+			names = append(names, "{:"+name+"}")
+		}
+		if sel.op == "or" {
+			sel.where = fmt.Sprintf("a.id in (select fv.accession_id from (%s) fv where fv.value in (%s))",
+				facet.valuesQS, strings.Join(names, ","))
+		} else {
+			conds := make([]string, 0, len(names))
+			for _, name := range names {
+				conds = append(conds, fmt.Sprintf("a.id in (select fv.accession_id from (%s) fv where fv.value=%s)",
+					facet.valuesQS, name))
+			}
+			sel.where = strings.Join(conds, " and ")
+		}
+		aq.facets[facet.Name] = &sel
+	}
+}
+
+// facetWhere returns the where conditions of the facet selections. The selection of the
+// skipped facet is left out so its other values are still counted
+func (aq *accessionQuery) facetWhere(skip string) []string {
+	out := make([]string, 0)
+	for _, facet := range accessionFacets {
+		if sel, ok := aq.facets[facet.Name]; ok && facet.Name != skip {
+			out = append(out, sel.where)
+		}
+	}
+	return out
+}
+
+// getFacets counts the accessions matching the query for each value of every facet.
+// Counts for an OR facet ignore its own selection, so they show how many accessions
+// each additional value would add; AND facets count within the current results
+func (aq *accessionQuery) getFacets(db *dbx.DB) ([]Facet, error) {
+	out := make([]Facet, 0, len(accessionFacets))
+	for _, def := range accessionFacets {
+		facet := Facet{Name: def.Name, Label: def.Label, Op: "or", Values: make([]FacetValue, 0)}
+		skip := def.Name
+		if sel, ok := aq.facets[def.Name]; ok {
+			facet.Op = sel.op
+			if sel.op == "and" {
+				skip = ""
+			}
+		}
+		conds := append(append([]string{}, aq.where...), aq.facetWhere(skip)...)
+		if aq.genre != "" {
+			conds = append(conds, "g.name={:g}")
+		}
+		matchQS := "select a.id " + accessionFromQS
+		if len(conds) > 0 {
+			matchQS += " where " + strings.Join(conds, " and ")
+		}
+		q := db.NewQuery(fmt.Sprintf(`select fv.value, fv.label, count(distinct fv.accession_id) as count
+			from (%s) fv where fv.accession_id in (%s)
+			group by fv.value, fv.label order by count desc, fv.label asc`, def.valuesQS, matchQS))
+		q.Bind(aq.params)
+		err := q.All(&facet.Values)
+		if err != nil {
+			return nil, err
+		}
+		if sel, ok := aq.facets[def.Name]; ok {
+			for idx := range facet.Values {
+				for _, v := range sel.values {
+					if facet.Values[idx].Value == v {
+						facet.Values[idx].Selected = true
+					}
+				}
+			}
+		}
+		out = append(out, facet)
+	}
+	return out, nil
+}
@@ -0,0 +1,187 @@
+package main
+
+import (
+	"fmt"
+	"log"
+	"net/url"
+	"strconv"
+	"strings"
+	"time"
+)
+
+// The admin accession list accepts these structured filters as query params. All of
+// them are optional and are combined with AND; values are always bound as SQL params.
+//
+//	submittedFrom  accessions submitted on or after a date, as YYYY-MM-DD
+//	submittedTo    accessions submitted on or before a date, as YYYY-MM-DD
+//	submitter      a submitter's user ID, or text matched against their name or email
+//	transfer       digital, physical, both (digital and physical) or single (only one)
+//	hasNotes       true or false
+//	minSize        smallest digital transfer size, in bytes or with a KB, MB or GB suffix
+//	maxSize        largest digital transfer size, in the same units as minSize
+//	idPrefix       prefix of the accession number or identifier
+//	sort           field:dir, where field is one of accessionSortFields and dir is
+//	               asc or desc. Searches default to relevance, other lists to submittedAt
+//
+// An invalid value is a filterError, reported to the caller as a bad request
+
+// filterError is an invalid filter or sort in the accession list request
+type filterError struct {
+	error
+}
+
+// accessionSortFields maps the sort fields of the accession list to their columns
+var accessionSortFields = map[string]string{
+	"submittedAt":     "created_at",
+	"accessionNumber": "accession_number",
+	"accessionType":   "accession_type",
+	"submitter":       "submitter",
+	"owner":           "owner",
+	"unit":            "unit",
+	"status":          "status",
+	"physical":        "physical",
+	"digital":         "digital",
+	"notes":           "notes",
+	"accruals":        "accruals",
+	"dueAt":           "due_at",
+	"dispositionDate": "disposition_date",
+	"relevance":       "score",
+}
+
+// parseSort sets the sort column and direction from a sort param. Rows with the same
+// sort value are ordered by ID so the order is stable for keyset paging
+func (aq *accessionQuery) parseSort(sort string) error {
+	sortBy := "submittedAt"
+	if len(aq.search) > 0 {
+		sortBy = "relevance"
+	}
+	sortDir := "desc"
+	if sort != "" {
+		parts := strings.SplitN(sort, ":", 2)
+		sortBy = parts[0]
+		if len(parts) > 1 && parts[1] != "" {
+			sortDir = strings.ToLower(parts[1])
+		}
+	}
+	column, ok := accessionSortFields[sortBy]
+	if ok == false {
+		return filterError{fmt.Errorf("invalid sort field %s", sortBy)}
+	}
+	if sortDir != "asc" && sortDir != "desc" {
+		return filterError{fmt.Errorf("invalid sort direction %s", sortDir)}
+	}
+	if column == "score" && len(aq.search) == 0 {
+		column = "created_at"
+	}
+	aq.sortColumn = column
+	aq.sortDir = sortDir
+	return nil
+}
+
+// parseSize parses a size in bytes with an optional KB, MB or GB suffix. Units are
+// decimal, as they are on the receipt and in emails
+func parseSize(size string) (int64, error) {
+	val := strings.ToUpper(strings.TrimSpace(size))
+	mult := int64(1)
+	for _, unit := range []struct {
+		suffix string
+		mult   int64
+	}{{"GB", 1000000000}, {"MB", 1000000}, {"KB", 1000}, {"B", 1}} {
+		if strings.HasSuffix(val, unit.suffix) {
+			mult = unit.mult
+			val = strings.TrimSpace(strings.TrimSuffix(val, unit.suffix))
+			break
+		}
+	}
+	num, err := strconv.ParseFloat(val, 64)
+	if err != nil || num < 0 {
+		return 0, fmt.Errorf("invalid size %s", size)
+	}
+	return int64(num * float64(mult)), nil
+}
+
+// parseFilters adds the structured filters of the request to the accession query
+func (aq *accessionQuery) parseFilters(query url.Values) error {
+	for _, param := range []struct {
+		name string
+		cond string
+		days int
+	}{{"submittedFrom", "a.created_at >= {:submittedFrom}", 0}, {"submittedTo", "a.created_at < {:submittedTo}", 1}} {
+		val := strings.TrimSpace(query.Get(param.name))
+		if val == "" {
+			continue
+		}
+		date, err := time.ParseInLocation("2006-01-02", val, time.Local)
+		if err != nil {
+			return filterError{fmt.Errorf("invalid %s date %s", param.name, val)}
+		}
+		log.Printf("Filter accessions by %s %s", param.name, val)
+		aq.params[param.name] = date.AddDate(0, 0, param.days)
+		aq.where = append(aq.where, param.cond)
+	}
+
+	if submitter := strings.TrimSpace(query.Get("submitter")); submitter != "" {
+		log.Printf("Filter accessions by submitter [%s]", submitter)
+		if userID, err := strconv.Atoi(submitter); err == nil {
+			aq.params["submitter"] = userID
+			aq.where = append(aq.where, "a.user_id={:submitter}")
+		} else {
+			aq.params["submitter"] = "%" + escapeLike(submitter) + "%"
+			aq.where = append(aq.where, `(concat(u.first_name, ' ', u.last_name) like {:submitter}
+				or u.email like {:submitter})`)
+		}
+	}
+
+	switch transfer := query.Get("transfer"); transfer {
+	case "":
+	case "digital":
+		aq.where = append(aq.where, "da.id is not null")
+	case "physical":
+		aq.where = append(aq.where, "pa.id is not null")
+	case "both":
+		aq.where = append(aq.where, "da.id is not null and pa.id is not null")
+	case "single":
+		aq.where = append(aq.where, "(da.id is null or pa.id is null)")
+	default:
+		return filterError{fmt.Errorf("invalid transfer filter %s", transfer)}
+	}
+
+	switch hasNotes := query.Get("hasNotes"); hasNotes {
+	case "":
+	case "true":
+		aq.where = append(aq.where, "exists (select 1 from accession_notes an where an.accession_id=a.id)")
+	case "false":
+		aq.where = append(aq.where, "not exists (select 1 from accession_notes an where an.accession_id=a.id)")
+	default:
+		return filterError{fmt.Errorf("invalid hasNotes filter %s", hasNotes)}
+	}
+
+	for _, param := range []struct {
+		name string
+		cond string
+	}{{"minSize", "da.upload_size >= {:minSize}"}, {"maxSize", "da.upload_size <= {:maxSize}"}} {
+		val := strings.TrimSpace(query.Get(param.name))
+		if val == "" {
+			continue
+		}
+		size, err := parseSize(val)
+		if err != nil {
+			return filterError{fmt.Errorf("invalid %s: %s", param.name, err.Error())}
+		}
+		log.Printf("Filter accessions by %s %d", param.name, size)
+		aq.params[param.name] = size
+		aq.where = append(aq.where, param.cond)
+	}
+
+	if prefix := strings.TrimSpace(query.Get("idPrefix")); prefix != "" {
+		log.Printf("Filter accessions by identifier prefix [%s]", prefix)
+		aq.params["idPrefix"] = escapeLike(prefix) + "%"
+		aq.where = append(aq.where, "(a.accession_number like {:idPrefix} or a.identifier like {:idPrefix})")
+	}
+	return nil
+}
+
+// escapeLike escapes the wildcard characters of a SQL like pattern
+func escapeLike(val string) string {
+	return strings.NewReplacer(`\`, `\\`, "%", `\%`, "_", `\_`).Replace(val)
+}
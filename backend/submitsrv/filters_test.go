@@ -0,0 +1,155 @@
+package main
+
+import (
+	"net/url"
+	"testing"
+	"time"
+
+	dbx "github.com/go-ozzo/ozzo-dbx"
+)
+
+func TestParseSize(t *testing.T) {
+	tests := []struct {
+		size string
+		want int64
+		err  bool
+	}{
+		{"0", 0, false},
+		{"1234", 1234, false},
+		{"10B", 10, false},
+		{"5KB", 5000, false},
+		{"1.5MB", 1500000, false},
+		{"2GB", 2000000000, false},
+		{" 3 gb ", 3000000000, false},
+		{"0.5kb", 500, false},
+		{"", 0, true},
+		{"GB", 0, true},
+		{"-1MB", 0, true},
+		{"ten", 0, true},
+		{"5TB", 0, true},
+	}
+	for _, tt := range tests {
+		got, err := parseSize(tt.size)
+		if tt.err {
+			if err == nil {
+				t.Errorf("parseSize(%q) = %d, want error", tt.size, got)
+			}
+			continue
+		}
+		if err != nil {
+			t.Errorf("parseSize(%q) unexpected error: %s", tt.size, err.Error())
+			continue
+		}
+		if got != tt.want {
+			t.Errorf("parseSize(%q) = %d, want %d", tt.size, got, tt.want)
+		}
+	}
+}
+
+func TestParseSort(t *testing.T) {
+	tests := []struct {
+		sort    string
+		search  bool
+		wantCol string
+		wantDir string
+		err     bool
+	}{
+		{"", false, "created_at", "desc", false},
+		{"", true, "score", "desc", false},
+		{"accessionNumber", false, "accession_number", "desc", false},
+		{"accessionType:asc", false, "accession_type", "asc", false},
+		{"dueAt:DESC", false, "due_at", "desc", false},
+		{"submitter:", false, "submitter", "desc", false},
+		{"relevance", false, "created_at", "desc", false},
+		{"relevance:asc", true, "score", "asc", false},
+		{"accession_type", false, "", "", true},
+		{"created_at", false, "", "", true},
+		{"status:sideways", false, "", "", true},
+		{"id; drop table accessions", false, "", "", true},
+	}
+	for _, tt := range tests {
+		aq := accessionQuery{params: dbx.Params{}}
+		if tt.search {
+			aq.search = []searchTerm{{Text: "letters"}}
+		}
+		err := aq.parseSort(tt.sort)
+		if tt.err {
+			if err == nil {
+				t.Errorf("parseSort(%q) = %s %s, want error", tt.sort, aq.sortColumn, aq.sortDir)
+			} else if _, ok := err.(filterError); ok == false {
+				t.Errorf("parseSort(%q) error is not a filterError: %s", tt.sort, err.Error())
+			}
+			continue
+		}
+		if err != nil {
+			t.Errorf("parseSort(%q) unexpected error: %s", tt.sort, err.Error())
+			continue
+		}
+		if aq.sortColumn != tt.wantCol || aq.sortDir != tt.wantDir {
+			t.Errorf("parseSort(%q) = %s %s, want %s %s", tt.sort, aq.sortColumn, aq.sortDir, tt.wantCol, tt.wantDir)
+		}
+	}
+}
+
+func TestParseFilters(t *testing.T) {
+	day := func(s string) time.Time {
+		d, _ := time.ParseInLocation("2006-01-02", s, time.Local)
+		return d
+	}
+	tests := []struct {
+		name       string
+		query      string
+		wantWhere  int
+		wantParams dbx.Params
+		err        bool
+	}{
+		{"none", "", 0, dbx.Params{}, false},
+		{"date range", "submittedFrom=2026-01-01&submittedTo=2026-01-31", 2,
+			dbx.Params{"submittedFrom": day("2026-01-01"), "submittedTo": day("2026-02-01")}, false},
+		{"bad date", "submittedFrom=01/01/2026", 0, nil, true},
+		{"submitter id", "submitter=42", 1, dbx.Params{"submitter": 42}, false},
+		{"submitter name", "submitter=ann_o%25", 1, dbx.Params{"submitter": `%ann\_o\%%`}, false},
+		{"digital", "transfer=digital", 1, dbx.Params{}, false},
+		{"both", "transfer=both", 1, dbx.Params{}, false},
+		{"bad transfer", "transfer=email", 0, nil, true},
+		{"has notes", "hasNotes=false", 1, dbx.Params{}, false},
+		{"bad notes", "hasNotes=yes", 0, nil, true},
+		{"size range", "minSize=1MB&maxSize=2.5GB", 2,
+			dbx.Params{"minSize": int64(1000000), "maxSize": int64(2500000000)}, false},
+		{"bad size", "maxSize=big", 0, nil, true},
+		{"id prefix", "idPrefix=2026_", 1, dbx.Params{"idPrefix": `2026\_%`}, false},
+		{"blank values", "submitter=%20&idPrefix=&minSize=", 0, dbx.Params{}, false},
+	}
+	for _, tt := range tests {
+		query, err := url.ParseQuery(tt.query)
+		if err != nil {
+			t.Fatalf("%s: bad test query %s", tt.name, tt.query)
+		}
+		aq := accessionQuery{params: dbx.Params{}}
+		err = aq.parseFilters(query)
+		if tt.err {
+			if err == nil {
+				t.Errorf("%s: parseFilters(%q) succeeded, want error", tt.name, tt.query)
+			} else if _, ok := err.(filterError); ok == false {
+				t.Errorf("%s: error is not a filterError: %s", tt.name, err.Error())
+			}
+			continue
+		}
+		if err != nil {
+			t.Errorf("%s: unexpected error: %s", tt.name, err.Error())
+			continue
+		}
+		if len(aq.where) != tt.wantWhere {
+			t.Errorf("%s: got %d conditions %v, want %d", tt.name, len(aq.where), aq.where, tt.wantWhere)
+		}
+		if len(aq.params) != len(tt.wantParams) {
+			t.Errorf("%s: got params %v, want %v", tt.name, aq.params, tt.wantParams)
+			continue
+		}
+		for key, want := range tt.wantParams {
+			if got := aq.params[key]; got != want {
+				t.Errorf("%s: param %s = %v, want %v", tt.name, key, got, want)
+			}
+		}
+	}
+}
@@ -0,0 +1,282 @@
+package main
+
+import (
+	"bytes"
+	"encoding/csv"
+	"errors"
+	"fmt"
+	"io"
+	"log"
+	"net/http"
+	"path/filepath"
+	"strings"
+
+	"github.com/gin-gonic/gin"
+	"github.com/xuri/excelize/v2"
+)
+
+// maxInventoryUploadSize is the largest inventory spreadsheet request accepted
+const maxInventoryUploadSize = 10 << 20
+
+// inventoryColumns lists the columns of an inventory spreadsheet in template order
+var inventoryColumns = []string{"Box Number", "Record Group #", "Box Title", "Description", "Dates"}
+
+// inventoryHeaders maps normalized spreadsheet header text to inventory columns. Headers
+// are normalized by lower casing and removing everything but letters
+var inventoryHeaders = map[string]string{
+	"boxnumber":         "box",
+	"boxnum":            "box",
+	"box":               "box",
+	"recordgroup":       "group",
+	"recordgroupnumber": "group",
+	"title":             "title",
+	"boxtitle":          "title",
+	"description":       "description",
+	"dates":             "dates",
+	"daterange":         "dates",
+}
+
+// RowError describes a problem with one row of an imported inventory spreadsheet
+type RowError struct {
+	Line    int    `json:"line"`
+	Message string `json:"message"`
+}
+
+// InventoryImport is the result of parsing an inventory spreadsheet
+type InventoryImport struct {
+	Inventory []InventoryItem `json:"inventory"`
+	Errors    []RowError      `json:"errors"`
+}
+
+// normalizeHeader reduces a header cell to lower case letters only
+func normalizeHeader(header string) string {
+	out := strings.Builder{}
+	for _, r := range strings.ToLower(header) {
+		if r >= 'a' && r <= 'z' {
+			out.WriteRune(r)
+		}
+	}
+	return out.String()
+}
+
+// readInventoryRows reads all rows of a CSV or XLSX file, chosen by file extension,
+// along with the line number of each row. Only the first sheet of a workbook is read
+func readInventoryRows(filename string, file io.Reader) ([][]string, []int, error) {
+	switch strings.ToLower(filepath.Ext(filename)) {
+	case ".csv":
+		// the CSV reader skips blank lines, so take line numbers from the reader
+		reader := csv.NewReader(file)
+		reader.FieldsPerRecord = -1
+		reader.TrimLeadingSpace = true
+		rows := make([][]string, 0)
+		lines := make([]int, 0)
+		for {
+			row, err := reader.Read()
+			if err == io.EOF {
+				return rows, lines, nil
+			}
+			if err != nil {
+				return nil, nil, err
+			}
+			line, _ := reader.FieldPos(0)
+			rows = append(rows, row)
+			lines = append(lines, line)
+		}
+	case ".xlsx":
+		wb, err := excelize.OpenReader(file)
+		if err != nil {
+			return nil, nil, err
+		}
+		defer wb.Close()
+		rows, err := wb.GetRows(wb.GetSheetName(0))
+		if err != nil {
+			return nil, nil, err
+		}
+		lines := make([]int, len(rows))
+		for idx := range rows {
+			lines[idx] = idx + 1
+		}
+		return rows, lines, nil
+	}
+	return nil, nil, fmt.Errorf("unsupported file type %s; use CSV or XLSX", filepath.Ext(filename))
+}
+
+// ParseInventory parses and validates an inventory spreadsheet. The first row must be a
+// header with at least a box number column. Blank rows are skipped; every other row
+// must have a box number that is not repeated. Errors give the line number in the file
+func ParseInventory(filename string, file io.Reader) (*InventoryImport, error) {
+	rows, lines, err := readInventoryRows(filename, file)
+	if err != nil {
+		return nil, err
+	}
+	out := InventoryImport{Inventory: make([]InventoryItem, 0), Errors: make([]RowError, 0)}
+	if len(rows) == 0 {
+		out.Errors = append(out.Errors, RowError{Line: 1, Message: "The file is empty"})
+		return &out, nil
+	}
+
+	cols := make(map[string]int)
+	for idx, header := range rows[0] {
+		if col, ok := inventoryHeaders[normalizeHeader(header)]; ok {
+			cols[col] = idx
+		}
+	}
+	if _, ok := cols["box"]; ok == false {
+		out.Errors = append(out.Errors, RowError{Line: lines[0], Message: "The header row must include a Box Number column"})
+		return &out, nil
+	}
+	cell := func(row []string, col string) string {
+		idx, ok := cols[col]
+		if ok == false || idx >= len(row) {
+			return ""
+		}
+		return strings.TrimSpace(row[idx])
+	}
+
+	boxLines := make(map[string]int)
+	for idx, row := range rows[1:] {
+		line := lines[idx+1]
+		item := InventoryItem{BoxNumber: cell(row, "box"), RecordGroup: cell(row, "group"),
+			Title: cell(row, "title"), Description: cell(row, "description"), Dates: cell(row, "dates")}
+		if item.BoxNumber == "" && item.RecordGroup == "" && item.Title == "" &&
+			item.Description == "" && item.Dates == "" {
+			continue
+		}
+		if item.BoxNumber == "" {
+			out.Errors = append(out.Errors, RowError{Line: line, Message: "Box number is required"})
+			continue
+		}
+		if prior, ok := boxLines[item.BoxNumber]; ok {
+			out.Errors = append(out.Errors, RowError{Line: line,
+				Message: fmt.Sprintf("Box number %s is also used on line %d", item.BoxNumber, prior)})
+			continue
+		}
+		boxLines[item.BoxNumber] = line
+		out.Inventory = append(out.Inventory, item)
+	}
+	if len(out.Inventory) == 0 && len(out.Errors) == 0 {
+		out.Errors = append(out.Errors, RowError{Line: lines[0] + 1, Message: "The file contains no inventory rows"})
+	}
+	return &out, nil
+}
+
+// parseInventoryUpload parses the spreadsheet posted in the file form field. An error
+// response is sent and nil returned if the file is too large, cannot be read or has
+// invalid rows
+func parseInventoryUpload(c *gin.Context) *InventoryImport {
+	c.Request.Body = http.MaxBytesReader(c.Writer, c.Request.Body, maxInventoryUploadSize)
+	file, header, err := c.Request.FormFile("file")
+	if err != nil {
+		var tooLarge *http.MaxBytesError
+		if errors.As(err, &tooLarge) {
+			c.String(http.StatusRequestEntityTooLarge, "the inventory file is larger than %d MB", maxInventoryUploadSize>>20)
+			return nil
+		}
+		c.String(http.StatusBadRequest, "a CSV or XLSX file is required: %s", err.Error())
+		return nil
+	}
+	defer file.Close()
+	parsed, err := ParseInventory(header.Filename, file)
+	if err != nil {
+		log.Printf("ERROR: Unable to read inventory %s: %s", header.Filename, err.Error())
+		c.String(http.StatusBadRequest, "unable to read %s: %s", header.Filename, err.Error())
+		return nil
+	}
+	if len(parsed.Errors) > 0 {
+		log.Printf("Inventory %s has %d invalid rows", header.Filename, len(parsed.Errors))
+		c.JSON(http.StatusBadRequest, parsed)
+		return nil
+	}
+	return parsed
+}
+
+// ParseInventoryUpload accepts an inventory spreadsheet and returns the parsed rows so
+// they can be loaded into the transfer form
+func (svc *ServiceContext) ParseInventoryUpload(c *gin.Context) {
+	parsed := parseInventoryUpload(c)
+	if parsed == nil {
+		return
+	}
+	c.JSON(http.StatusOK, parsed)
+}
+
+// GetInventoryTemplate returns a blank inventory spreadsheet with the expected columns.
+// The format query param selects csv or xlsx (the default)
+func (svc *ServiceContext) GetInventoryTemplate(c *gin.Context) {
+	if c.Query("format") == "csv" {
+		var buf bytes.Buffer
+		writer := csv.NewWriter(&buf)
+		writer.Write(inventoryColumns)
+		writer.Flush()
+		c.Header("Content-Disposition", `attachment; filename="inventory.csv"`)
+		c.Data(http.StatusOK, "text/csv", buf.Bytes())
+		return
+	}
+
+	wb := excelize.NewFile()
+	defer wb.Close()
+	sheet := wb.GetSheetName(0)
+	wb.SetSheetRow(sheet, "A1", &inventoryColumns)
+	wb.SetColWidth(sheet, "C", "D", 40)
+	buf, err := wb.WriteToBuffer()
+	if err != nil {
+		log.Printf("ERROR: Unable to generate inventory template: %s", err.Error())
+		c.String(http.StatusInternalServerError, err.Error())
+		return
+	}
+	c.Header("Content-Disposition", `attachment; filename="inventory.xlsx"`)
+	c.Data(http.StatusOK, "application/vnd.openxmlformats-officedocument.spreadsheetml.sheet", buf.Bytes())
+}
+
+// ImportInventory is an admin API call that adds the boxes in an inventory spreadsheet
+// to a physical accession. Boxes already in the inventory are rejected, as are imports
+// once receiving is closed
+func (svc *ServiceContext) ImportInventory(c *gin.Context) {
+	accession := svc.getOpenPhysicalAccession(c)
+	if accession == nil {
+		return
+	}
+	parsed := parseInventoryUpload(c)
+	if parsed == nil {
+		return
+	}
+	existing := make(map[string]bool)
+	for _, item := range accession.Physical.Inventory {
+		existing[item.BoxNumber] = true
+	}
+	for _, item := range parsed.Inventory {
+		if existing[item.BoxNumber] {
+			c.String(http.StatusBadRequest, "box %s is already in the inventory of accession %d", item.BoxNumber, accession.ID)
+			return
+		}
+	}
+
+	tx, _ := svc.DB.Begin()
+	for _, item := range parsed.Inventory {
+		item.PhysAccessionID = accession.Physical.ID
+		item.Status = "expected"
+		err := tx.Model(&item).Exclude("Note", "CheckedAt", "CheckedBy").Insert()
+		if err != nil {
+			log.Printf("ERROR: Unable to import box %s to accession %d: %s", item.BoxNumber, accession.ID, err.Error())
+			tx.Rollback()
+			c.String(http.StatusInternalServerError, err.Error())
+			return
+		}
+	}
+	err := EnqueueJob(tx, accession.ID, "index")
+	if err != nil {
+		log.Printf("ERROR: Unable to queue index job for accession %d: %s", accession.ID, err.Error())
+		tx.Rollback()
+		c.String(http.StatusInternalServerError, err.Error())
+		return
+	}
+	err = tx.Commit()
+	if err != nil {
+		log.Printf("ERROR: Unable to commit inventory import for accession %d: %s", accession.ID, err.Error())
+		c.String(http.StatusInternalServerError, err.Error())
+		return
+	}
+	log.Printf("%s imported %d boxes to accession %d", GetAuthUser(c).Email, len(parsed.Inventory), accession.ID)
+	accession.Physical.GetInventory(svc.DB)
+	c.JSON(http.StatusOK, accession.Physical.Inventory)
+}
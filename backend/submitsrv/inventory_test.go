@@ -0,0 +1,182 @@
+package main
+
+import (
+	"bytes"
+	"encoding/json"
+	"mime/multipart"
+	"net/http"
+	"net/http/httptest"
+	"strings"
+	"testing"
+
+	"github.com/gin-gonic/gin"
+	"github.com/xuri/excelize/v2"
+)
+
+func TestParseInventoryCSV(t *testing.T) {
+	tests := []struct {
+		name      string
+		csv       string
+		wantBoxes []string
+		wantErrs  []RowError
+	}{
+		{"template headers",
+			"Box Number,Record Group #,Box Title,Description,Dates\n1,RG-31,Minutes,Board minutes,1960-1965\n2,RG-31,Letters,,1966\n",
+			[]string{"1", "2"}, nil},
+		{"header aliases and order",
+			"dates, TITLE ,box num,Date Range,record group number\n1960,Minutes,A1,ignored,RG-31\n",
+			[]string{"A1"}, nil},
+		{"unknown columns ignored",
+			"Shelf,Box,Notes\nS1,1,fragile\n",
+			[]string{"1"}, nil},
+		{"blank rows skipped",
+			"Box\n1\n\n,\n2\n",
+			[]string{"1", "2"}, nil},
+		{"short rows",
+			"Title,Box,Dates\nMinutes,1\nLetters\n",
+			[]string{"1"}, []RowError{{Line: 3, Message: "Box number is required"}}},
+		{"cells trimmed",
+			"Box,Title\n  7  ,  Minutes  \n",
+			[]string{"7"}, nil},
+		{"missing box number",
+			"Box,Title\n1,Minutes\n,Letters\n",
+			[]string{"1"}, []RowError{{Line: 3, Message: "Box number is required"}}},
+		{"duplicate box number",
+			"Box,Title\n1,Minutes\n2,Letters\n1,Diaries\n",
+			[]string{"1", "2"}, []RowError{{Line: 4, Message: "Box number 1 is also used on line 2"}}},
+		{"line numbers count blank and quoted lines",
+			"Box,Description\n1,\"two\nlines\"\n\n1,again\n",
+			[]string{"1"}, []RowError{{Line: 5, Message: "Box number 1 is also used on line 2"}}},
+		{"no box column",
+			"Title,Dates\nMinutes,1960\n",
+			nil, []RowError{{Line: 1, Message: "The header row must include a Box Number column"}}},
+		{"empty file",
+			"",
+			nil, []RowError{{Line: 1, Message: "The file is empty"}}},
+		{"header only",
+			"Box Number,Title\n",
+			nil, []RowError{{Line: 2, Message: "The file contains no inventory rows"}}},
+	}
+	for _, tt := range tests {
+		parsed, err := ParseInventory("inventory.csv", strings.NewReader(tt.csv))
+		if err != nil {
+			t.Errorf("%s: unexpected error: %s", tt.name, err.Error())
+			continue
+		}
+		checkInventoryImport(t, tt.name, parsed, tt.wantBoxes, tt.wantErrs)
+	}
+}
+
+func TestParseInventoryFields(t *testing.T) {
+	parsed, err := ParseInventory("INVENTORY.CSV", strings.NewReader(
+		"Box Number,Record Group #,Box Title,Description,Dates\n3,RG-31,Minutes,\"Board minutes, signed\",1960-1965\n"))
+	if err != nil {
+		t.Fatalf("unexpected error: %s", err.Error())
+	}
+	want := InventoryItem{BoxNumber: "3", RecordGroup: "RG-31", Title: "Minutes",
+		Description: "Board minutes, signed", Dates: "1960-1965"}
+	if len(parsed.Inventory) != 1 || parsed.Inventory[0] != want {
+		t.Errorf("got %+v, want %+v", parsed.Inventory, want)
+	}
+}
+
+func TestParseInventoryXLSX(t *testing.T) {
+	wb := excelize.NewFile()
+	sheet := wb.GetSheetName(0)
+	wb.SetSheetRow(sheet, "A1", &[]interface{}{"Box Number", "Box Title"})
+	wb.SetSheetRow(sheet, "A2", &[]interface{}{1, "Minutes"})
+	wb.SetSheetRow(sheet, "A4", &[]interface{}{"", "Letters"})
+	wb.SetSheetRow(sheet, "A5", &[]interface{}{1, "Diaries"})
+	buf, err := wb.WriteToBuffer()
+	wb.Close()
+	if err != nil {
+		t.Fatalf("unable to write test workbook: %s", err.Error())
+	}
+
+	parsed, err := ParseInventory("inventory.xlsx", buf)
+	if err != nil {
+		t.Fatalf("unexpected error: %s", err.Error())
+	}
+	checkInventoryImport(t, "xlsx", parsed, []string{"1"}, []RowError{
+		{Line: 4, Message: "Box number is required"},
+		{Line: 5, Message: "Box number 1 is also used on line 2"}})
+}
+
+func TestParseInventoryBadFiles(t *testing.T) {
+	tests := []struct {
+		name     string
+		filename string
+		data     string
+	}{
+		{"unsupported type", "inventory.xls", "Box\n1\n"},
+		{"no extension", "inventory", "Box\n1\n"},
+		{"bad xlsx", "inventory.xlsx", "Box\n1\n"},
+		{"bad csv quoting", "inventory.csv", "Box,Title\n1,\"Minutes\n"},
+	}
+	for _, tt := range tests {
+		parsed, err := ParseInventory(tt.filename, strings.NewReader(tt.data))
+		if err == nil {
+			t.Errorf("%s: parsed as %+v, want error", tt.name, parsed)
+		}
+	}
+}
+
+func TestParseInventoryUploadSize(t *testing.T) {
+	gin.SetMode(gin.TestMode)
+	router := gin.New()
+	svc := ServiceContext{}
+	router.POST("/api/inventory/parse", svc.ParseInventoryUpload)
+
+	tests := []struct {
+		name       string
+		data       string
+		wantStatus int
+	}{
+		{"small file", "Box\n1\n2\n", http.StatusOK},
+		{"invalid rows", "Title\nMinutes\n", http.StatusBadRequest},
+		{"too large", "Box\n" + strings.Repeat("1234567890\n", maxInventoryUploadSize/10), http.StatusRequestEntityTooLarge},
+	}
+	for _, tt := range tests {
+		var body bytes.Buffer
+		form := multipart.NewWriter(&body)
+		part, _ := form.CreateFormFile("file", "inventory.csv")
+		part.Write([]byte(tt.data))
+		form.Close()
+
+		req := httptest.NewRequest("POST", "/api/inventory/parse", &body)
+		req.Header.Set("Content-Type", form.FormDataContentType())
+		resp := httptest.NewRecorder()
+		router.ServeHTTP(resp, req)
+		if resp.Code != tt.wantStatus {
+			t.Errorf("%s: status %d, want %d: %s", tt.name, resp.Code, tt.wantStatus, resp.Body.String())
+			continue
+		}
+		if tt.wantStatus == http.StatusOK {
+			var parsed InventoryImport
+			if err := json.Unmarshal(resp.Body.Bytes(), &parsed); err != nil || len(parsed.Inventory) != 2 {
+				t.Errorf("%s: unexpected response %s", tt.name, resp.Body.String())
+			}
+		}
+	}
+}
+
+// checkInventoryImport compares the box numbers and row errors of a parsed inventory
+func checkInventoryImport(t *testing.T, name string, parsed *InventoryImport, wantBoxes []string, wantErrs []RowError) {
+	t.Helper()
+	boxes := make([]string, 0, len(parsed.Inventory))
+	for _, item := range parsed.Inventory {
+		boxes = append(boxes, item.BoxNumber)
+	}
+	if strings.Join(boxes, "|") != strings.Join(wantBoxes, "|") {
+		t.Errorf("%s: boxes %v, want %v", name, boxes, wantBoxes)
+	}
+	if len(parsed.Errors) != len(wantErrs) {
+		t.Errorf("%s: errors %+v, want %+v", name, parsed.Errors, wantErrs)
+		return
+	}
+	for idx, want := range wantErrs {
+		if parsed.Errors[idx] != want {
+			t.Errorf("%s: error %d is %+v, want %+v", name, idx, parsed.Errors[idx], want)
+		}
+	}
+}
@@ -0,0 +1,474 @@
+package main
+
+import (
+	"crypto/sha256"
+	"fmt"
+	"io"
+	"log"
+	"math"
+	"net/http"
+	"os"
+	"os/exec"
+	"path/filepath"
+	"time"
+
+	"github.com/gin-gonic/gin"
+	dbx "github.com/go-ozzo/ozzo-dbx"
+	"github.com/rs/xid"
+)
+
+// Job is one step of the post-submit processing for an accession. Jobs are stored in
+// the DB and run by a pool of workers so that a failed step can be retried without
+// leaving the accession half processed.
+type Job struct {
+	ID          int       `json:"id" db:"id"`
+	AccessionID int       `json:"accessionID" db:"accession_id"`
+	Identifier  string    `json:"identifier" db:"identifier"`
+	Type        string    `json:"type" db:"job_type"`
+	Status      string    `json:"status" db:"status"`
+	Attempts    int       `json:"attempts" db:"attempts"`
+	MaxAttempts int       `json:"maxAttempts" db:"max_attempts"`
+	LastError   string    `json:"lastError" db:"last_error"`
+	ClaimToken  string    `json:"-" db:"claim_token"`
+	RunAt       time.Time `json:"runAt" db:"run_at"`
+	CreatedAt   time.Time `json:"createdAt" db:"created_at"`
+	UpdatedAt   time.Time `json:"updatedAt" db:"updated_at"`
+}
+
+// TableName defines the expected DB table name that holds data for jobs
+func (job *Job) TableName() string {
+	return "jobs"
+}
+
+// fatalJobError marks a job failure that will not be fixed by retrying
+type fatalJobError struct {
+	error
+}
+
+// jobHandler performs the work for one type of job
+type jobHandler func(svc *ServiceContext, accession *Accession) error
+
+// jobHandlers maps job types to the functions that perform them
+var jobHandlers = map[string]jobHandler{
+	"promote":      promoteFilesJob,
+	"scan":         scanFilesJob,
+	"checksum":     checksumFilesJob,
+	"notify":       notifyJob,
+	"discrepancy":  discrepancyJob,
+	"embargo":      embargoJob,
+	"owner_notify": ownerNotifyJob,
+	"message":      messageJob,
+	"assignment":   assignmentJob,
+	"deadline":     deadlineJob,
+	"sla_recalc":   slaRecalcJob,
+	"disposition":  dispositionJob,
+	"index":        indexJob,
+	"index_new":    indexJob,
+	"saved_search": savedSearchJob,
+}
+
+// jobFollowups lists the jobs that are queued once a job completes successfully. A new
+// submission is indexed by index_new so that only new accessions are checked against
+// the saved searches
+var jobFollowups = map[string][]string{
+	"promote":   {"scan", "checksum"},
+	"index_new": {"saved_search"},
+}
+
+const maxJobAttempts = 5
+const jobBaseRetryDelay = 30 * time.Second
+const jobMaxRetryDelay = time.Hour
+
+// maxJobErrorLength is the size of the last_error column of jobs
+const maxJobErrorLength = 1024
+
+// A running job holds a lease that its worker renews every jobHeartbeat. A job whose
+// lease has not been renewed for jobLease was orphaned by a worker that stopped, and is
+// returned to pending. Workers of another service instance keep their leases renewed,
+// so overlapping instances never take over each other's jobs
+const jobHeartbeat = time.Minute
+const jobLease = 5 * time.Minute
+
+// EnqueueJob adds a new pending job for an accession. It accepts either a DB or a
+// transaction so jobs can be created atomically with the accession itself
+func EnqueueJob(db dbx.Builder, accessionID int, jobType string) error {
+	return EnqueueJobAt(db, accessionID, jobType, time.Now())
+}
+
+// EnqueueJobAt adds a new pending job for an accession that will not run before runAt
+func EnqueueJobAt(db dbx.Builder, accessionID int, jobType string, runAt time.Time) error {
+	log.Printf("Enqueue %s job for accession %d to run at %s", jobType, accessionID, runAt.Format(time.RFC3339))
+	now := time.Now()
+	_, err := db.Insert("jobs", dbx.Params{
+		"accession_id": accessionID,
+		"job_type":     jobType,
+		"status":       "pending",
+		"max_attempts": maxJobAttempts,
+		"run_at":       runAt,
+		"created_at":   now,
+		"updated_at":   now,
+	}).Execute()
+	return err
+}
+
+// EnqueueAccessionJobs adds a pending job for every accession matching a where clause
+// on accessions a, skipping accessions that already have one pending. Returns the
+// number of jobs added
+func EnqueueAccessionJobs(db dbx.Builder, jobType string, where string, params dbx.Params) (int64, error) {
+	q := db.NewQuery(`insert into jobs (accession_id, job_type, status, max_attempts, run_at, created_at, updated_at)
+		select a.id, {:jobType}, "pending", {:maxAttempts}, {:now}, {:now}, {:now} from accessions a
+		where ` + where + ` and not exists (select 1 from jobs j
+			where j.accession_id=a.id and j.job_type={:jobType} and j.status="pending")`)
+	bind := dbx.Params{"jobType": jobType, "maxAttempts": maxJobAttempts, "now": time.Now()}
+	for name, val := range params {
+		bind[name] = val
+	}
+	q.Bind(bind)
+	res, err := q.Execute()
+	if err != nil {
+		return 0, err
+	}
+	log.Printf("Enqueued %s jobs for accessions where %s", jobType, where)
+	return res.RowsAffected()
+}
+
+// StartJobWorkers starts the requested number of worker goroutines, along with a
+// monitor that returns jobs orphaned by a stopped worker to pending
+func (svc *ServiceContext) StartJobWorkers(count int, interval time.Duration) {
+	log.Printf("Starting %d job workers...", count)
+	go func() {
+		for {
+			svc.resetOrphanedJobs()
+			time.Sleep(jobHeartbeat)
+		}
+	}()
+	for i := 0; i < count; i++ {
+		go svc.jobWorker(i, interval)
+	}
+}
+
+// resetOrphanedJobs returns running jobs whose lease has expired to pending
+func (svc *ServiceContext) resetOrphanedJobs() {
+	q := svc.DB.NewQuery(`update jobs set status="pending", claim_token=""
+		where status="running" and updated_at < {:stale}`)
+	q.Bind(dbx.Params{"stale": time.Now().Add(-jobLease)})
+	res, err := q.Execute()
+	if err != nil {
+		log.Printf("WARN: Unable to reset orphaned jobs: %s", err.Error())
+		return
+	}
+	if cnt, _ := res.RowsAffected(); cnt > 0 {
+		log.Printf("WARN: Reset %d orphaned jobs to pending", cnt)
+	}
+}
+
+// renewJobLease keeps the lease of a running job until done is closed
+func (svc *ServiceContext) renewJobLease(job *Job, done chan struct{}) {
+	ticker := time.NewTicker(jobHeartbeat)
+	defer ticker.Stop()
+	for {
+		select {
+		case <-done:
+			return
+		case <-ticker.C:
+			_, err := svc.DB.Update("jobs", dbx.Params{"updated_at": time.Now()},
+				dbx.HashExp{"id": job.ID, "claim_token": job.ClaimToken}).Execute()
+			if err != nil {
+				log.Printf("WARN: Unable to renew lease of job %d: %s", job.ID, err.Error())
+			}
+		}
+	}
+}
+
+// jobWorker loops forever, claiming and running jobs that are due
+func (svc *ServiceContext) jobWorker(workerID int, interval time.Duration) {
+	for {
+		job, err := svc.claimJob()
+		if err != nil {
+			log.Printf("ERROR: Worker %d unable to claim job: %s", workerID, err.Error())
+		}
+		if job == nil {
+			time.Sleep(interval)
+			continue
+		}
+		log.Printf("Worker %d running %s job %d for accession %d", workerID, job.Type, job.ID, job.AccessionID)
+		svc.runJob(job)
+	}
+}
+
+// claimJob marks the next due job as running and returns it. A unique claim token
+// ensures that only one worker can pick up a job. Returns nil if nothing is due
+func (svc *ServiceContext) claimJob() (*Job, error) {
+	token := xid.New().String()
+	q := svc.DB.NewQuery(`update jobs set status="running", claim_token={:token}, updated_at={:now}
+		where status="pending" and run_at <= {:now} order by run_at asc limit 1`)
+	q.Bind(dbx.Params{"token": token, "now": time.Now()})
+	res, err := q.Execute()
+	if err != nil {
+		return nil, err
+	}
+	if cnt, _ := res.RowsAffected(); cnt == 0 {
+		return nil, nil
+	}
+
+	var job Job
+	jq := svc.DB.NewQuery(`select j.*, a.identifier from jobs j
+		inner join accessions a on a.id = j.accession_id where claim_token={:token}`)
+	jq.Bind(dbx.Params{"token": token})
+	err = jq.One(&job)
+	if err != nil {
+		return nil, err
+	}
+	return &job, nil
+}
+
+// runJob executes a claimed job and records the result. Failures are retried with an
+// exponential backoff until the maximum number of attempts is reached.
+func (svc *ServiceContext) runJob(job *Job) {
+	done := make(chan struct{})
+	go svc.renewJobLease(job, done)
+	err := svc.executeJob(job)
+	close(done)
+	claim := job.ClaimToken
+	job.Attempts++
+	job.UpdatedAt = time.Now()
+	job.ClaimToken = ""
+	if err == nil {
+		log.Printf("Job %d (%s) for accession %d complete", job.ID, job.Type, job.AccessionID)
+		job.Status = "done"
+		job.LastError = ""
+		for _, next := range jobFollowups[job.Type] {
+			if qErr := EnqueueJob(svc.DB, job.AccessionID, next); qErr != nil {
+				log.Printf("ERROR: Unable to enqueue %s job for accession %d: %s", next, job.AccessionID, qErr.Error())
+			}
+		}
+	} else {
+		job.LastError = truncateJobError(err.Error())
+		_, fatal := err.(fatalJobError)
+		if fatal || job.Attempts >= job.MaxAttempts {
+			log.Printf("ERROR: Job %d (%s) for accession %d failed: %s", job.ID, job.Type, job.AccessionID, err.Error())
+			job.Status = "failed"
+		} else {
+			delay := jobRetryDelay(job.Attempts)
+			log.Printf("WARN: Job %d (%s) for accession %d failed; retry in %s: %s",
+				job.ID, job.Type, job.AccessionID, delay, err.Error())
+			job.Status = "pending"
+			job.RunAt = time.Now().Add(delay)
+		}
+	}
+
+	// the result is only recorded while the job is still claimed by this worker
+	res, uErr := svc.DB.Update("jobs", dbx.Params{"status": job.Status, "attempts": job.Attempts,
+		"last_error": job.LastError, "claim_token": "", "run_at": job.RunAt, "updated_at": job.UpdatedAt},
+		dbx.HashExp{"id": job.ID, "claim_token": claim}).Execute()
+	if uErr != nil {
+		log.Printf("ERROR: Unable to update job %d: %s", job.ID, uErr.Error())
+	} else if cnt, _ := res.RowsAffected(); cnt == 0 {
+		log.Printf("WARN: Job %d lost its claim before it finished; result not recorded", job.ID)
+	}
+}
+
+// truncateJobError shortens an error message to fit the last_error column
+func truncateJobError(msg string) string {
+	runes := []rune(msg)
+	if len(runes) <= maxJobErrorLength {
+		return msg
+	}
+	return string(runes[:maxJobErrorLength-3]) + "..."
+}
+
+// executeJob loads the accession for a job and calls the handler for the job type
+func (svc *ServiceContext) executeJob(job *Job) (err error) {
+	defer func() {
+		if r := recover(); r != nil {
+			err = fmt.Errorf("job panic: %v", r)
+		}
+	}()
+	handler, ok := jobHandlers[job.Type]
+	if ok == false {
+		return fatalJobError{fmt.Errorf("unknown job type %s", job.Type)}
+	}
+	accession, err := LoadAccession(svc.DB, job.AccessionID)
+	if err != nil {
+		return err
+	}
+	return handler(svc, accession)
+}
+
+// jobRetryDelay returns the backoff delay before the next attempt of a failed job
+func jobRetryDelay(attempts int) time.Duration {
+	delay := time.Duration(float64(jobBaseRetryDelay) * math.Pow(2, float64(attempts-1)))
+	if delay > jobMaxRetryDelay {
+		delay = jobMaxRetryDelay
+	}
+	return delay
+}
+
+// pendingDir returns the directory that holds uploads for an accession prior to submission
+func (svc *ServiceContext) pendingDir(accession *Accession) string {
+	return fmt.Sprintf("%s/%s/%s", svc.UploadDir, "pending", accession.Identifier)
+}
+
+// transferDir returns the directory that holds the submitted files for an accession.
+// The tree gets broken up by YYYY/MM of the submission before the identifier
+func (svc *ServiceContext) transferDir(accession *Accession) string {
+	return fmt.Sprintf("%s/%s/%s/%s", svc.UploadDir, "transferred",
+		accession.CreatedAt.Format("2006/01"), accession.Identifier)
+}
+
+// promoteFilesJob moves pending uploads into the transferred tree
+func promoteFilesJob(svc *ServiceContext, accession *Accession) error {
+	if accession.DigitalTransfer == false {
+		return nil
+	}
+	uploadDir := svc.pendingDir(accession)
+	tgtDir := svc.transferDir(accession)
+	if _, err := os.Stat(uploadDir); os.IsNotExist(err) {
+		if _, err := os.Stat(tgtDir); err == nil {
+			log.Printf("Files for %s have already been promoted", accession.Identifier)
+			return nil
+		}
+		return fatalJobError{fmt.Errorf("no pending or transferred files found for %s", accession.Identifier)}
+	}
+
+	err := os.MkdirAll(filepath.Dir(tgtDir), 0777)
+	if err != nil {
+		return err
+	}
+	log.Printf("Moving pending upload files from %s to %s", uploadDir, tgtDir)
+	return os.Rename(uploadDir, tgtDir)
+}
+
+// scanFilesJob runs a virus scan over the transferred files
+func scanFilesJob(svc *ServiceContext, accession *Accession) error {
+	if accession.DigitalTransfer == false {
+		return nil
+	}
+	if svc.ScanCommand == "" {
+		log.Printf("Virus scanning is disabled; skipping scan of %s", accession.Identifier)
+		return nil
+	}
+	tgtDir := svc.transferDir(accession)
+	log.Printf("Scanning %s with %s", tgtDir, svc.ScanCommand)
+	out, err := exec.Command(svc.ScanCommand, "--no-summary", "--infected", "-r", tgtDir).CombinedOutput()
+	if err != nil {
+		if exitErr, ok := err.(*exec.ExitError); ok && exitErr.ExitCode() == 1 {
+			return fatalJobError{fmt.Errorf("infected files found: %s", out)}
+		}
+		return fmt.Errorf("scan failed: %s %s", err.Error(), out)
+	}
+	return nil
+}
+
+// checksumFilesJob computes and stores a SHA256 checksum for each transferred file
+func checksumFilesJob(svc *ServiceContext, accession *Accession) error {
+	if accession.DigitalTransfer == false {
+		return nil
+	}
+	tgtDir := svc.transferDir(accession)
+	for _, fn := range accession.Digital.Files {
+		f, err := os.Open(fmt.Sprintf("%s/%s", tgtDir, fn))
+		if err != nil {
+			return err
+		}
+		hash := sha256.New()
+		_, err = io.Copy(hash, f)
+		f.Close()
+		if err != nil {
+			return err
+		}
+		q := svc.DB.NewQuery(`update digital_files set checksum={:sum}
+			where digital_accession_id={:id} and filename={:fn}`)
+		q.Bind(dbx.Params{"sum": fmt.Sprintf("%x", hash.Sum(nil)), "id": accession.Digital.ID, "fn": fn})
+		_, err = q.Execute()
+		if err != nil {
+			return err
+		}
+	}
+	return nil
+}
+
+// notifyJob generates and stores the PDF receipt, then sends the receipt email with
+// the PDF attached to the submitter and admins. The accession stays submitted until
+// staff acknowledge it
+func notifyJob(svc *ServiceContext, accession *Accession) error {
+	receipt, err := svc.WriteReceipt(accession)
+	if err != nil {
+		return err
+	}
+	return accession.User.SendReceiptEmail(svc.DB, svc.SMTP, svc.Hostname, accession, receipt)
+}
+
+// GetJobs is an admin API call that lists jobs a page at a time, most recently updated
+// first. By default only failed jobs are returned; use the status query param to select
+// others
+func (svc *ServiceContext) GetJobs(c *gin.Context) {
+	status := c.DefaultQuery("status", "failed")
+	pr, err := svc.parsePageRequest(c)
+	if err != nil {
+		c.String(http.StatusBadRequest, err.Error())
+		return
+	}
+	params := dbx.Params{"status": status}
+	keyset := pr.keysetQS("j.updated_at", "j.id", "desc", params)
+	if keyset != "" {
+		keyset = " and " + keyset
+	}
+	q := svc.DB.NewQuery(`select j.*, a.identifier from jobs j
+		inner join accessions a on a.id = j.accession_id
+		where j.status={:status}` + keyset + " order by j.updated_at desc, j.id desc" + pr.limitQS())
+	q.Bind(params)
+	type JobsPage struct {
+		PageSize   int    `json:"pageSize"`
+		NextCursor string `json:"nextCursor"`
+		Jobs       []Job  `json:"jobs"`
+	}
+	out := JobsPage{PageSize: pr.size, Jobs: make([]Job, 0)}
+	err = q.All(&out.Jobs)
+	if err != nil {
+		log.Printf("ERROR: Unable to get %s jobs: %s", status, err.Error())
+		c.String(http.StatusInternalServerError, err.Error())
+		return
+	}
+	if len(out.Jobs) > pr.size {
+		out.Jobs = out.Jobs[:pr.size]
+		last := out.Jobs[pr.size-1]
+		out.NextCursor = nextCursor(cursorValue(last.UpdatedAt), last.ID)
+	}
+	c.JSON(http.StatusOK, out)
+}
+
+// GetAccessionJobs is an admin API call that lists all jobs for an accession
+func (svc *ServiceContext) GetAccessionJobs(c *gin.Context) {
+	accessionID := c.Param("id")
+	q := svc.DB.NewQuery(`select j.*, a.identifier from jobs j
+		inner join accessions a on a.id = j.accession_id
+		where j.accession_id={:id} order by j.created_at asc`)
+	q.Bind(dbx.Params{"id": accessionID})
+	jobs := make([]Job, 0)
+	err := q.All(&jobs)
+	if err != nil {
+		log.Printf("ERROR: Unable to get jobs for accession %s: %s", accessionID, err.Error())
+		c.String(http.StatusInternalServerError, err.Error())
+		return
+	}
+	c.JSON(http.StatusOK, jobs)
+}
+
+// RetryAccessionJobs is an admin API call that resets all failed jobs for an accession
+// so they will be picked up again by the workers
+func (svc *ServiceContext) RetryAccessionJobs(c *gin.Context) {
+	accessionID := c.Param("id")
+	log.Printf("Retry failed jobs for accession %s", accessionID)
+	q := svc.DB.NewQuery(`update jobs set status="pending", attempts=0, last_error="", run_at={:now}, updated_at={:now}
+		where accession_id={:id} and status="failed"`)
+	q.Bind(dbx.Params{"id": accessionID, "now": time.Now()})
+	res, err := q.Execute()
+	if err != nil {
+		log.Printf("ERROR: Unable to retry jobs for accession %s: %s", accessionID, err.Error())
+		c.String(http.StatusInternalServerError, err.Error())
+		return
+	}
+	cnt, _ := res.RowsAffected()
+	c.String(http.StatusOK, "%d jobs queued", cnt)
+}
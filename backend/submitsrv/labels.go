@@ -0,0 +1,199 @@
+package main
+
+import (
+	"fmt"
+	"log"
+	"net/http"
+	"regexp"
+	"strconv"
+	"time"
+
+	"github.com/gin-gonic/gin"
+	dbx "github.com/go-ozzo/ozzo-dbx"
+	"github.com/go-pdf/fpdf"
+	"github.com/go-pdf/fpdf/contrib/barcode"
+)
+
+// Box labels are laid out 2 across and 3 down on a letter sized sheet (Avery 5164 style)
+const labelCols = 2
+const labelRows = 3
+const labelWidth = 4.0
+const labelHeight = 3.33
+const labelMarginX = 0.16
+const labelMarginY = 0.5
+const labelPad = 0.2
+
+// boxBarcodeRegex matches the value encoded in a box label barcode: the accession
+// identifier and inventory item ID separated by a dash
+var boxBarcodeRegex = regexp.MustCompile(`^([0-9a-v]{20})-(\d+)$`)
+
+// BoxBarcode returns the value encoded in the barcode for an inventory box
+func BoxBarcode(accession *Accession, item *InventoryItem) string {
+	return fmt.Sprintf("%s-%d", accession.Identifier, item.ID)
+}
+
+// GenerateBoxLabels renders a PDF sheet with one label for each inventory box of a
+// physical transfer. Each label has a Code128 barcode that identifies the box
+func (a *Accession) GenerateBoxLabels() *fpdf.Fpdf {
+	pdf := fpdf.New("P", "in", "Letter", "")
+	pdf.SetMargins(labelMarginX, labelMarginY, labelMarginX)
+	pdf.SetAutoPageBreak(false, 0)
+	pdf.SetTitle(fmt.Sprintf("Box Labels for %s", a.Identifier), true)
+	tr := pdf.UnicodeTranslatorFromDescriptor("")
+
+	perPage := labelCols * labelRows
+	for idx, item := range a.Physical.Inventory {
+		if idx%perPage == 0 {
+			pdf.AddPage()
+		}
+		slot := idx % perPage
+		x := labelMarginX + float64(slot%labelCols)*labelWidth
+		y := labelMarginY + float64(slot/labelCols)*labelHeight
+		innerW := labelWidth - 2*labelPad
+
+		pdf.SetDrawColor(200, 200, 200)
+		pdf.Rect(x, y, labelWidth, labelHeight, "D")
+
+		pdf.SetXY(x+labelPad, y+labelPad)
+		pdf.SetFont("Helvetica", "", 9)
+		pdf.CellFormat(innerW, 0.2, "UVA University Archives Records Transfer", "", 2, "L", false, 0, "")
+		pdf.SetFont("Helvetica", "B", 14)
+		pdf.CellFormat(innerW, 0.25, a.AccessionNumber, "", 2, "L", false, 0, "")
+		pdf.SetFont("Helvetica", "", 8)
+		pdf.CellFormat(innerW, 0.15, a.Identifier, "", 2, "L", false, 0, "")
+
+		pdf.SetFont("Helvetica", "B", 22)
+		pdf.CellFormat(innerW, 0.4, tr(fmt.Sprintf("Box %s", item.BoxNumber)), "", 2, "L", false, 0, "")
+
+		pdf.SetFont("Helvetica", "", 10)
+		if item.RecordGroup != "" {
+			pdf.CellFormat(innerW, 0.2, tr(fmt.Sprintf("Record Group: %s", item.RecordGroup)), "", 2, "L", false, 0, "")
+		}
+		pdf.MultiCell(innerW, 0.18, tr(truncateLabelText(item.Title, 120)), "", "L", false)
+
+		code := BoxBarcode(a, &item)
+		key := barcode.RegisterCode128(pdf, code)
+		barcode.Barcode(pdf, key, x+labelPad, y+labelHeight-labelPad-0.85, innerW, 0.6, false)
+		pdf.SetXY(x+labelPad, y+labelHeight-labelPad-0.22)
+		pdf.SetFont("Courier", "", 8)
+		pdf.CellFormat(innerW, 0.2, code, "", 0, "C", false, 0, "")
+	}
+	return pdf
+}
+
+// truncateLabelText shortens text so that it fits in the fixed space of a label
+func truncateLabelText(text string, max int) string {
+	runes := []rune(text)
+	if len(runes) <= max {
+		return text
+	}
+	return string(runes[:max-3]) + "..."
+}
+
+// sendBoxLabels writes the box label PDF for an accession to the response
+func (svc *ServiceContext) sendBoxLabels(c *gin.Context, accession *Accession) {
+	if accession.PhysicalTransfer == false || len(accession.Physical.Inventory) == 0 {
+		c.String(http.StatusBadRequest, "accession %s has no physical inventory", accession.Identifier)
+		return
+	}
+	log.Printf("Generate %d box labels for accession %s", len(accession.Physical.Inventory), accession.Identifier)
+	pdf := accession.GenerateBoxLabels()
+	c.Header("Content-Type", "application/pdf")
+	c.Header("Content-Disposition", fmt.Sprintf("attachment; filename=%s-labels.pdf", accession.Identifier))
+	err := pdf.Output(c.Writer)
+	if err != nil {
+		log.Printf("ERROR: Unable to generate box labels for %s: %s", accession.Identifier, err.Error())
+		c.String(http.StatusInternalServerError, err.Error())
+	}
+}
+
+// GetSubmitterBoxLabels returns the box label PDF for the accession with the access
+// token from the submitter's download link
+func (svc *ServiceContext) GetSubmitterBoxLabels(c *gin.Context) {
+	accession, err := LoadAccessionByToken(svc.DB, c.Param("token"))
+	if err != nil {
+		log.Printf("ERROR: No accession found for box label token: %s", err.Error())
+		c.String(http.StatusNotFound, "not found")
+		return
+	}
+	svc.sendBoxLabels(c, accession)
+}
+
+// GetBoxLabels is an admin API call that returns the box label PDF for an accession
+func (svc *ServiceContext) GetBoxLabels(c *gin.Context) {
+	accessionID := c.Param("id")
+	accession, err := LoadAccession(svc.DB, accessionID)
+	if err != nil {
+		log.Printf("ERROR: Unable to get accession %s: %s", accessionID, err.Error())
+		c.String(http.StatusNotFound, "accession %s not found", accessionID)
+		return
+	}
+	svc.sendBoxLabels(c, accession)
+}
+
+// ScanInventoryItem is an admin API call used by receiving staff to check off a box by
+// scanning the barcode from its label. The box is marked received unless another
+// status is specified. Boxes can't be scanned once receiving is closed
+func (svc *ServiceContext) ScanInventoryItem(c *gin.Context) {
+	var req struct {
+		Barcode string `json:"barcode" binding:"required"`
+		Status  string `json:"status"`
+	}
+	err := c.ShouldBindJSON(&req)
+	if err != nil {
+		c.String(http.StatusBadRequest, err.Error())
+		return
+	}
+	matches := boxBarcodeRegex.FindStringSubmatch(req.Barcode)
+	if matches == nil {
+		c.String(http.StatusBadRequest, "%s is not a valid box barcode", req.Barcode)
+		return
+	}
+	if req.Status == "" {
+		req.Status = "received"
+	}
+	if IsCheckStatus(req.Status) == false {
+		c.String(http.StatusBadRequest, "invalid status %s", req.Status)
+		return
+	}
+	itemID, _ := strconv.Atoi(matches[2])
+
+	var item InventoryItem
+	q := svc.DB.NewQuery(`select i.* from inventory_items i
+		inner join physical_accessions p on p.id = i.physical_accession_id
+		inner join accessions a on a.id = p.accession_id
+		where i.id={:item} and a.identifier={:identifier}`)
+	q.Bind(dbx.Params{"item": itemID, "identifier": matches[1]})
+	err = q.One(&item)
+	if err != nil {
+		log.Printf("ERROR: No inventory found for barcode %s: %s", req.Barcode, err.Error())
+		c.String(http.StatusNotFound, "no box found for barcode %s", req.Barcode)
+		return
+	}
+	var physical PhysicalAccession
+	err = svc.DB.Select("receipt_closed_at").From("physical_accessions").
+		Where(dbx.HashExp{"id": item.PhysAccessionID}).One(&physical)
+	if err != nil {
+		log.Printf("ERROR: Unable to get physical transfer %d: %s", item.PhysAccessionID, err.Error())
+		c.String(http.StatusInternalServerError, err.Error())
+		return
+	}
+	if physical.ReceiptClosedAt != nil {
+		c.String(http.StatusConflict, "receiving for the transfer of box %s is already closed", req.Barcode)
+		return
+	}
+
+	staff := GetAuthUser(c)
+	now := time.Now()
+	item.Status = req.Status
+	item.CheckedAt = &now
+	item.CheckedBy = &staff.ID
+	log.Printf("%s scanned box %s as %s", staff.Email, req.Barcode, item.Status)
+	err = svc.DB.Model(&item).Update("Status", "CheckedAt", "CheckedBy")
+	if err != nil {
+		log.Printf("ERROR: Unable to update inventory item %d: %s", item.ID, err.Error())
+		c.String(http.StatusInternalServerError, err.Error())
+		return
+	}
+	c.JSON(http.StatusOK, item)
+}
@@ -0,0 +1,222 @@
+package main
+
+import (
+	"fmt"
+	"log"
+	"net/http"
+	"time"
+
+	"github.com/gin-gonic/gin"
+	dbx "github.com/go-ozzo/ozzo-dbx"
+)
+
+// AccessionLink is a typed relationship from one accession to another. For example,
+// an accrual link means the accession is an accrual to the related accession
+type AccessionLink struct {
+	ID             int       `json:"id" db:"id"`
+	AccessionID    int       `json:"accessionID" db:"accession_id"`
+	RelatedID      int       `json:"relatedID" db:"related_accession_id"`
+	Type           string    `json:"type" db:"link_type"`
+	CreatedBy      *int      `json:"-" db:"created_by"`
+	CreatedAt      time.Time `json:"createdAt" db:"created_at"`
+	Direction      string    `json:"direction" db:"direction"`
+	RelatedNumber  string    `json:"relatedAccessionNumber" db:"related_number"`
+	RelatedSummary string    `json:"relatedSummary" db:"related_summary"`
+}
+
+// TableName defines the expected DB table name that holds data for accession links
+func (l *AccessionLink) TableName() string {
+	return "accession_links"
+}
+
+// IsLinkType returns true if the type is a supported accession link type
+func IsLinkType(linkType string) bool {
+	switch linkType {
+	case "accrual", "supersedes", "related":
+		return true
+	}
+	return false
+}
+
+// AccrualSummary is a brief description of one accession in an accrual chain
+type AccrualSummary struct {
+	ID              int       `json:"id" db:"id"`
+	AccessionNumber string    `json:"accessionNumber" db:"accession_number"`
+	Summary         string    `json:"summary" db:"description"`
+	CreatedAt       time.Time `json:"createdAt" db:"created_at"`
+}
+
+// GetLinks loads all links to and from this accession. Outgoing links are those made
+// by this accession (it is an accrual to another); incoming are made by other accessions
+func (a *Accession) GetLinks(db *dbx.DB) {
+	q := db.NewQuery(`select l.id, l.accession_id, l.related_accession_id, l.link_type, l.created_by, l.created_at,
+			"outgoing" as direction, r.accession_number as related_number,
+			r.description as related_summary
+		from accession_links l inner join accessions r on r.id = l.related_accession_id
+		where l.accession_id={:id}
+		union all
+		select l.id, l.related_accession_id, l.accession_id, l.link_type, l.created_by, l.created_at,
+			"incoming" as direction, r.accession_number as related_number, r.description as related_summary
+		from accession_links l inner join accessions r on r.id = l.accession_id
+		where l.related_accession_id={:id}
+		order by created_at asc`)
+	q.Bind(dbx.Params{"id": a.ID})
+	a.Links = make([]AccessionLink, 0)
+	err := q.All(&a.Links)
+	if err != nil {
+		log.Printf("ERROR: Unable to get links for accession %d: %s", a.ID, err.Error())
+	}
+}
+
+// GetAccrualChain finds the original accession that this accession accrues to, then
+// returns it and all accruals to it in submission order
+func (a *Accession) GetAccrualChain(db *dbx.DB) []AccrualSummary {
+	// walk up to the root; the visited set guards against link cycles
+	rootID := a.ID
+	visited := map[int]bool{rootID: true}
+	for {
+		var parent struct{ ID int }
+		q := db.NewQuery(`select related_accession_id as id from accession_links
+			where accession_id={:id} and link_type="accrual" limit 1`)
+		q.Bind(dbx.Params{"id": rootID})
+		if q.One(&parent) != nil || visited[parent.ID] {
+			break
+		}
+		rootID = parent.ID
+		visited[rootID] = true
+	}
+
+	// then collect the root and all accruals below it
+	chainIDs := []interface{}{rootID}
+	seen := map[int]bool{rootID: true}
+	for idx := 0; idx < len(chainIDs); idx++ {
+		q := db.NewQuery(`select accession_id as id from accession_links
+			where related_accession_id={:id} and link_type="accrual"`)
+		q.Bind(dbx.Params{"id": chainIDs[idx]})
+		var children []struct{ ID int }
+		q.All(&children)
+		for _, child := range children {
+			if seen[child.ID] == false {
+				seen[child.ID] = true
+				chainIDs = append(chainIDs, child.ID)
+			}
+		}
+	}
+
+	out := make([]AccrualSummary, 0)
+	if len(chainIDs) == 1 {
+		return out
+	}
+	q := db.Select("id", "accession_number", "description", "created_at").From("accessions").
+		Where(dbx.In("id", chainIDs...)).OrderBy("created_at asc")
+	err := q.All(&out)
+	if err != nil {
+		log.Printf("ERROR: Unable to get accrual chain for accession %d: %s", a.ID, err.Error())
+	}
+	return out
+}
+
+// WriteLinks writes the links selected by the submitter for a new accession
+func (a *Accession) WriteLinks(tx *dbx.Tx) error {
+	for _, link := range a.Links {
+		log.Printf("Link accession %d as %s to %d", a.ID, link.Type, link.RelatedID)
+		_, err := tx.Insert("accession_links", dbx.Params{
+			"accession_id":         a.ID,
+			"related_accession_id": link.RelatedID,
+			"link_type":            link.Type,
+			"created_at":           time.Now(),
+		}).Execute()
+		if err != nil {
+			return err
+		}
+	}
+	return nil
+}
+
+// GetUserAccessions returns a brief list of the earlier accessions submitted by or on
+// behalf of the signed in submitter so that one can be selected when submitting an accrual
+func (svc *ServiceContext) GetUserAccessions(c *gin.Context) {
+	user := GetAuthUser(c)
+	q := svc.DB.NewQuery(`select id, accession_number, description, created_at from accessions
+		where user_id={:id} or owner_id={:id} order by created_at desc`)
+	q.Bind(dbx.Params{"id": user.ID})
+	out := make([]AccrualSummary, 0)
+	err := q.All(&out)
+	if err != nil {
+		log.Printf("ERROR: Unable to get accessions for user %d: %s", user.ID, err.Error())
+		c.String(http.StatusInternalServerError, err.Error())
+		return
+	}
+	c.JSON(http.StatusOK, out)
+}
+
+// AddAccessionLink is an admin API call that links an accession to another
+func (svc *ServiceContext) AddAccessionLink(c *gin.Context) {
+	accessionID := c.Param("id")
+	var link AccessionLink
+	err := c.ShouldBindJSON(&link)
+	if err != nil {
+		c.String(http.StatusBadRequest, err.Error())
+		return
+	}
+	if IsLinkType(link.Type) == false {
+		c.String(http.StatusBadRequest, "invalid link type %s", link.Type)
+		return
+	}
+	accession, err := LoadAccession(svc.DB, accessionID)
+	if err != nil {
+		c.String(http.StatusNotFound, "accession %s not found", accessionID)
+		return
+	}
+	if accession.ID == link.RelatedID {
+		c.String(http.StatusBadRequest, "an accession cannot be linked to itself")
+		return
+	}
+	var related struct{ ID int }
+	err = svc.DB.Select("id").From("accessions").Where(dbx.HashExp{"id": link.RelatedID}).One(&related)
+	if err != nil {
+		c.String(http.StatusBadRequest, "related accession %d not found", link.RelatedID)
+		return
+	}
+
+	staff := GetAuthUser(c)
+	link.ID = 0
+	link.AccessionID = accession.ID
+	link.CreatedBy = &staff.ID
+	link.CreatedAt = time.Now()
+	log.Printf("%s linked accession %d as %s to %d", staff.Email, link.AccessionID, link.Type, link.RelatedID)
+	err = svc.DB.Model(&link).Exclude("Direction", "RelatedNumber", "RelatedSummary").Insert()
+	if err != nil {
+		log.Printf("ERROR: Unable to link accession %d to %d: %s", link.AccessionID, link.RelatedID, err.Error())
+		c.String(http.StatusInternalServerError, err.Error())
+		return
+	}
+	LogEvent(svc.DB, accession.ID, staff, "link", fmt.Sprintf("Linked as %s to accession %d", link.Type, link.RelatedID))
+	accession.GetLinks(svc.DB)
+	c.JSON(http.StatusOK, accession.Links)
+}
+
+// DeleteAccessionLink is an admin API call that removes a link to or from an accession
+func (svc *ServiceContext) DeleteAccessionLink(c *gin.Context) {
+	accessionID := c.Param("id")
+	linkID := c.Param("link")
+	var link AccessionLink
+	q := svc.DB.NewQuery(`select id, accession_id, related_accession_id, link_type from accession_links
+		where id={:link} and (accession_id={:id} or related_accession_id={:id})`)
+	q.Bind(dbx.Params{"id": accessionID, "link": linkID})
+	err := q.One(&link)
+	if err != nil {
+		c.String(http.StatusNotFound, "link %s not found", linkID)
+		return
+	}
+	_, err = svc.DB.Delete("accession_links", dbx.HashExp{"id": link.ID}).Execute()
+	if err != nil {
+		log.Printf("ERROR: Unable to delete link %s: %s", linkID, err.Error())
+		c.String(http.StatusInternalServerError, err.Error())
+		return
+	}
+	staff := GetAuthUser(c)
+	log.Printf("%s removed link %s from accession %s", staff.Email, linkID, accessionID)
+	LogEvent(svc.DB, link.AccessionID, staff, "unlink", fmt.Sprintf("Removed %s link to accession %d", link.Type, link.RelatedID))
+	c.String(http.StatusOK, "deleted")
+}
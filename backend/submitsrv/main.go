@@ -3,6 +3,7 @@ package main
 import (
 	"fmt"
 	"log"
+	"time"
 
 	"github.com/gin-gonic/contrib/static"
 	"github.com/gin-gonic/gin"
@@ -22,6 +23,13 @@ func main() {
 	cfg.Load()
 	svc := ServiceContext{}
 	svc.Init(&cfg)
+	if err := svc.NumberAccessions(); err != nil {
+		log.Printf("ERROR: Unable to number existing accessions: %s", err.Error())
+	}
+	if err := svc.IndexAccessions(); err != nil {
+		log.Printf("ERROR: Unable to index existing accessions: %s", err.Error())
+	}
+	svc.StartJobWorkers(cfg.JobWorkers, 5*time.Second)
 
 	log.Printf("Setup routes...")
 	gin.SetMode(gin.ReleaseMode)
@@ -37,19 +45,73 @@ func main() {
 		api.GET("/types", svc.GetTypes)
 		api.GET("/transfer-methods", svc.GetTransferMethods)
 		api.GET("/media-carriers", svc.GetMediaCarriers)
+		api.GET("/restriction-categories", svc.GetRestrictionCategories)
+		api.GET("/retention-schedules", svc.GetRetentionSchedules)
+		api.GET("/units", svc.GetOrgUnits)
+		api.GET("/inventory/template", svc.GetInventoryTemplate)
+		api.POST("/inventory/parse", svc.ParseInventoryUpload)
+		api.GET("/messages/:token", svc.GetSubmitterMessages)
+		api.POST("/messages/:token", svc.AddSubmitterMessage)
+		api.GET("/agreement", svc.GetAgreement)
 		api.POST("/submit", svc.Submit)
+		api.GET("/labels/:token", svc.GetSubmitterBoxLabels)
+		api.GET("/receipt/:token", svc.GetSubmitterReceipt)
 		api.POST("/upload", svc.UploadFile)
 		api.DELETE("/upload/:file", svc.DeleteUploadedFile)
 		api.GET("/users/lookup", svc.UserSearch)
 		api.POST("/users", svc.CreateUser)
+		api.GET("/user/accessions", svc.SubmitterMiddleware, svc.GetUserAccessions)
 		api.POST("/verify/:token", svc.VerifyUser)
 		api.POST("/resend/verification", svc.ResendVerification)
 		admin := api.Group("/admin")
 		{
 			admin.GET("/accessions", svc.AuthMiddleware, svc.GetAccessions)
+			admin.GET("/accessions/export", svc.AuthMiddleware, svc.ExportAccessions)
 			admin.GET("/accessions/:id", svc.AuthMiddleware, svc.GetAccessionDetail)
 			admin.GET("/accessions/:id/notes", svc.AuthMiddleware, svc.GetAccessionNotes)
 			admin.POST("/accessions/:id/notes", svc.AuthMiddleware, svc.AddAccessionNote)
+			admin.PUT("/accessions/:id/restriction", svc.AuthMiddleware, svc.UpdateRestriction)
+			admin.PUT("/accessions/:id/retention", svc.AuthMiddleware, svc.UpdateAccessionRetention)
+			admin.DELETE("/accessions/:id/restriction", svc.AuthMiddleware, svc.DeleteRestriction)
+			admin.POST("/accessions/:id/links", svc.AuthMiddleware, svc.AddAccessionLink)
+			admin.DELETE("/accessions/:id/links/:link", svc.AuthMiddleware, svc.DeleteAccessionLink)
+			admin.GET("/accessions/:id/history", svc.AuthMiddleware, svc.GetAccessionHistory)
+			admin.PUT("/accessions/:id/status", svc.AuthMiddleware, svc.UpdateAccessionStatus)
+			admin.POST("/accessions/:id/assignees", svc.AuthMiddleware, svc.AddAssignment)
+			admin.DELETE("/accessions/:id/assignees/:user", svc.AuthMiddleware, svc.DeleteAssignment)
+			admin.GET("/accessions/:id/messages", svc.AuthMiddleware, svc.GetAccessionMessages)
+			admin.POST("/accessions/:id/messages", svc.AuthMiddleware, svc.AddAccessionMessage)
+			admin.POST("/accessions/:id/attachments", svc.AuthMiddleware, svc.AddAttachment)
+			admin.GET("/accessions/:id/attachments/:attachment", svc.AuthMiddleware, svc.GetAttachment)
+			admin.DELETE("/accessions/:id/attachments/:attachment", svc.AuthMiddleware, svc.DeleteAttachment)
+			admin.GET("/accessions/:id/jobs", svc.AuthMiddleware, svc.GetAccessionJobs)
+			admin.POST("/accessions/:id/jobs/retry", svc.AuthMiddleware, svc.RetryAccessionJobs)
+			admin.POST("/accessions/:id/receive", svc.AuthMiddleware, svc.ReceivePhysicalAccession)
+			admin.POST("/accessions/:id/receive/close", svc.AuthMiddleware, svc.CloseReceiving)
+			admin.GET("/accessions/:id/discrepancies", svc.AuthMiddleware, svc.GetDiscrepancies)
+			admin.POST("/accessions/:id/inventory", svc.AuthMiddleware, svc.AddExtraInventoryItem)
+			admin.POST("/accessions/:id/inventory/import", svc.AuthMiddleware, svc.ImportInventory)
+			admin.GET("/accessions/:id/inventory/export", svc.AuthMiddleware, svc.ExportInventory)
+			admin.PUT("/accessions/:id/inventory/:item", svc.AuthMiddleware, svc.CheckInventoryItem)
+			admin.GET("/accessions/:id/labels", svc.AuthMiddleware, svc.GetBoxLabels)
+			admin.GET("/accessions/:id/receipt", svc.AuthMiddleware, svc.GetReceipt)
+			admin.POST("/inventory/scan", svc.AuthMiddleware, svc.ScanInventoryItem)
+			admin.GET("/jobs", svc.AuthMiddleware, svc.GetJobs)
+			admin.GET("/agreements", svc.AuthMiddleware, svc.GetAgreements)
+			admin.POST("/retention-schedules", svc.AuthMiddleware, svc.ImportRetentionSchedules)
+			admin.POST("/units", svc.AuthMiddleware, svc.ImportOrgUnits)
+			admin.GET("/stats", svc.AuthMiddleware, svc.GetStats)
+			admin.GET("/stats/units", svc.AuthMiddleware, svc.GetUnitStats)
+			admin.GET("/workload", svc.AuthMiddleware, svc.GetWorkload)
+			admin.POST("/search/reindex", svc.AuthMiddleware, svc.ReindexAccessions)
+			admin.GET("/searches", svc.AuthMiddleware, svc.GetSavedSearches)
+			admin.POST("/searches", svc.AuthMiddleware, svc.AddSavedSearch)
+			admin.PUT("/searches/:id", svc.AuthMiddleware, svc.UpdateSavedSearch)
+			admin.DELETE("/searches/:id", svc.AuthMiddleware, svc.DeleteSavedSearch)
+			admin.GET("/sla-rules", svc.AuthMiddleware, svc.GetSLARules)
+			admin.PUT("/sla-rules", svc.AuthMiddleware, svc.UpdateSLARules)
+			admin.GET("/retention/due", svc.AuthMiddleware, svc.GetDispositionReport)
+			admin.POST("/agreements", svc.AuthMiddleware, svc.AddAgreement)
 		}
 	}
 
@@ -0,0 +1,253 @@
+package main
+
+import (
+	"crypto/rand"
+	"encoding/hex"
+	"fmt"
+	"log"
+	"net/http"
+	"strings"
+	"time"
+
+	"github.com/gin-gonic/gin"
+	dbx "github.com/go-ozzo/ozzo-dbx"
+)
+
+// Message is one message in the conversation between archivists and the submitter of
+// an accession. Unlike notes, messages are seen by the submitter. ReadAt is set when
+// the other party views the message
+type Message struct {
+	ID          int        `json:"id" db:"id"`
+	AccessionID int        `json:"-" db:"accession_id"`
+	UserID      int        `json:"userID" db:"user_id"`
+	UserName    string     `json:"userName" db:"user_name"`
+	FromStaff   bool       `json:"fromStaff" db:"from_staff"`
+	Body        string     `json:"body" db:"body" binding:"required"`
+	CreatedAt   time.Time  `json:"createdAt" db:"created_at"`
+	ReadAt      *time.Time `json:"readAt" db:"read_at"`
+	NotifiedAt  *time.Time `json:"-" db:"notified_at"`
+}
+
+// TableName defines the expected DB table name that holds data for messages
+func (m *Message) TableName() string {
+	return "accession_messages"
+}
+
+// newToken generates a random token for the links sent to submitters, such as the
+// message and receipt links
+func newToken() string {
+	buf := make([]byte, 24)
+	rand.Read(buf)
+	return hex.EncodeToString(buf)
+}
+
+// GetMessages returns the message thread of an accession, oldest first
+func (a *Accession) GetMessages(db *dbx.DB) []Message {
+	q := db.NewQuery(`select m.*, concat(u.first_name,' ',u.last_name) as user_name
+		from accession_messages m inner join users u on u.id = m.user_id
+		where m.accession_id={:id} order by m.created_at asc, m.id asc`)
+	q.Bind(dbx.Params{"id": a.ID})
+	out := make([]Message, 0)
+	err := q.All(&out)
+	if err != nil {
+		log.Printf("ERROR: Unable to get messages for accession %d: %s", a.ID, err.Error())
+	}
+	return out
+}
+
+// markMessagesRead marks the messages of one party as read by the other
+func markMessagesRead(db *dbx.DB, accessionID int, fromStaff bool) {
+	q := db.NewQuery(`update accession_messages set read_at={:now}
+		where accession_id={:id} and from_staff={:staff} and read_at is null`)
+	q.Bind(dbx.Params{"now": time.Now(), "id": accessionID, "staff": fromStaff})
+	_, err := q.Execute()
+	if err != nil {
+		log.Printf("ERROR: Unable to mark messages read for accession %d: %s", accessionID, err.Error())
+	}
+}
+
+// addMessage writes a new message and queues the email notification for it
+func addMessage(db *dbx.DB, accession *Accession, sender *User, fromStaff bool, body string) (*Message, error) {
+	msg := Message{AccessionID: accession.ID, UserID: sender.ID, UserName: sender.FullName(),
+		FromStaff: fromStaff, Body: strings.TrimSpace(body), CreatedAt: time.Now()}
+	tx, _ := db.Begin()
+	err := tx.Model(&msg).Exclude("UserName", "ReadAt", "NotifiedAt").Insert()
+	if err == nil {
+		err = EnqueueJob(tx, accession.ID, "message")
+	}
+	if err != nil {
+		tx.Rollback()
+		return nil, err
+	}
+	if fromStaff {
+		LogEvent(tx, accession.ID, sender, "message", "Message sent to submitter")
+	} else {
+		LogEvent(tx, accession.ID, nil, "message", "Reply received from submitter")
+	}
+	tx.Commit()
+	return &msg, nil
+}
+
+// messageJob emails any messages that have not yet been sent. Staff messages go to the
+// submitter with their secure reply link; submitter replies go to the admins
+func messageJob(svc *ServiceContext, accession *Accession) error {
+	var pending []Message
+	q := svc.DB.NewQuery(`select m.*, concat(u.first_name,' ',u.last_name) as user_name
+		from accession_messages m inner join users u on u.id = m.user_id
+		where m.accession_id={:id} and m.notified_at is null order by m.created_at asc`)
+	q.Bind(dbx.Params{"id": accession.ID})
+	err := q.All(&pending)
+	if err != nil || len(pending) == 0 {
+		return err
+	}
+
+	var token struct{ Token *string }
+	tq := svc.DB.NewQuery("select message_token as token from accessions where id={:id}")
+	tq.Bind(dbx.Params{"id": accession.ID})
+	tq.One(&token)
+	if token.Token == nil {
+		return fatalJobError{fmt.Errorf("accession %d has no message token", accession.ID)}
+	}
+
+	for _, msg := range pending {
+		data := struct {
+			Accession *Accession
+			Message   Message
+			URL       string
+		}{Accession: accession, Message: msg,
+			URL: fmt.Sprintf("https://%s/messages/%s", svc.Hostname, *token.Token)}
+		if msg.FromStaff == false {
+			data.URL = fmt.Sprintf("https://%s/admin/accessions/%d", svc.Hostname, accession.ID)
+		}
+		body, err := RenderEmailTemplate("message_email.html", data)
+		if err != nil {
+			log.Printf("ERROR: Unable to render message email: %s", err.Error())
+			return err
+		}
+		subject := fmt.Sprintf("UVA Archives Transfer %s: New Message", accession.AccessionNumber)
+		req := EmailRequest{Subject: subject, To: accession.ContactEmails(), Body: body}
+		if msg.FromStaff == false {
+			req.To = GetAdminEmails(svc.DB)
+		}
+		err = svc.SMTP.SendEmail(req)
+		if err != nil {
+			return err
+		}
+		_, err = svc.DB.Update("accession_messages", dbx.Params{"notified_at": time.Now()},
+			dbx.HashExp{"id": msg.ID}).Execute()
+		if err != nil {
+			return err
+		}
+	}
+	return nil
+}
+
+// GetAccessionMessages is an admin API call that returns the message thread of an
+// accession. Viewing the thread marks the submitter's messages as read
+func (svc *ServiceContext) GetAccessionMessages(c *gin.Context) {
+	accessionID := c.Param("id")
+	accession, err := LoadAccession(svc.DB, accessionID)
+	if err != nil {
+		c.String(http.StatusNotFound, "accession %s not found", accessionID)
+		return
+	}
+	messages := accession.GetMessages(svc.DB)
+	markMessagesRead(svc.DB, accession.ID, false)
+	c.JSON(http.StatusOK, messages)
+}
+
+// AddAccessionMessage is an admin API call that sends a message to the submitter of an
+// accession. The secure link for the submitter's replies is created with the first message
+func (svc *ServiceContext) AddAccessionMessage(c *gin.Context) {
+	accessionID := c.Param("id")
+	var req Message
+	err := c.ShouldBindJSON(&req)
+	if err != nil || strings.TrimSpace(req.Body) == "" {
+		c.String(http.StatusBadRequest, "message body is required")
+		return
+	}
+	accession, err := LoadAccession(svc.DB, accessionID)
+	if err != nil {
+		c.String(http.StatusNotFound, "accession %s not found", accessionID)
+		return
+	}
+	q := svc.DB.NewQuery(`update accessions set message_token={:token} where id={:id} and message_token is null`)
+	q.Bind(dbx.Params{"token": newToken(), "id": accession.ID})
+	_, err = q.Execute()
+	if err != nil {
+		log.Printf("ERROR: Unable to create message token for accession %d: %s", accession.ID, err.Error())
+		c.String(http.StatusInternalServerError, err.Error())
+		return
+	}
+
+	staff := GetAuthUser(c)
+	msg, err := addMessage(svc.DB, accession, staff, true, req.Body)
+	if err != nil {
+		log.Printf("ERROR: Unable to add message to accession %d: %s", accession.ID, err.Error())
+		c.String(http.StatusInternalServerError, err.Error())
+		return
+	}
+	log.Printf("%s sent message %d to submitter of accession %d", staff.Email, msg.ID, accession.ID)
+	c.JSON(http.StatusOK, msg)
+}
+
+// getMessageAccession loads the accession for a submitter message token. An error
+// response is sent and nil returned if the token is not valid
+func (svc *ServiceContext) getMessageAccession(c *gin.Context) *Accession {
+	token := c.Param("token")
+	var found struct{ ID int }
+	q := svc.DB.NewQuery("select id from accessions where message_token={:token}")
+	q.Bind(dbx.Params{"token": token})
+	if token == "" || q.One(&found) != nil {
+		c.String(http.StatusNotFound, "message thread not found")
+		return nil
+	}
+	accession, err := LoadAccession(svc.DB, found.ID)
+	if err != nil {
+		log.Printf("ERROR: Unable to load accession %d for messages: %s", found.ID, err.Error())
+		c.String(http.StatusInternalServerError, err.Error())
+		return nil
+	}
+	return accession
+}
+
+// GetSubmitterMessages returns the message thread for the submitter's secure link.
+// Viewing the thread marks the archivists' messages as read
+func (svc *ServiceContext) GetSubmitterMessages(c *gin.Context) {
+	accession := svc.getMessageAccession(c)
+	if accession == nil {
+		return
+	}
+	type Thread struct {
+		AccessionNumber string    `json:"accessionNumber"`
+		Summary         string    `json:"summary"`
+		SubmittedAt     time.Time `json:"submittedAt"`
+		Messages        []Message `json:"messages"`
+	}
+	out := Thread{AccessionNumber: accession.AccessionNumber, Summary: accession.Summary,
+		SubmittedAt: accession.CreatedAt, Messages: accession.GetMessages(svc.DB)}
+	markMessagesRead(svc.DB, accession.ID, true)
+	c.JSON(http.StatusOK, out)
+}
+
+// AddSubmitterMessage accepts a reply from the submitter through their secure link
+func (svc *ServiceContext) AddSubmitterMessage(c *gin.Context) {
+	accession := svc.getMessageAccession(c)
+	if accession == nil {
+		return
+	}
+	var req Message
+	err := c.ShouldBindJSON(&req)
+	if err != nil || strings.TrimSpace(req.Body) == "" {
+		c.String(http.StatusBadRequest, "message body is required")
+		return
+	}
+	msg, err := addMessage(svc.DB, accession, &accession.User, false, req.Body)
+	if err != nil {
+		log.Printf("ERROR: Unable to add reply to accession %d: %s", accession.ID, err.Error())
+		c.String(http.StatusInternalServerError, err.Error())
+		return
+	}
+	log.Printf("Submitter replied to accession %d with message %d", accession.ID, msg.ID)
+	c.JSON(http.StatusOK, msg)
+}
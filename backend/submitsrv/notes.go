@@ -3,6 +3,7 @@ package main
 import (
 	"log"
 	"net/http"
+	"strconv"
 	"time"
 
 	"github.com/gin-gonic/gin"
@@ -49,6 +50,10 @@ func (svc *ServiceContext) AddAccessionNote(c *gin.Context) {
 	nq := tx.NewQuery("insert into accession_notes (accession_id,note_id) values ({:aid},{:nid})")
 	nq.Bind(dbx.Params{"aid": accessionID, "nid": note.ID})
 	_, err = nq.Execute()
+	if err == nil {
+		aid, _ := strconv.Atoi(accessionID)
+		err = EnqueueJob(tx, aid, "index")
+	}
 	if err != nil {
 		log.Printf("ERROR: Add accession_note failed: %s", err.Error())
 		tx.Rollback()
@@ -63,20 +68,40 @@ func (svc *ServiceContext) AddAccessionNote(c *gin.Context) {
 	c.JSON(http.StatusOK, note)
 }
 
-// GetAccessionNotes returns the details about notes for a particular accession
+// GetAccessionNotes returns a page of the notes for a particular accession, oldest first
 func (svc *ServiceContext) GetAccessionNotes(c *gin.Context) {
 	accessionID := c.Param("id")
+	pr, err := svc.parsePageRequest(c)
+	if err != nil {
+		c.String(http.StatusBadRequest, err.Error())
+		return
+	}
+	params := dbx.Params{"id": accessionID}
+	keyset := pr.keysetQS("n.created_at", "n.id", "asc", params)
+	if keyset != "" {
+		keyset = " and " + keyset
+	}
 	q := svc.DB.NewQuery(`select n.*,concat(u.first_name,' ',u.last_name) as user_name from notes n
 			inner join accession_notes an on an.note_id = n.id
 			inner join users u on u.id = n.user_id
-		where an.accession_id={:id}`)
-	q.Bind(dbx.Params{"id": accessionID})
-	var notes []Note
-	err := q.All(&notes)
+		where an.accession_id={:id}` + keyset + " order by n.created_at asc, n.id asc" + pr.limitQS())
+	q.Bind(params)
+	type NotesPage struct {
+		PageSize   int    `json:"pageSize"`
+		NextCursor string `json:"nextCursor"`
+		Notes      []Note `json:"notes"`
+	}
+	out := NotesPage{PageSize: pr.size, Notes: make([]Note, 0)}
+	err = q.All(&out.Notes)
 	if err != nil {
 		log.Printf("ERROR: Unable to get notes for accessions %s:%s", accessionID, err.Error())
 		c.String(http.StatusInternalServerError, err.Error())
 		return
 	}
-	c.JSON(http.StatusOK, notes)
+	if len(out.Notes) > pr.size {
+		out.Notes = out.Notes[:pr.size]
+		last := out.Notes[pr.size-1]
+		out.NextCursor = nextCursor(cursorValue(last.CreatedAt), last.ID)
+	}
+	c.JSON(http.StatusOK, out)
 }
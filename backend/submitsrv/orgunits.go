@@ -0,0 +1,226 @@
+package main
+
+import (
+	"encoding/csv"
+	"io"
+	"log"
+	"net/http"
+	"sort"
+	"strings"
+
+	"github.com/gin-gonic/gin"
+	dbx "github.com/go-ozzo/ozzo-dbx"
+)
+
+// OrgUnit is a school, department or office of the university. Units form a
+// hierarchy through ParentID; schools have no parent
+type OrgUnit struct {
+	ID       int    `json:"id" db:"id"`
+	Code     string `json:"code" db:"code"`
+	Name     string `json:"name" db:"name"`
+	Type     string `json:"type" db:"unit_type"`
+	ParentID *int   `json:"parentID" db:"parent_id"`
+}
+
+// TableName defines the expected DB table name that holds data for organizational units
+func (u *OrgUnit) TableName() string {
+	return "org_units"
+}
+
+// IsUnitType returns true if the type is a supported organizational unit type
+func IsUnitType(unitType string) bool {
+	switch unitType {
+	case "school", "department", "office":
+		return true
+	}
+	return false
+}
+
+// GetUnitName returns the name of an organizational unit, or a blank string for no unit
+func GetUnitName(db *dbx.DB, unitID *int) string {
+	if unitID == nil {
+		return ""
+	}
+	var unit OrgUnit
+	if db.Select().From("org_units").Where(dbx.HashExp{"id": *unitID}).One(&unit) != nil {
+		return ""
+	}
+	return unit.Name
+}
+
+// getUnitTree returns the IDs of a unit and all of the units below it in the hierarchy
+func getUnitTree(db *dbx.DB, unitID int) ([]int, error) {
+	var units []OrgUnit
+	err := db.Select("id", "parent_id").From("org_units").All(&units)
+	if err != nil {
+		return nil, err
+	}
+	children := make(map[int][]int)
+	for _, u := range units {
+		if u.ParentID != nil {
+			children[*u.ParentID] = append(children[*u.ParentID], u.ID)
+		}
+	}
+	out := []int{unitID}
+	seen := map[int]bool{unitID: true}
+	for idx := 0; idx < len(out); idx++ {
+		for _, child := range children[out[idx]] {
+			if seen[child] == false {
+				seen[child] = true
+				out = append(out, child)
+			}
+		}
+	}
+	return out, nil
+}
+
+// GetOrgUnits returns the list of organizational units as JSON
+func (svc *ServiceContext) GetOrgUnits(c *gin.Context) {
+	units := make([]OrgUnit, 0)
+	err := svc.DB.Select().From("org_units").OrderBy("name asc").All(&units)
+	if err != nil {
+		c.String(http.StatusInternalServerError, "Unable to retrive organizational units: %s", err.Error())
+		return
+	}
+	c.JSON(http.StatusOK, units)
+}
+
+// ImportOrgUnits is an admin API call that loads organizational units from an uploaded
+// CSV file. The first row must be a header naming the code, name, type and parent code
+// columns. Units are matched by code, so a file can be imported again to apply changes
+func (svc *ServiceContext) ImportOrgUnits(c *gin.Context) {
+	file, _, err := c.Request.FormFile("file")
+	if err != nil {
+		c.String(http.StatusBadRequest, "a CSV file is required: %s", err.Error())
+		return
+	}
+	defer file.Close()
+
+	reader := csv.NewReader(file)
+	reader.TrimLeadingSpace = true
+	header, err := reader.Read()
+	if err != nil {
+		c.String(http.StatusBadRequest, "unable to read CSV header: %s", err.Error())
+		return
+	}
+	cols := make(map[string]int)
+	for idx, name := range header {
+		name = strings.ToLower(strings.TrimSpace(name))
+		cols[strings.Replace(name, " ", "_", -1)] = idx
+	}
+	for _, name := range []string{"code", "name", "type", "parent_code"} {
+		if _, ok := cols[name]; ok == false {
+			c.String(http.StatusBadRequest, "CSV is missing the %s column", name)
+			return
+		}
+	}
+
+	// units are written first and parents are linked after, so a file does not
+	// need to list parents before their children
+	parents := make(map[string]string)
+	tx, _ := svc.DB.Begin()
+	for line := 2; ; line++ {
+		row, err := reader.Read()
+		if err == io.EOF {
+			break
+		}
+		if err != nil {
+			tx.Rollback()
+			c.String(http.StatusBadRequest, "invalid CSV at line %d: %s", line, err.Error())
+			return
+		}
+		unit := OrgUnit{
+			Code: strings.TrimSpace(row[cols["code"]]),
+			Name: strings.TrimSpace(row[cols["name"]]),
+			Type: strings.ToLower(strings.TrimSpace(row[cols["type"]])),
+		}
+		if unit.Code == "" {
+			continue
+		}
+		if IsUnitType(unit.Type) == false {
+			tx.Rollback()
+			c.String(http.StatusBadRequest, "invalid unit type %s at line %d", unit.Type, line)
+			return
+		}
+		parents[unit.Code] = strings.TrimSpace(row[cols["parent_code"]])
+		q := tx.NewQuery(`insert into org_units (code, name, unit_type) values ({:code}, {:name}, {:type})
+			on duplicate key update name=values(name), unit_type=values(unit_type)`)
+		q.Bind(dbx.Params{"code": unit.Code, "name": unit.Name, "type": unit.Type})
+		_, err = q.Execute()
+		if err != nil {
+			log.Printf("ERROR: Unable to import unit %s: %s", unit.Code, err.Error())
+			tx.Rollback()
+			c.String(http.StatusInternalServerError, err.Error())
+			return
+		}
+	}
+
+	for code, parentCode := range parents {
+		var parentID *int
+		if parentCode != "" {
+			var parent struct{ ID int }
+			q := tx.NewQuery("select id from org_units where code={:code}")
+			q.Bind(dbx.Params{"code": parentCode})
+			if q.One(&parent) != nil {
+				tx.Rollback()
+				c.String(http.StatusBadRequest, "parent unit %s of %s not found", parentCode, code)
+				return
+			}
+			parentID = &parent.ID
+		}
+		_, err = tx.Update("org_units", dbx.Params{"parent_id": parentID}, dbx.HashExp{"code": code}).Execute()
+		if err != nil {
+			log.Printf("ERROR: Unable to set parent of unit %s: %s", code, err.Error())
+			tx.Rollback()
+			c.String(http.StatusInternalServerError, err.Error())
+			return
+		}
+	}
+	tx.Commit()
+	log.Printf("%s imported %d organizational units", GetAuthUser(c).Email, len(parents))
+	c.String(http.StatusOK, "%d", len(parents))
+}
+
+// GetUnitStats is an admin API call that reports accession counts by organizational
+// unit. Total includes the accessions of all units below the unit in the hierarchy
+func (svc *ServiceContext) GetUnitStats(c *gin.Context) {
+	type UnitStats struct {
+		OrgUnit
+		Accessions int `json:"accessions" db:"accessions"`
+		Digital    int `json:"digital" db:"digital"`
+		Physical   int `json:"physical" db:"physical"`
+		Total      int `json:"total" db:"-"`
+	}
+	var stats []UnitStats
+	q := svc.DB.NewQuery(`select u.*, count(a.id) as accessions,
+			count(da.id) as digital, count(pa.id) as physical
+		from org_units u left outer join accessions a on a.unit_id = u.id
+			left outer join digital_accessions da on da.accession_id = a.id
+			left outer join physical_accessions pa on pa.accession_id = a.id
+		group by u.id`)
+	err := q.All(&stats)
+	if err != nil {
+		log.Printf("ERROR: Unable to get unit statistics: %s", err.Error())
+		c.String(http.StatusInternalServerError, err.Error())
+		return
+	}
+
+	// roll the direct counts up to every ancestor of each unit
+	byID := make(map[int]*UnitStats)
+	for idx := range stats {
+		byID[stats[idx].ID] = &stats[idx]
+	}
+	for _, unit := range stats {
+		seen := make(map[int]bool)
+		for cur := byID[unit.ID]; cur != nil && seen[cur.ID] == false; {
+			seen[cur.ID] = true
+			cur.Total += unit.Accessions
+			if cur.ParentID == nil {
+				break
+			}
+			cur = byID[*cur.ParentID]
+		}
+	}
+	sort.Slice(stats, func(i, j int) bool { return stats[i].Total > stats[j].Total })
+	c.JSON(http.StatusOK, stats)
+}
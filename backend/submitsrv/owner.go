@@ -0,0 +1,123 @@
+package main
+
+import (
+	"fmt"
+	"log"
+	"strings"
+	"time"
+
+	dbx "github.com/go-ozzo/ozzo-dbx"
+	"github.com/rs/xid"
+)
+
+// validateOwner checks the records owner of a delegated submission. Only a name and
+// email are required since the owner may not have used the transfer form before
+func (a *Accession) validateOwner(ve *ValidationErrors) {
+	if a.Owner == nil {
+		return
+	}
+	if strings.TrimSpace(a.Owner.FirstName) == "" || strings.TrimSpace(a.Owner.LastName) == "" {
+		ve.Add("owner", "The name of the records owner is required")
+	}
+	if strings.Contains(a.Owner.Email, "@") == false {
+		ve.Add("owner.email", "A valid email for the records owner is required")
+	}
+}
+
+// ResolveOwner links a delegated submission to the user account of the records owner.
+// An owner without an account gets an unverified one so they can later verify their
+// email and see the accession. An owner that is the submitter is ignored
+func (a *Accession) ResolveOwner(tx *dbx.Tx) error {
+	if a.Owner == nil {
+		return nil
+	}
+	email := strings.TrimSpace(a.Owner.Email)
+	if strings.EqualFold(email, a.User.Email) {
+		a.Owner = nil
+		a.OwnerID = nil
+		return nil
+	}
+
+	var existing User
+	q := tx.NewQuery("select * from users where email={:email}")
+	q.Bind(dbx.Params{"email": email})
+	if q.One(&existing) == nil {
+		log.Printf("Accession %s submitted on behalf of existing user %d", a.Identifier, existing.ID)
+		a.Owner = &existing
+		a.OwnerID = &existing.ID
+		return nil
+	}
+
+	log.Printf("Create contact for records owner %s", email)
+	token := xid.New().String()
+	owner := User{FirstName: a.Owner.FirstName, LastName: a.Owner.LastName, Title: a.Owner.Title,
+		Affiliation: a.Owner.Affiliation, Email: email, Phone: a.Owner.Phone, UnitID: a.UnitID,
+		VerifyToken: &token, CreatedAt: time.Now(), UpdatedAt: time.Now()}
+	owner.FormatPhone()
+	err := tx.Model(&owner).Insert()
+	if err != nil {
+		return err
+	}
+	a.Owner = &owner
+	a.OwnerID = &owner.ID
+	return nil
+}
+
+// ContactEmails returns the emails that correspondence about an accession is sent to:
+// the submitter and, for a delegated submission, the records owner
+func (a *Accession) ContactEmails() []string {
+	out := []string{a.User.Email}
+	if a.Owner != nil {
+		out = append(out, a.Owner.Email)
+	}
+	return out
+}
+
+// ownerNotifyJob sends the records owner of a delegated submission their own receipt.
+// An owner that has not verified their email is also asked to do so, which signs them
+// in to see the transfers made on their behalf
+func ownerNotifyJob(svc *ServiceContext, accession *Accession) error {
+	if accession.Owner == nil {
+		return nil
+	}
+	receipt, err := svc.WriteReceipt(accession)
+	if err != nil {
+		return err
+	}
+	err = accession.Owner.SendReceiptEmail(svc.DB, svc.SMTP, svc.Hostname, accession, receipt)
+	if err != nil {
+		return err
+	}
+	if accession.Owner.Verified || accession.Owner.VerifyToken == nil {
+		return nil
+	}
+	return svc.sendOwnerVerifyEmail(accession)
+}
+
+// ownerViewURL returns the link that signs a records owner in to see their transfers.
+// Owners with a verify token use it; others sign in with NetBadge
+func ownerViewURL(baseURL string, owner *User) string {
+	if owner.VerifyToken != nil {
+		return fmt.Sprintf("https://%s/verify/%s?next=transfers", baseURL, *owner.VerifyToken)
+	}
+	return fmt.Sprintf("https://%s/authenticate?url=/transfers", baseURL)
+}
+
+// sendOwnerVerifyEmail asks the unverified records owner of a delegated submission to
+// verify their email
+func (svc *ServiceContext) sendOwnerVerifyEmail(accession *Accession) error {
+	data := struct {
+		Owner     *User
+		Submitter *User
+		Accession *Accession
+		URL       string
+	}{Owner: accession.Owner, Submitter: &accession.User, Accession: accession,
+		URL: ownerViewURL(svc.Hostname, accession.Owner)}
+	body, err := RenderEmailTemplate("owner_verify_email.html", data)
+	if err != nil {
+		log.Printf("ERROR: Unable to render owner verify email: %s", err.Error())
+		return err
+	}
+	return svc.SMTP.SendEmail(EmailRequest{Subject: "UVA Archives Transfer Verification",
+		To: []string{accession.Owner.Email}, Body: body})
+}
@@ -0,0 +1,169 @@
+package main
+
+import (
+	"encoding/base64"
+	"encoding/json"
+	"fmt"
+	"strconv"
+	"sync"
+	"time"
+
+	"github.com/gin-gonic/gin"
+	dbx "github.com/go-ozzo/ozzo-dbx"
+)
+
+// Admin lists are paged by keyset rather than offset. Each page ends with an opaque
+// cursor holding the sort value and ID of its last row; the next page is the rows that
+// sort after it. The pageSize param selects the page size, up to the configured maximum
+
+const defaultPageSize = 50
+
+// countCacheTTL is how long list totals are reused before they are counted again
+const countCacheTTL = 60 * time.Second
+
+// pageCursor is the position of the last row of a page. Value is the sort value of the
+// row formatted for comparison in SQL, and nil if the row has no value
+type pageCursor struct {
+	Value *string `json:"v"`
+	ID    int     `json:"id"`
+}
+
+// pageRequest is the page size and starting cursor of a list request
+type pageRequest struct {
+	size   int
+	cursor *pageCursor
+}
+
+// parsePageRequest reads the pageSize and cursor params of a list request
+func (svc *ServiceContext) parsePageRequest(c *gin.Context) (*pageRequest, error) {
+	req := pageRequest{size: defaultPageSize}
+	if sizeStr := c.Query("pageSize"); sizeStr != "" {
+		size, err := strconv.Atoi(sizeStr)
+		if err != nil || size < 1 {
+			return nil, filterError{fmt.Errorf("invalid page size %s", sizeStr)}
+		}
+		req.size = size
+	}
+	if req.size > svc.MaxPageSize {
+		req.size = svc.MaxPageSize
+	}
+	if token := c.Query("cursor"); token != "" {
+		raw, err := base64.RawURLEncoding.DecodeString(token)
+		if err == nil {
+			req.cursor = &pageCursor{}
+			err = json.Unmarshal(raw, req.cursor)
+		}
+		if err != nil {
+			return nil, filterError{fmt.Errorf("invalid cursor")}
+		}
+	}
+	return &req, nil
+}
+
+// limitQS returns the limit clause for the page. One extra row is fetched to tell
+// whether there is a next page
+func (pr *pageRequest) limitQS() string {
+	return fmt.Sprintf(" limit %d", pr.size+1)
+}
+
+// nextCursor returns the cursor token for the row that ends a page
+func nextCursor(value *string, id int) string {
+	raw, _ := json.Marshal(pageCursor{Value: value, ID: id})
+	return base64.RawURLEncoding.EncodeToString(raw)
+}
+
+// keysetQS returns the condition that selects the rows after the cursor in a list
+// ordered by column and then id in the direction dir. MySQL sorts nulls first in
+// ascending order and last in descending order, so they are handled separately
+func (pr *pageRequest) keysetQS(column, idColumn, dir string, params dbx.Params) string {
+	if pr.cursor == nil {
+		return ""
+	}
+	params["cursorID"] = pr.cursor.ID
+	cmp, idCmp := ">", ">"
+	if dir == "desc" {
+		cmp, idCmp = "<", "<"
+	}
+	if pr.cursor.Value == nil {
+		if dir == "desc" {
+			return fmt.Sprintf("(%s is null and %s %s {:cursorID})", column, idColumn, idCmp)
+		}
+		return fmt.Sprintf("(%s is not null or %s %s {:cursorID})", column, idColumn, idCmp)
+	}
+	params["cursorValue"] = *pr.cursor.Value
+	cond := fmt.Sprintf("(%s %s {:cursorValue} or (%s = {:cursorValue} and %s %s {:cursorID})",
+		column, cmp, column, idColumn, idCmp)
+	if dir == "desc" {
+		cond += fmt.Sprintf(" or %s is null", column)
+	}
+	return cond + ")"
+}
+
+// cursorValue formats a sort value for comparison in SQL
+func cursorValue(val interface{}) *string {
+	var out string
+	switch v := val.(type) {
+	case nil:
+		return nil
+	case *time.Time:
+		if v == nil {
+			return nil
+		}
+		out = v.Format("2006-01-02 15:04:05.999999")
+	case time.Time:
+		out = v.Format("2006-01-02 15:04:05.999999")
+	case float64:
+		out = strconv.FormatFloat(v, 'g', -1, 64)
+	case bool:
+		out = "0"
+		if v {
+			out = "1"
+		}
+	default:
+		out = fmt.Sprintf("%v", v)
+	}
+	return &out
+}
+
+// countCache holds recent list totals so paging through a list does not recount it
+type countCache struct {
+	mutex  sync.Mutex
+	counts map[string]cachedCount
+}
+
+type cachedCount struct {
+	count   int
+	expires time.Time
+}
+
+// getCount returns the cached count for a query, or counts it with the query
+func (cc *countCache) getCount(db *dbx.DB, sql string, params dbx.Params) (int, error) {
+	key := sql + fmt.Sprintf("%v", params)
+	cc.mutex.Lock()
+	if cc.counts == nil {
+		cc.counts = make(map[string]cachedCount)
+	}
+	cached, ok := cc.counts[key]
+	cc.mutex.Unlock()
+	if ok && time.Now().Before(cached.expires) {
+		return cached.count, nil
+	}
+
+	count := 0
+	q := db.NewQuery(sql)
+	q.Bind(params)
+	err := q.Row(&count)
+	if err != nil {
+		return 0, err
+	}
+	cc.mutex.Lock()
+	now := time.Now()
+	for k, v := range cc.counts {
+		if now.After(v.expires) {
+			delete(cc.counts, k)
+		}
+	}
+	cc.counts[key] = cachedCount{count: count, expires: now.Add(countCacheTTL)}
+	cc.mutex.Unlock()
+	return count, nil
+}
@@ -0,0 +1,153 @@
+package main
+
+import (
+	"encoding/base64"
+	"net/http/httptest"
+	"net/url"
+	"strings"
+	"testing"
+
+	"github.com/gin-gonic/gin"
+	dbx "github.com/go-ozzo/ozzo-dbx"
+)
+
+// testPageRequest parses the page params of a list request with the given query
+func testPageRequest(query url.Values) (*pageRequest, error) {
+	gin.SetMode(gin.TestMode)
+	c, _ := gin.CreateTestContext(httptest.NewRecorder())
+	c.Request = httptest.NewRequest("GET", "/api/admin/accessions?"+query.Encode(), nil)
+	svc := ServiceContext{MaxPageSize: 200}
+	return svc.parsePageRequest(c)
+}
+
+func TestCursorRoundTrip(t *testing.T) {
+	strPtr := func(s string) *string { return &s }
+	tests := []struct {
+		name      string
+		value     *string
+		id        int
+		dir       string
+		wantQS    string
+		wantValue interface{}
+	}{
+		{"asc value", strPtr("2026-0042"), 17, "asc",
+			"(a.accession_number > {:cursorValue} or (a.accession_number = {:cursorValue} and a.id > {:cursorID}))", "2026-0042"},
+		{"desc value", strPtr("2026-03-01 10:15:00.5"), 9, "desc",
+			"(a.accession_number < {:cursorValue} or (a.accession_number = {:cursorValue} and a.id < {:cursorID}) or a.accession_number is null)",
+			"2026-03-01 10:15:00.5"},
+		{"empty value", strPtr(""), 3, "asc",
+			"(a.accession_number > {:cursorValue} or (a.accession_number = {:cursorValue} and a.id > {:cursorID}))", ""},
+		{"asc null", nil, 5, "asc", "(a.accession_number is not null or a.id > {:cursorID})", nil},
+		{"desc null", nil, 5, "desc", "(a.accession_number is null and a.id < {:cursorID})", nil},
+		{"unicode value", strPtr("Smith, Zoë"), 1, "asc",
+			"(a.accession_number > {:cursorValue} or (a.accession_number = {:cursorValue} and a.id > {:cursorID}))", "Smith, Zoë"},
+	}
+	for _, tt := range tests {
+		token := nextCursor(tt.value, tt.id)
+		pr, err := testPageRequest(url.Values{"cursor": {token}})
+		if err != nil {
+			t.Errorf("%s: cursor %s did not parse: %s", tt.name, token, err.Error())
+			continue
+		}
+		if pr.cursor == nil || pr.cursor.ID != tt.id {
+			t.Errorf("%s: cursor %+v, want ID %d", tt.name, pr.cursor, tt.id)
+			continue
+		}
+		params := dbx.Params{}
+		qs := pr.keysetQS("a.accession_number", "a.id", tt.dir, params)
+		if qs != tt.wantQS {
+			t.Errorf("%s: keysetQS = %s, want %s", tt.name, qs, tt.wantQS)
+		}
+		if params["cursorID"] != tt.id {
+			t.Errorf("%s: cursorID = %v, want %d", tt.name, params["cursorID"], tt.id)
+		}
+		if got, ok := params["cursorValue"]; tt.wantValue == nil && ok {
+			t.Errorf("%s: cursorValue = %v, want none", tt.name, got)
+		} else if tt.wantValue != nil && got != tt.wantValue {
+			t.Errorf("%s: cursorValue = %v, want %v", tt.name, got, tt.wantValue)
+		}
+	}
+}
+
+func TestBadCursor(t *testing.T) {
+	encode := func(s string) string { return base64.RawURLEncoding.EncodeToString([]byte(s)) }
+	whole := nextCursor(nil, 12345)
+	tests := []struct {
+		name   string
+		cursor string
+	}{
+		{"not base64", "!!not-a-cursor!!"},
+		{"padded base64", base64.URLEncoding.EncodeToString([]byte(`{"v":"a","id":1}`))},
+		{"not json", encode("hello")},
+		{"truncated", whole[:len(whole)-4]},
+		{"id not a number", encode(`{"v":"a","id":"1 or 1=1"}`)},
+		{"value not a string", encode(`{"v":5,"id":1}`)},
+		{"json array", encode(`[1,2]`)},
+	}
+	for _, tt := range tests {
+		pr, err := testPageRequest(url.Values{"cursor": {tt.cursor}})
+		if err == nil {
+			t.Errorf("%s: cursor %q parsed as %+v, want error", tt.name, tt.cursor, pr.cursor)
+			continue
+		}
+		if _, ok := err.(filterError); ok == false {
+			t.Errorf("%s: error is not a filterError: %s", tt.name, err.Error())
+		}
+	}
+}
+
+func TestTamperedCursorValueIsBound(t *testing.T) {
+	value := "x' or '1'='1"
+	token := base64.RawURLEncoding.EncodeToString([]byte(`{"v":"x' or '1'='1","id":7}`))
+	pr, err := testPageRequest(url.Values{"cursor": {token}})
+	if err != nil {
+		t.Fatalf("cursor did not parse: %s", err.Error())
+	}
+	params := dbx.Params{}
+	qs := pr.keysetQS("submitter", "id", "asc", params)
+	if strings.Contains(qs, value) {
+		t.Errorf("keysetQS includes the cursor value in the SQL: %s", qs)
+	}
+	if params["cursorValue"] != value {
+		t.Errorf("cursorValue = %v, want %s", params["cursorValue"], value)
+	}
+}
+
+func TestPageSize(t *testing.T) {
+	tests := []struct {
+		size string
+		want int
+		err  bool
+	}{
+		{"", defaultPageSize, false},
+		{"25", 25, false},
+		{"200", 200, false},
+		{"500", 200, false},
+		{"0", 0, true},
+		{"-5", 0, true},
+		{"ten", 0, true},
+	}
+	for _, tt := range tests {
+		query := url.Values{}
+		if tt.size != "" {
+			query.Set("pageSize", tt.size)
+		}
+		pr, err := testPageRequest(query)
+		if tt.err {
+			if err == nil {
+				t.Errorf("pageSize %q = %d, want error", tt.size, pr.size)
+			}
+			continue
+		}
+		if err != nil {
+			t.Errorf("pageSize %q unexpected error: %s", tt.size, err.Error())
+			continue
+		}
+		if pr.size != tt.want {
+			t.Errorf("pageSize %q = %d, want %d", tt.size, pr.size, tt.want)
+		}
+		if pr.cursor != nil {
+			t.Errorf("pageSize %q has cursor %+v with no cursor param", tt.size, pr.cursor)
+		}
+	}
+}
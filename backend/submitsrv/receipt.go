@@ -0,0 +1,259 @@
+package main
+
+import (
+	"bytes"
+	"fmt"
+	"io/ioutil"
+	"log"
+	"net/http"
+	"os"
+	"path/filepath"
+	"strings"
+
+	"github.com/gin-gonic/gin"
+	"github.com/go-pdf/fpdf"
+)
+
+// receiptPDF wraps the PDF being generated for a receipt along with the unicode translator
+// needed to render user supplied text in the core fonts
+type receiptPDF struct {
+	pdf *fpdf.Fpdf
+	tr  func(string) string
+}
+
+// heading adds a section heading to the receipt
+func (r *receiptPDF) heading(text string) {
+	r.pdf.Ln(0.1)
+	r.pdf.SetFont("Helvetica", "B", 13)
+	r.pdf.CellFormat(0, 0.3, r.tr(text), "B", 1, "L", false, 0, "")
+	r.pdf.Ln(0.05)
+}
+
+// field adds a labelled value to the receipt. Blank values are skipped
+func (r *receiptPDF) field(label string, value string) {
+	if strings.TrimSpace(value) == "" {
+		return
+	}
+	r.pdf.SetFont("Helvetica", "B", 10)
+	r.pdf.CellFormat(2.0, 0.22, r.tr(label), "", 0, "L", false, 0, "")
+	r.pdf.SetFont("Helvetica", "", 10)
+	r.pdf.MultiCell(0, 0.22, r.tr(value), "", "L", false)
+}
+
+// inventoryTable adds a table listing all of the inventory boxes for a physical transfer
+func (r *receiptPDF) inventoryTable(inventory []InventoryItem) {
+	widths := []float64{0.8, 1.2, 2.2, 2.3, 1.0}
+	headers := []string{"Box", "Record Group", "Title", "Description", "Dates"}
+	r.pdf.SetFont("Helvetica", "B", 9)
+	for i, hdr := range headers {
+		r.pdf.CellFormat(widths[i], 0.25, hdr, "1", 0, "L", false, 0, "")
+	}
+	r.pdf.Ln(-1)
+	r.pdf.SetFont("Helvetica", "", 9)
+	for _, item := range inventory {
+		vals := []string{item.BoxNumber, item.RecordGroup, item.Title, item.Description, item.Dates}
+		for i, val := range vals {
+			r.pdf.CellFormat(widths[i], 0.22, r.tr(truncateLabelText(val, int(widths[i]*14))), "1", 0, "L", false, 0, "")
+		}
+		r.pdf.Ln(-1)
+	}
+}
+
+// signatures adds sign off lines for the submitter and receiving archivist
+func (r *receiptPDF) signatures() {
+	r.pdf.Ln(0.4)
+	r.pdf.SetFont("Helvetica", "", 10)
+	for _, who := range []string{"Transferred by", "Received by (University Archives)"} {
+		r.pdf.CellFormat(4.0, 0.3, "", "B", 0, "L", false, 0, "")
+		r.pdf.CellFormat(0.5, 0.3, "", "", 0, "L", false, 0, "")
+		r.pdf.CellFormat(2.0, 0.3, "", "B", 1, "L", false, 0, "")
+		r.pdf.CellFormat(4.0, 0.22, who, "", 0, "L", false, 0, "")
+		r.pdf.CellFormat(0.5, 0.22, "", "", 0, "L", false, 0, "")
+		r.pdf.CellFormat(2.0, 0.22, "Date", "", 1, "L", false, 0, "")
+		r.pdf.Ln(0.3)
+	}
+}
+
+// GenerateReceipt renders a PDF transfer receipt for an accession. Physical transfers also
+// get a packing slip page listing every inventory box. The accession is expected to
+// have been read with LoadAccession so that vocabulary names are present
+func (a *Accession) GenerateReceipt() *fpdf.Fpdf {
+	pdf := fpdf.New("P", "in", "Letter", "")
+	pdf.SetMargins(0.75, 0.75, 0.75)
+	pdf.SetAutoPageBreak(true, 0.75)
+	pdf.SetTitle(fmt.Sprintf("Transfer Receipt for %s", a.Identifier), true)
+	pdf.SetFooterFunc(func() {
+		pdf.SetY(-0.6)
+		pdf.SetFont("Helvetica", "I", 8)
+		pdf.CellFormat(0, 0.2, fmt.Sprintf("Transfer %s - page %d", a.Identifier, pdf.PageNo()), "", 0, "C", false, 0, "")
+	})
+	r := receiptPDF{pdf: pdf, tr: pdf.UnicodeTranslatorFromDescriptor("")}
+
+	pdf.AddPage()
+	pdf.SetFont("Helvetica", "B", 16)
+	pdf.CellFormat(0, 0.35, "University of Virginia Library", "", 1, "C", false, 0, "")
+	pdf.CellFormat(0, 0.35, "University Archives Records Transfer Receipt", "", 1, "C", false, 0, "")
+
+	r.heading("General Information")
+	r.field("Accession Number:", a.AccessionNumber)
+	r.field("Transfer Identifier:", a.Identifier)
+	r.field("Transfer Date/Time:", a.CreatedAt.Format("2006-01-02 15:04 MST"))
+	r.field("Submitted By:", fmt.Sprintf("%s, %s", a.User.FullName(), a.User.Title))
+	r.field("Affiliation:", a.User.Affiliation)
+	if a.Owner != nil {
+		r.field("Records Owner:", fmt.Sprintf("%s, %s", a.Owner.FullName(), a.Owner.Email))
+	}
+	r.field("On Behalf Of:", a.Unit)
+	r.field("Contact:", fmt.Sprintf("%s %s", a.User.Email, a.User.Phone))
+	r.field("Accession Type:", a.Type)
+	r.field("Summary:", a.Summary)
+	if a.Activities != nil {
+		r.field("Activities:", *a.Activities)
+	}
+	if a.Creator != nil {
+		r.field("Creator:", *a.Creator)
+	}
+	r.field("Genres:", strings.Join(a.Genres, ", "))
+
+	if a.DigitalTransfer {
+		r.heading("Digital Transfer")
+		r.field("Technical Description:", a.Digital.Description)
+		if a.Digital.DateRange != nil {
+			r.field("Date Range of Files:", *a.Digital.DateRange)
+		}
+		r.field("Record Types:", strings.Join(a.Digital.RecordTypes, ", "))
+		r.field("Total Transfer Size:", fmt.Sprintf("%.2fMB", float32(a.Digital.TotalSize)/1000.0/1000.0))
+		r.field("Files:", strings.Join(a.Digital.Files, ", "))
+	}
+
+	if a.PhysicalTransfer {
+		r.heading("Physical Transfer")
+		r.field("Date Range of Records:", a.Physical.DateRange)
+		r.field("Number and Size of Boxes:", a.Physical.BoxInfo)
+		r.field("Record Types:", strings.Join(a.Physical.RecordTypes, ", "))
+		r.field("Transfer Method:", a.Physical.TransferMethod)
+		if a.Physical.HasDigital {
+			r.field("Technical Description:", a.Physical.TechInfo)
+			r.field("Media Carriers:", strings.Join(a.Physical.MediaCarriers, ", "))
+			r.field("Media Carrier Estimates:", a.Physical.MediaCount)
+			r.field("Includes Software:", a.Physical.HasSoftware)
+		}
+		r.field("Inventory:", fmt.Sprintf("%d boxes (see packing slip)", len(a.Physical.Inventory)))
+	}
+	if a.RetentionSchedule != nil {
+		r.heading("Retention")
+		r.field("Retention Schedule:", fmt.Sprintf("%s %s", a.RetentionSchedule.ScheduleNumber, a.RetentionSchedule.SeriesTitle))
+		r.field("Retention Period:", a.RetentionSchedule.RetentionPeriod)
+		r.field("Disposition:", a.RetentionSchedule.Disposition)
+		if a.DispositionDate != nil {
+			r.field("Disposition Date:", a.DispositionDate.Format("2006-01-02"))
+		}
+	}
+	if a.Restriction != nil {
+		r.heading("Access Restriction")
+		r.field("Category:", fmt.Sprintf("%s (%s)", a.Restriction.Category, a.Restriction.Status))
+		if a.Restriction.RestrictedUntil != nil {
+			r.field("Restricted Until:", a.Restriction.RestrictedUntil.Format("2006-01-02"))
+		}
+		r.field("Justification:", a.Restriction.Justification)
+	}
+	if a.Agreement != nil {
+		r.heading("Transfer Agreement")
+		r.field("Agreement:", fmt.Sprintf("%s (version %s)", a.Agreement.Title, a.Agreement.Version))
+		r.field("Accepted:", fmt.Sprintf("%s by %s from %s", a.Agreement.AcceptedAt.Format("2006-01-02 15:04 MST"),
+			a.User.FullName(), a.Agreement.IPAddress))
+		pdf.SetFont("Helvetica", "", 9)
+		pdf.MultiCell(0, 0.18, r.tr(a.Agreement.Body), "", "L", false)
+	}
+	r.signatures()
+
+	if a.PhysicalTransfer {
+		pdf.AddPage()
+		pdf.SetFont("Helvetica", "B", 16)
+		pdf.CellFormat(0, 0.35, "Packing Slip", "", 1, "C", false, 0, "")
+		pdf.SetFont("Helvetica", "I", 11)
+		pdf.CellFormat(0, 0.3, "Place this packing slip inside Box 1", "", 1, "C", false, 0, "")
+		r.heading(fmt.Sprintf("Accession %s", a.AccessionNumber))
+		r.field("Transfer Identifier:", a.Identifier)
+		r.field("Submitted By:", a.User.FullName())
+		r.field("Transfer Date/Time:", a.CreatedAt.Format("2006-01-02 15:04 MST"))
+		r.field("Transfer Method:", a.Physical.TransferMethod)
+		r.field("Number of Boxes:", fmt.Sprintf("%d", len(a.Physical.Inventory)))
+		r.heading("Box Inventory")
+		r.inventoryTable(a.Physical.Inventory)
+	}
+	return pdf
+}
+
+// ReceiptFilename returns the name of the PDF receipt for an accession
+func ReceiptFilename(accession *Accession) string {
+	return fmt.Sprintf("%s-receipt.pdf", accession.Identifier)
+}
+
+// receiptFile returns the full path to the stored PDF receipt for an accession
+func (svc *ServiceContext) receiptFile(accession *Accession) string {
+	return fmt.Sprintf("%s/%s/%s/%s", svc.UploadDir, "receipts",
+		accession.CreatedAt.Format("2006/01"), ReceiptFilename(accession))
+}
+
+// WriteReceipt generates the PDF receipt for an accession and stores it in the receipts
+// area of the upload directory. The PDF data is returned
+func (svc *ServiceContext) WriteReceipt(accession *Accession) ([]byte, error) {
+	log.Printf("Generate PDF receipt for accession %s", accession.Identifier)
+	var buf bytes.Buffer
+	err := accession.GenerateReceipt().Output(&buf)
+	if err != nil {
+		return nil, err
+	}
+	tgt := svc.receiptFile(accession)
+	err = os.MkdirAll(filepath.Dir(tgt), 0777)
+	if err != nil {
+		return nil, err
+	}
+	err = ioutil.WriteFile(tgt, buf.Bytes(), 0666)
+	if err != nil {
+		return nil, err
+	}
+	return buf.Bytes(), nil
+}
+
+// sendReceipt writes the stored PDF receipt for an accession to the response. If
+// the receipt has not been generated yet, it is created now
+func (svc *ServiceContext) sendReceipt(c *gin.Context, accession *Accession) {
+	data, err := ioutil.ReadFile(svc.receiptFile(accession))
+	if err != nil {
+		log.Printf("Receipt for %s not found; generating it", accession.Identifier)
+		data, err = svc.WriteReceipt(accession)
+		if err != nil {
+			log.Printf("ERROR: Unable to generate receipt for %s: %s", accession.Identifier, err.Error())
+			c.String(http.StatusInternalServerError, err.Error())
+			return
+		}
+	}
+	c.Header("Content-Disposition", fmt.Sprintf("attachment; filename=%s", ReceiptFilename(accession)))
+	c.Data(http.StatusOK, "application/pdf", data)
+}
+
+// GetSubmitterReceipt returns the PDF receipt for the accession with the access token
+// from the submitter's download link
+func (svc *ServiceContext) GetSubmitterReceipt(c *gin.Context) {
+	accession, err := LoadAccessionByToken(svc.DB, c.Param("token"))
+	if err != nil {
+		log.Printf("ERROR: No accession found for receipt token: %s", err.Error())
+		c.String(http.StatusNotFound, "not found")
+		return
+	}
+	svc.sendReceipt(c, accession)
+}
+
+// GetReceipt is an admin API call that returns the PDF receipt for an accession
+func (svc *ServiceContext) GetReceipt(c *gin.Context) {
+	accessionID := c.Param("id")
+	accession, err := LoadAccession(svc.DB, accessionID)
+	if err != nil {
+		log.Printf("ERROR: Unable to get accession %s: %s", accessionID, err.Error())
+		c.String(http.StatusNotFound, "accession %s not found", accessionID)
+		return
+	}
+	svc.sendReceipt(c, accession)
+}
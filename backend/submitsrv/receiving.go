@@ -0,0 +1,298 @@
+package main
+
+import (
+	"log"
+	"net/http"
+	"time"
+
+	"github.com/gin-gonic/gin"
+	dbx "github.com/go-ozzo/ozzo-dbx"
+)
+
+// DiscrepancyReport lists the differences between the promised physical inventory
+// of an accession and what was actually received
+type DiscrepancyReport struct {
+	Accession *Accession      `json:"-"`
+	Missing   []InventoryItem `json:"missing"`
+	Damaged   []InventoryItem `json:"damaged"`
+	Extra     []InventoryItem `json:"extra"`
+}
+
+// HasDiscrepancies returns true if any boxes were missing, damaged or unexpected
+func (dr *DiscrepancyReport) HasDiscrepancies() bool {
+	return len(dr.Missing) > 0 || len(dr.Damaged) > 0 || len(dr.Extra) > 0
+}
+
+// GetDiscrepancyReport builds a discrepancy report from the checked inventory of a physical accession
+func (a *Accession) GetDiscrepancyReport() *DiscrepancyReport {
+	out := DiscrepancyReport{Accession: a, Missing: make([]InventoryItem, 0),
+		Damaged: make([]InventoryItem, 0), Extra: make([]InventoryItem, 0)}
+	for _, item := range a.Physical.Inventory {
+		switch item.Status {
+		case "missing":
+			out.Missing = append(out.Missing, item)
+		case "damaged":
+			out.Damaged = append(out.Damaged, item)
+		case "extra":
+			out.Extra = append(out.Extra, item)
+		}
+	}
+	return &out
+}
+
+// SendDiscrepancyEmail sends the receiving discrepancy report to the submitter and records
+// owner (and admins)
+func (dr *DiscrepancyReport) SendDiscrepancyEmail(db *dbx.DB, smtpCfg SMTPConfig) error {
+	body, err := RenderEmailTemplate("discrepancy_email.html", dr)
+	if err != nil {
+		log.Printf("ERROR: Unable to render discrepancy email: %s", err.Error())
+		return err
+	}
+	return smtpCfg.SendEmail(EmailRequest{Subject: "UVA Archives Transfer Receiving Report",
+		To: dr.Accession.ContactEmails(), BCC: GetAdminEmails(db), Body: body})
+}
+
+// discrepancyJob sends the discrepancy report for a physical accession that has been received
+func discrepancyJob(svc *ServiceContext, accession *Accession) error {
+	report := accession.GetDiscrepancyReport()
+	if report.HasDiscrepancies() == false {
+		return nil
+	}
+	return report.SendDiscrepancyEmail(svc.DB, svc.SMTP)
+}
+
+// IsCheckStatus returns true if the status can be assigned when checking off an inventory box
+func IsCheckStatus(status string) bool {
+	switch status {
+	case "received", "missing", "damaged":
+		return true
+	}
+	return false
+}
+
+// getPhysicalAccession loads an accession for the receiving workflow, ensuring it has a
+// physical transfer. An error response is sent and nil returned if not
+func (svc *ServiceContext) getPhysicalAccession(c *gin.Context) *Accession {
+	accessionID := c.Param("id")
+	accession, err := LoadAccession(svc.DB, accessionID)
+	if err != nil {
+		log.Printf("ERROR: Unable to get accession %s: %s", accessionID, err.Error())
+		c.String(http.StatusNotFound, "accession %s not found", accessionID)
+		return nil
+	}
+	if accession.PhysicalTransfer == false {
+		log.Printf("ERROR: Accession %s has no physical transfer", accessionID)
+		c.String(http.StatusBadRequest, "accession %s has no physical transfer", accessionID)
+		return nil
+	}
+	return accession
+}
+
+// getOpenPhysicalAccession loads a physical accession whose inventory may still be
+// changed. Once receiving is closed and the discrepancy report has gone out, a conflict
+// response is sent and nil returned
+func (svc *ServiceContext) getOpenPhysicalAccession(c *gin.Context) *Accession {
+	accession := svc.getPhysicalAccession(c)
+	if accession == nil {
+		return nil
+	}
+	if accession.Physical.ReceiptClosedAt != nil {
+		c.String(http.StatusConflict, "receiving for accession %d is already closed", accession.ID)
+		return nil
+	}
+	return accession
+}
+
+// ReceivePhysicalAccession is an admin API call that marks the boxes of a physical transfer as
+// having arrived. The receiving staff member is the authenticated admin. A transfer can
+// only be received once
+func (svc *ServiceContext) ReceivePhysicalAccession(c *gin.Context) {
+	accession := svc.getPhysicalAccession(c)
+	if accession == nil {
+		return
+	}
+	if accession.Physical.ReceivedAt != nil {
+		c.String(http.StatusConflict, "accession %d was already received on %s", accession.ID,
+			accession.Physical.ReceivedAt.Format("2006-01-02"))
+		return
+	}
+	var req struct {
+		ReceivedAt string `json:"receivedAt"`
+	}
+	c.ShouldBindJSON(&req)
+	receivedAt := time.Now()
+	if req.ReceivedAt != "" {
+		parsed, err := time.ParseInLocation("2006-01-02", req.ReceivedAt, time.Local)
+		if err != nil {
+			c.String(http.StatusBadRequest, "invalid received date %s", req.ReceivedAt)
+			return
+		}
+		receivedAt = parsed
+	}
+
+	staff := GetAuthUser(c)
+	log.Printf("%s received physical transfer for accession %d", staff.Email, accession.ID)
+	accession.Physical.ReceivedAt = &receivedAt
+	accession.Physical.ReceivedByID = &staff.ID
+	err := svc.DB.Model(&accession.Physical).Update("ReceivedAt", "ReceivedByID")
+	if err != nil {
+		log.Printf("ERROR: Unable to mark accession %d received: %s", accession.ID, err.Error())
+		c.String(http.StatusInternalServerError, err.Error())
+		return
+	}
+	LogEvent(svc.DB, accession.ID, staff, "received", "Physical transfer received")
+	err = accession.AdvanceStatus(svc.DB, "received", staff)
+	if err != nil {
+		log.Printf("ERROR: Unable to update status of accession %d: %s", accession.ID, err.Error())
+	}
+	accession.Physical.ReceivedBy = staff.FullName()
+	c.JSON(http.StatusOK, accession.Physical)
+}
+
+// CheckInventoryItem is an admin API call that records the receiving status of one inventory box.
+// Status must be one of received, missing or damaged
+func (svc *ServiceContext) CheckInventoryItem(c *gin.Context) {
+	accession := svc.getOpenPhysicalAccession(c)
+	if accession == nil {
+		return
+	}
+	var req struct {
+		Status string `json:"status"`
+		Note   string `json:"note"`
+	}
+	err := c.ShouldBindJSON(&req)
+	if err != nil {
+		c.String(http.StatusBadRequest, err.Error())
+		return
+	}
+	if IsCheckStatus(req.Status) == false {
+		c.String(http.StatusBadRequest, "invalid status %s", req.Status)
+		return
+	}
+
+	itemID := c.Param("item")
+	var item InventoryItem
+	q := svc.DB.NewQuery("select * from inventory_items where id={:id} and physical_accession_id={:pid}")
+	q.Bind(dbx.Params{"id": itemID, "pid": accession.Physical.ID})
+	err = q.One(&item)
+	if err != nil {
+		log.Printf("ERROR: Inventory item %s not found for accession %d: %s", itemID, accession.ID, err.Error())
+		c.String(http.StatusNotFound, "inventory item %s not found", itemID)
+		return
+	}
+
+	staff := GetAuthUser(c)
+	now := time.Now()
+	item.Status = req.Status
+	item.Note = req.Note
+	item.CheckedAt = &now
+	item.CheckedBy = &staff.ID
+	log.Printf("%s marked box %s of accession %d as %s", staff.Email, item.BoxNumber, accession.ID, item.Status)
+	err = svc.DB.Model(&item).Update("Status", "Note", "CheckedAt", "CheckedBy")
+	if err != nil {
+		log.Printf("ERROR: Unable to update inventory item %d: %s", item.ID, err.Error())
+		c.String(http.StatusInternalServerError, err.Error())
+		return
+	}
+	if item.Note != "" {
+		if err := EnqueueJob(svc.DB, accession.ID, "index"); err != nil {
+			log.Printf("ERROR: Unable to queue index job for accession %d: %s", accession.ID, err.Error())
+		}
+	}
+	c.JSON(http.StatusOK, item)
+}
+
+// AddExtraInventoryItem is an admin API call that records a box that arrived but was
+// not listed in the inventory of a physical transfer
+func (svc *ServiceContext) AddExtraInventoryItem(c *gin.Context) {
+	accession := svc.getOpenPhysicalAccession(c)
+	if accession == nil {
+		return
+	}
+	var item InventoryItem
+	err := c.ShouldBindJSON(&item)
+	if err != nil {
+		c.String(http.StatusBadRequest, err.Error())
+		return
+	}
+
+	staff := GetAuthUser(c)
+	now := time.Now()
+	item.ID = 0
+	item.PhysAccessionID = accession.Physical.ID
+	item.Status = "extra"
+	item.CheckedAt = &now
+	item.CheckedBy = &staff.ID
+	log.Printf("%s added extra box %s to accession %d", staff.Email, item.BoxNumber, accession.ID)
+	err = svc.DB.Model(&item).Insert()
+	if err != nil {
+		log.Printf("ERROR: Unable to add extra inventory to accession %d: %s", accession.ID, err.Error())
+		c.String(http.StatusInternalServerError, err.Error())
+		return
+	}
+	if err := EnqueueJob(svc.DB, accession.ID, "index"); err != nil {
+		log.Printf("ERROR: Unable to queue index job for accession %d: %s", accession.ID, err.Error())
+	}
+	c.JSON(http.StatusOK, item)
+}
+
+// CloseReceiving is an admin API call that completes receiving for a physical transfer.
+// Any boxes that have not been checked off are marked missing, and a discrepancy report
+// is queued for the submitter if the shipment did not match the inventory
+func (svc *ServiceContext) CloseReceiving(c *gin.Context) {
+	accession := svc.getPhysicalAccession(c)
+	if accession == nil {
+		return
+	}
+	if accession.Physical.ReceivedAt == nil {
+		c.String(http.StatusBadRequest, "accession %d has not been received", accession.ID)
+		return
+	}
+	if accession.Physical.ReceiptClosedAt != nil {
+		c.String(http.StatusConflict, "receiving for accession %d is already closed", accession.ID)
+		return
+	}
+
+	now := time.Now()
+	accession.Physical.ReceiptClosedAt = &now
+	tx, _ := svc.DB.Begin()
+	q := tx.NewQuery(`update inventory_items set status="missing", checked_at={:now}
+		where physical_accession_id={:pid} and status="expected"`)
+	q.Bind(dbx.Params{"now": now, "pid": accession.Physical.ID})
+	_, err := q.Execute()
+	if err == nil {
+		err = tx.Model(&accession.Physical).Update("ReceiptClosedAt")
+	}
+	if err == nil {
+		err = EnqueueJob(tx, accession.ID, "discrepancy")
+	}
+	if err == nil {
+		LogEvent(tx, accession.ID, GetAuthUser(c), "received", "Receiving closed")
+	}
+	if err != nil {
+		log.Printf("ERROR: Unable to close receiving for accession %d: %s", accession.ID, err.Error())
+		tx.Rollback()
+		c.String(http.StatusInternalServerError, err.Error())
+		return
+	}
+	err = tx.Commit()
+	if err != nil {
+		log.Printf("ERROR: Unable to commit close of receiving for accession %d: %s", accession.ID, err.Error())
+		c.String(http.StatusInternalServerError, err.Error())
+		return
+	}
+
+	accession.Physical.Inventory = nil
+	accession.Physical.GetInventory(svc.DB)
+	c.JSON(http.StatusOK, accession.GetDiscrepancyReport())
+}
+
+// GetDiscrepancies is an admin API call that returns the current receiving discrepancy
+// report for a physical accession
+func (svc *ServiceContext) GetDiscrepancies(c *gin.Context) {
+	accession := svc.getPhysicalAccession(c)
+	if accession == nil {
+		return
+	}
+	c.JSON(http.StatusOK, accession.GetDiscrepancyReport())
+}
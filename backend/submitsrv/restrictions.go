@@ -0,0 +1,191 @@
+package main
+
+import (
+	"fmt"
+	"log"
+	"net/http"
+	"strings"
+	"time"
+
+	"github.com/gin-gonic/gin"
+	dbx "github.com/go-ozzo/ozzo-dbx"
+)
+
+// Restriction describes the access restriction placed on an accession, such as FERPA
+// protected student records. Submitters propose a restriction and admins confirm it
+type Restriction struct {
+	ID              int        `json:"id" db:"id"`
+	AccessionID     int        `json:"-" db:"accession_id"`
+	CategoryID      int        `json:"categoryID" db:"category_id"`
+	Category        string     `json:"category" db:"category"`
+	RestrictedUntil *time.Time `json:"restrictedUntil" db:"restricted_until"`
+	Justification   string     `json:"justification" db:"justification"`
+	Status          string     `json:"status" db:"status"`
+	ConfirmedBy     *int       `json:"-" db:"confirmed_by"`
+	ConfirmedAt     *time.Time `json:"confirmedAt" db:"confirmed_at"`
+}
+
+// TableName defines the expected DB table name that holds data for restrictions
+func (r *Restriction) TableName() string {
+	return "accession_restrictions"
+}
+
+// IsRestrictionStatus returns true if the status is a supported restriction status
+func IsRestrictionStatus(status string) bool {
+	switch status {
+	case "proposed", "confirmed":
+		return true
+	}
+	return false
+}
+
+// GetRestriction loads the access restriction for this accession, if any
+func (a *Accession) GetRestriction(db *dbx.DB) {
+	var restriction Restriction
+	q := db.NewQuery(`select ar.*, rc.name as category from accession_restrictions ar
+		inner join restriction_categories rc on rc.id = ar.category_id where ar.accession_id={:id}`)
+	q.Bind(dbx.Params{"id": a.ID})
+	if q.One(&restriction) == nil {
+		a.Restriction = &restriction
+	}
+}
+
+// WriteRestriction writes the restriction proposed by the submitter of a new accession
+// and schedules the embargo reminder for its end date
+func (a *Accession) WriteRestriction(tx *dbx.Tx) error {
+	if a.Restriction == nil {
+		return nil
+	}
+	log.Printf("Accession %d proposed restriction category %d", a.ID, a.Restriction.CategoryID)
+	a.Restriction.ID = 0
+	a.Restriction.AccessionID = a.ID
+	a.Restriction.Status = "proposed"
+	err := tx.Model(a.Restriction).Exclude("Category", "ConfirmedBy", "ConfirmedAt").Insert()
+	if err != nil {
+		return err
+	}
+	return scheduleEmbargoReminder(tx, a.ID, a.Restriction.RestrictedUntil)
+}
+
+// scheduleEmbargoReminder replaces any pending embargo reminder for an accession with
+// one that runs on the restricted until date. Restrictions without a date get none
+func scheduleEmbargoReminder(db dbx.Builder, accessionID int, restrictedUntil *time.Time) error {
+	q := db.NewQuery(`delete from jobs where accession_id={:id} and job_type="embargo" and status="pending"`)
+	q.Bind(dbx.Params{"id": accessionID})
+	_, err := q.Execute()
+	if err != nil || restrictedUntil == nil {
+		return err
+	}
+	return EnqueueJobAt(db, accessionID, "embargo", *restrictedUntil)
+}
+
+// embargoJob reminds admins that the embargo on an accession has ended. The reminder
+// is skipped if the restriction was removed or extended after the job was queued
+func embargoJob(svc *ServiceContext, accession *Accession) error {
+	r := accession.Restriction
+	if r == nil || r.RestrictedUntil == nil || r.RestrictedUntil.After(time.Now()) {
+		log.Printf("Embargo on accession %d is no longer due; skipping reminder", accession.ID)
+		return nil
+	}
+	body, err := RenderEmailTemplate("embargo_email.html", accession)
+	if err != nil {
+		log.Printf("ERROR: Unable to render embargo email: %s", err.Error())
+		return err
+	}
+	subject := fmt.Sprintf("UVA Archives Embargo Ended: %s", accession.AccessionNumber)
+	return svc.SMTP.SendEmail(EmailRequest{Subject: subject, To: GetAdminEmails(svc.DB), Body: body})
+}
+
+// UpdateRestriction is an admin API call that sets, changes or confirms the access
+// restriction on an accession. A justification is always required
+func (svc *ServiceContext) UpdateRestriction(c *gin.Context) {
+	accessionID := c.Param("id")
+	var req Restriction
+	err := c.ShouldBindJSON(&req)
+	if err != nil {
+		c.String(http.StatusBadRequest, err.Error())
+		return
+	}
+	if IsRestrictionStatus(req.Status) == false {
+		c.String(http.StatusBadRequest, "invalid restriction status %s", req.Status)
+		return
+	}
+	categories, err := getVocabIDs(svc.DB, "restriction_categories", "")
+	if err != nil {
+		c.String(http.StatusInternalServerError, err.Error())
+		return
+	}
+	if categories[req.CategoryID] == false {
+		c.String(http.StatusBadRequest, "invalid restriction category %d", req.CategoryID)
+		return
+	}
+	req.Justification = strings.TrimSpace(req.Justification)
+	if req.Justification == "" {
+		c.String(http.StatusBadRequest, "a justification for the restriction is required")
+		return
+	}
+	accession, err := LoadAccession(svc.DB, accessionID)
+	if err != nil {
+		c.String(http.StatusNotFound, "accession %s not found", accessionID)
+		return
+	}
+
+	staff := GetAuthUser(c)
+	params := dbx.Params{"category_id": req.CategoryID, "restricted_until": req.RestrictedUntil,
+		"justification": req.Justification, "status": req.Status,
+		"confirmed_by": nil, "confirmed_at": nil}
+	if req.Status == "confirmed" {
+		params["confirmed_by"] = staff.ID
+		params["confirmed_at"] = time.Now()
+	}
+	log.Printf("%s set %s restriction on accession %d", staff.Email, req.Status, accession.ID)
+	tx, _ := svc.DB.Begin()
+	if accession.Restriction == nil {
+		params["accession_id"] = accession.ID
+		_, err = tx.Insert("accession_restrictions", params).Execute()
+	} else {
+		_, err = tx.Update("accession_restrictions", params, dbx.HashExp{"accession_id": accession.ID}).Execute()
+	}
+	if err == nil {
+		err = scheduleEmbargoReminder(tx, accession.ID, req.RestrictedUntil)
+	}
+	if err != nil {
+		log.Printf("ERROR: Unable to update restriction for accession %d: %s", accession.ID, err.Error())
+		tx.Rollback()
+		c.String(http.StatusInternalServerError, err.Error())
+		return
+	}
+	LogEvent(tx, accession.ID, staff, "restriction", fmt.Sprintf("Restriction %s", req.Status))
+	tx.Commit()
+	accession.GetRestriction(svc.DB)
+	c.JSON(http.StatusOK, accession.Restriction)
+}
+
+// DeleteRestriction is an admin API call that removes the access restriction from an accession
+func (svc *ServiceContext) DeleteRestriction(c *gin.Context) {
+	accessionID := c.Param("id")
+	accession, err := LoadAccession(svc.DB, accessionID)
+	if err != nil {
+		c.String(http.StatusNotFound, "accession %s not found", accessionID)
+		return
+	}
+	if accession.Restriction == nil {
+		c.String(http.StatusNotFound, "accession %s is not restricted", accessionID)
+		return
+	}
+	tx, _ := svc.DB.Begin()
+	_, err = tx.Delete("accession_restrictions", dbx.HashExp{"accession_id": accession.ID}).Execute()
+	if err == nil {
+		err = scheduleEmbargoReminder(tx, accession.ID, nil)
+	}
+	if err != nil {
+		log.Printf("ERROR: Unable to remove restriction from accession %d: %s", accession.ID, err.Error())
+		tx.Rollback()
+		c.String(http.StatusInternalServerError, err.Error())
+		return
+	}
+	LogEvent(tx, accession.ID, GetAuthUser(c), "restriction", "Restriction removed")
+	tx.Commit()
+	log.Printf("%s removed restriction from accession %d", GetAuthUser(c).Email, accession.ID)
+	c.String(http.StatusOK, "deleted")
+}
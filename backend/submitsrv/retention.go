@@ -0,0 +1,304 @@
+package main
+
+import (
+	"database/sql"
+	"encoding/csv"
+	"io"
+	"log"
+	"net/http"
+	"regexp"
+	"strconv"
+	"strings"
+	"time"
+
+	"github.com/gin-gonic/gin"
+	dbx "github.com/go-ozzo/ozzo-dbx"
+)
+
+// RetentionSchedule is one records series from the university records retention
+// schedules. RetentionYears is parsed from the period text and is nil for series
+// that are kept permanently or have an event based period that cannot be calculated
+type RetentionSchedule struct {
+	ID              int    `json:"id" db:"id"`
+	ScheduleNumber  string `json:"scheduleNumber" db:"schedule_number"`
+	SeriesTitle     string `json:"seriesTitle" db:"series_title"`
+	RetentionPeriod string `json:"retentionPeriod" db:"retention_period"`
+	RetentionYears  *int   `json:"retentionYears" db:"retention_years"`
+	Disposition     string `json:"disposition" db:"disposition"`
+}
+
+// TableName defines the expected DB table name that holds data for retention schedules
+func (rs *RetentionSchedule) TableName() string {
+	return "retention_schedules"
+}
+
+var retentionYearsRegex = regexp.MustCompile(`(?i)\b(\d+)\)?[\s-]*(?:(?:fiscal|calendar|academic|full|complete)\s+)?(?:years?|yrs?)\b`)
+var yearRegex = regexp.MustCompile(`\b(1[89]|20)\d{2}\b`)
+
+// parseRetentionYears extracts the number of years from retention period text such
+// as "5 years after end of fiscal year". Returns nil if no period in years is found
+func parseRetentionYears(period string) *int {
+	match := retentionYearsRegex.FindStringSubmatch(period)
+	if match == nil {
+		return nil
+	}
+	years, _ := strconv.Atoi(match[1])
+	return &years
+}
+
+// GetRetentionSchedule loads the retention schedule with the given ID
+func GetRetentionSchedule(db *dbx.DB, ID int) (*RetentionSchedule, error) {
+	var schedule RetentionSchedule
+	err := db.Select().From("retention_schedules").Where(dbx.HashExp{"id": ID}).One(&schedule)
+	if err != nil {
+		return nil, err
+	}
+	return &schedule, nil
+}
+
+// CalculateDispositionDate returns the date the accession becomes eligible for
+// disposition under a retention schedule; the end of the latest year mentioned in
+// the record date ranges plus the retention period. The transfer date is used when no
+// year can be found. Series without a period in years have no disposition date
+func (a *Accession) CalculateDispositionDate(schedule *RetentionSchedule) *time.Time {
+	if schedule == nil || schedule.RetentionYears == nil {
+		return nil
+	}
+	dateRanges := a.Physical.DateRange
+	if a.Digital.DateRange != nil {
+		dateRanges += " " + *a.Digital.DateRange
+	}
+	endYear := 0
+	for _, years := range yearRegex.FindAllString(dateRanges, -1) {
+		year, _ := strconv.Atoi(years)
+		if year > endYear {
+			endYear = year
+		}
+	}
+	if endYear == 0 {
+		endYear = a.CreatedAt.Year()
+	}
+	out := time.Date(endYear+*schedule.RetentionYears, time.December, 31, 0, 0, 0, 0, time.UTC)
+	return &out
+}
+
+// GetRetentionSchedule loads the retention schedule selected for this accession, if any
+func (a *Accession) GetRetentionSchedule(db *dbx.DB) {
+	if a.RetentionScheduleID == nil {
+		return
+	}
+	schedule, err := GetRetentionSchedule(db, *a.RetentionScheduleID)
+	if err != nil {
+		log.Printf("ERROR: Unable to get retention schedule %d: %s", *a.RetentionScheduleID, err.Error())
+		return
+	}
+	a.RetentionSchedule = schedule
+}
+
+// GetRetentionSchedules returns the list of retention schedule series as JSON
+func (svc *ServiceContext) GetRetentionSchedules(c *gin.Context) {
+	schedules := make([]RetentionSchedule, 0)
+	err := svc.DB.Select().From("retention_schedules").OrderBy("schedule_number asc").All(&schedules)
+	if err != nil {
+		c.String(http.StatusInternalServerError, "Unable to retrive retention schedules: %s", err.Error())
+		return
+	}
+	c.JSON(http.StatusOK, schedules)
+}
+
+// ImportRetentionSchedules is an admin API call that loads retention schedules from an
+// uploaded CSV file. The first row must be a header naming the schedule number, series
+// title, retention period and disposition columns. Existing series are updated
+func (svc *ServiceContext) ImportRetentionSchedules(c *gin.Context) {
+	file, _, err := c.Request.FormFile("file")
+	if err != nil {
+		c.String(http.StatusBadRequest, "a CSV file is required: %s", err.Error())
+		return
+	}
+	defer file.Close()
+
+	reader := csv.NewReader(file)
+	reader.TrimLeadingSpace = true
+	header, err := reader.Read()
+	if err != nil {
+		c.String(http.StatusBadRequest, "unable to read CSV header: %s", err.Error())
+		return
+	}
+	cols := make(map[string]int)
+	for idx, name := range header {
+		name = strings.ToLower(strings.TrimSpace(name))
+		cols[strings.Replace(name, " ", "_", -1)] = idx
+	}
+	for _, name := range []string{"schedule_number", "series_title", "retention_period", "disposition"} {
+		if _, ok := cols[name]; ok == false {
+			c.String(http.StatusBadRequest, "CSV is missing the %s column", name)
+			return
+		}
+	}
+
+	tx, _ := svc.DB.Begin()
+	count := 0
+	changed := make([]int, 0)
+	for line := 2; ; line++ {
+		row, err := reader.Read()
+		if err == io.EOF {
+			break
+		}
+		if err != nil {
+			tx.Rollback()
+			c.String(http.StatusBadRequest, "invalid CSV at line %d: %s", line, err.Error())
+			return
+		}
+		schedule := RetentionSchedule{
+			ScheduleNumber:  strings.TrimSpace(row[cols["schedule_number"]]),
+			SeriesTitle:     strings.TrimSpace(row[cols["series_title"]]),
+			RetentionPeriod: strings.TrimSpace(row[cols["retention_period"]]),
+			Disposition:     strings.TrimSpace(row[cols["disposition"]]),
+		}
+		if schedule.ScheduleNumber == "" {
+			continue
+		}
+		schedule.RetentionYears = parseRetentionYears(schedule.RetentionPeriod)
+
+		// accessions under a series whose retention changes need new disposition dates
+		var prior RetentionSchedule
+		pq := tx.NewQuery("select * from retention_schedules where schedule_number={:num}")
+		pq.Bind(dbx.Params{"num": schedule.ScheduleNumber})
+		err = pq.One(&prior)
+		if err == nil && sameRetentionYears(prior.RetentionYears, schedule.RetentionYears) == false {
+			changed = append(changed, prior.ID)
+		} else if err != nil && err != sql.ErrNoRows {
+			log.Printf("ERROR: Unable to get retention schedule %s: %s", schedule.ScheduleNumber, err.Error())
+			tx.Rollback()
+			c.String(http.StatusInternalServerError, err.Error())
+			return
+		}
+
+		q := tx.NewQuery(`insert into retention_schedules
+			(schedule_number, series_title, retention_period, retention_years, disposition)
+			values ({:num}, {:title}, {:period}, {:years}, {:disposition})
+			on duplicate key update series_title=values(series_title), retention_period=values(retention_period),
+				retention_years=values(retention_years), disposition=values(disposition)`)
+		q.Bind(dbx.Params{"num": schedule.ScheduleNumber, "title": schedule.SeriesTitle,
+			"period": schedule.RetentionPeriod, "years": schedule.RetentionYears, "disposition": schedule.Disposition})
+		_, err = q.Execute()
+		if err != nil {
+			log.Printf("ERROR: Unable to import retention schedule %s: %s", schedule.ScheduleNumber, err.Error())
+			tx.Rollback()
+			c.String(http.StatusInternalServerError, err.Error())
+			return
+		}
+		count++
+	}
+	var queued int64
+	for _, scheduleID := range changed {
+		n, err := EnqueueAccessionJobs(tx, "disposition", "a.retention_schedule_id={:schedule}", dbx.Params{"schedule": scheduleID})
+		if err != nil {
+			log.Printf("ERROR: Unable to queue disposition updates for schedule %d: %s", scheduleID, err.Error())
+			tx.Rollback()
+			c.String(http.StatusInternalServerError, err.Error())
+			return
+		}
+		queued += n
+	}
+	err = tx.Commit()
+	if err != nil {
+		log.Printf("ERROR: Unable to commit retention schedules: %s", err.Error())
+		c.String(http.StatusInternalServerError, err.Error())
+		return
+	}
+	log.Printf("%s imported %d retention schedules; %d changed retention, updating %d accessions",
+		GetAuthUser(c).Email, count, len(changed), queued)
+	c.String(http.StatusOK, "%d", count)
+}
+
+// sameRetentionYears returns true if two parsed retention periods are equal
+func sameRetentionYears(a *int, b *int) bool {
+	if a == nil || b == nil {
+		return a == nil && b == nil
+	}
+	return *a == *b
+}
+
+// dispositionJob recalculates the disposition date of an accession after its
+// retention schedule changes
+func dispositionJob(svc *ServiceContext, accession *Accession) error {
+	accession.DispositionDate = accession.CalculateDispositionDate(accession.RetentionSchedule)
+	_, err := svc.DB.Update("accessions", dbx.Params{"disposition_date": accession.DispositionDate},
+		dbx.HashExp{"id": accession.ID}).Execute()
+	return err
+}
+
+// UpdateAccessionRetention is an admin API call that changes the retention schedule of
+// an accession and recalculates its disposition date
+func (svc *ServiceContext) UpdateAccessionRetention(c *gin.Context) {
+	accessionID := c.Param("id")
+	var req struct {
+		ScheduleID *int `json:"retentionScheduleID"`
+	}
+	err := c.ShouldBindJSON(&req)
+	if err != nil {
+		c.String(http.StatusBadRequest, err.Error())
+		return
+	}
+	accession, err := LoadAccession(svc.DB, accessionID)
+	if err != nil {
+		c.String(http.StatusNotFound, "accession %s not found", accessionID)
+		return
+	}
+	accession.RetentionScheduleID = req.ScheduleID
+	accession.RetentionSchedule = nil
+	if req.ScheduleID != nil {
+		accession.RetentionSchedule, err = GetRetentionSchedule(svc.DB, *req.ScheduleID)
+		if err != nil {
+			c.String(http.StatusBadRequest, "retention schedule %d not found", *req.ScheduleID)
+			return
+		}
+	}
+	accession.DispositionDate = accession.CalculateDispositionDate(accession.RetentionSchedule)
+	_, err = svc.DB.Update("accessions", dbx.Params{"retention_schedule_id": accession.RetentionScheduleID,
+		"disposition_date": accession.DispositionDate}, dbx.HashExp{"id": accession.ID}).Execute()
+	if err != nil {
+		log.Printf("ERROR: Unable to update retention for accession %d: %s", accession.ID, err.Error())
+		c.String(http.StatusInternalServerError, err.Error())
+		return
+	}
+	log.Printf("%s set retention schedule of accession %d", GetAuthUser(c).Email, accession.ID)
+	c.JSON(http.StatusOK, accession)
+}
+
+// GetDispositionReport is an admin API call that lists the accessions that are due
+// for disposition on or before a date. The date defaults to today
+func (svc *ServiceContext) GetDispositionReport(c *gin.Context) {
+	before := time.Now()
+	if dateStr := c.Query("before"); dateStr != "" {
+		date, err := time.Parse("2006-01-02", dateStr)
+		if err != nil {
+			c.String(http.StatusBadRequest, "invalid date %s", dateStr)
+			return
+		}
+		before = date
+	}
+	type DispositionRow struct {
+		ID              int       `json:"id" db:"id"`
+		AccessionNumber string    `json:"accessionNumber" db:"accession_number"`
+		Summary         string    `json:"summary" db:"description"`
+		ScheduleNumber  string    `json:"scheduleNumber" db:"schedule_number"`
+		SeriesTitle     string    `json:"seriesTitle" db:"series_title"`
+		Disposition     string    `json:"disposition" db:"disposition"`
+		DispositionDate time.Time `json:"dispositionDate" db:"disposition_date"`
+	}
+	q := svc.DB.NewQuery(`select a.id, a.accession_number, a.description, rs.schedule_number, rs.series_title,
+			rs.disposition, a.disposition_date
+		from accessions a inner join retention_schedules rs on rs.id = a.retention_schedule_id
+		where a.disposition_date <= {:before} order by a.disposition_date asc`)
+	q.Bind(dbx.Params{"before": before})
+	out := make([]DispositionRow, 0)
+	err := q.All(&out)
+	if err != nil {
+		log.Printf("ERROR: Unable to get disposition report: %s", err.Error())
+		c.String(http.StatusInternalServerError, err.Error())
+		return
+	}
+	c.JSON(http.StatusOK, out)
+}
@@ -0,0 +1,82 @@
+package main
+
+import (
+	"testing"
+	"time"
+)
+
+func TestParseRetentionYears(t *testing.T) {
+	tests := []struct {
+		period string
+		want   int
+	}{
+		{"5 years", 5},
+		{"1 year after end of fiscal year", 1},
+		{"3 fiscal years", 3},
+		{"2 calendar years after closure", 2},
+		{"10 yrs", 10},
+		{"7yr", 7},
+		{"6-year retention", 6},
+		{"Retain (4) years then destroy", 4},
+		{"5 Academic Years", 5},
+		{"Until superseded, then 2 years", 2},
+		{"Permanent", -1},
+		{"Until no longer administratively useful", -1},
+		{"", -1},
+		{"12 months", -1},
+		{"Fiscal 2020 plus years", -1},
+		{"5 yearbooks", -1},
+	}
+	for _, tt := range tests {
+		got := parseRetentionYears(tt.period)
+		if tt.want < 0 {
+			if got != nil {
+				t.Errorf("parseRetentionYears(%q) = %d, want nil", tt.period, *got)
+			}
+			continue
+		}
+		if got == nil || *got != tt.want {
+			t.Errorf("parseRetentionYears(%q) = %v, want %d", tt.period, got, tt.want)
+		}
+	}
+}
+
+func TestCalculateDispositionDate(t *testing.T) {
+	intPtr := func(i int) *int { return &i }
+	strPtr := func(s string) *string { return &s }
+	created := time.Date(2026, 3, 1, 10, 15, 0, 0, time.UTC)
+	tests := []struct {
+		name     string
+		physical string
+		digital  *string
+		years    *int
+		want     string
+	}{
+		{"single year", "1962", nil, intPtr(5), "1967-12-31"},
+		{"range", "1960-1965", nil, intPtr(3), "1968-12-31"},
+		{"latest of physical and digital", "1960-1965", strPtr("1990-2001"), intPtr(1), "2002-12-31"},
+		{"digital only", "", strPtr("circa 1999"), intPtr(10), "2009-12-31"},
+		{"unordered years", "2004, 1998, 2001", nil, intPtr(2), "2006-12-31"},
+		{"no years uses transfer date", "undated", nil, intPtr(3), "2029-12-31"},
+		{"numbers that are not years", "boxes 1-12, 300 folders", nil, intPtr(1), "2027-12-31"},
+		{"zero years", "1980", nil, intPtr(0), "1980-12-31"},
+		{"permanent", "1980", nil, nil, ""},
+	}
+	for _, tt := range tests {
+		accession := Accession{CreatedAt: created}
+		accession.Physical.DateRange = tt.physical
+		accession.Digital.DateRange = tt.digital
+		got := accession.CalculateDispositionDate(&RetentionSchedule{RetentionYears: tt.years})
+		gotStr := ""
+		if got != nil {
+			gotStr = got.Format("2006-01-02")
+		}
+		if gotStr != tt.want {
+			t.Errorf("%s: CalculateDispositionDate = %q, want %q", tt.name, gotStr, tt.want)
+		}
+	}
+
+	if got := (&Accession{}).CalculateDispositionDate(nil); got != nil {
+		t.Errorf("CalculateDispositionDate(nil) = %s, want nil", got)
+	}
+}
@@ -0,0 +1,291 @@
+package main
+
+import (
+	"fmt"
+	"log"
+	"net/http"
+	"net/url"
+	"strings"
+	"time"
+
+	"github.com/gin-gonic/gin"
+	dbx "github.com/go-ozzo/ozzo-dbx"
+)
+
+// SavedSearch is a named set of accession list filters and sort saved by an admin.
+// Query is the URL query string of the list params. Shared searches are visible to all
+// admins, and the owner of a search with Notify set is emailed when new accessions match
+type SavedSearch struct {
+	ID        int       `json:"id" db:"id"`
+	UserID    int       `json:"userID" db:"user_id"`
+	OwnerName string    `json:"owner" db:"owner_name"`
+	Name      string    `json:"name" db:"name" binding:"required"`
+	Query     string    `json:"query" db:"query"`
+	Shared    bool      `json:"shared" db:"shared"`
+	Notify    bool      `json:"notify" db:"notify"`
+	CreatedAt time.Time `json:"createdAt" db:"created_at"`
+	UpdatedAt time.Time `json:"updatedAt" db:"updated_at"`
+}
+
+// TableName defines the expected DB table name that holds data for saved searches
+func (s *SavedSearch) TableName() string {
+	return "saved_searches"
+}
+
+// listOnlyParams are the accession list params that are not part of a saved search
+var listOnlyParams = []string{"search", "cursor", "pageSize", "total", "format"}
+
+// searchQuery returns the query string of the filter and sort params of a list request
+func searchQuery(query url.Values) string {
+	out := url.Values{}
+	for key, values := range query {
+		out[key] = values
+	}
+	for _, key := range listOnlyParams {
+		out.Del(key)
+	}
+	return out.Encode()
+}
+
+const savedSearchSelectQS = `select s.*, concat(u.first_name, ' ', u.last_name) as owner_name
+	from saved_searches s inner join users u on u.id = s.user_id`
+
+// loadSavedSearch loads a saved search the user can see: one of their own or a shared one
+func loadSavedSearch(db *dbx.DB, ID interface{}, user *User) (*SavedSearch, error) {
+	var search SavedSearch
+	q := db.NewQuery(savedSearchSelectQS + " where s.id={:id} and (s.user_id={:user} or s.shared=1)")
+	q.Bind(dbx.Params{"id": ID, "user": user.ID})
+	err := q.One(&search)
+	if err != nil {
+		return nil, err
+	}
+	return &search, nil
+}
+
+// listAccessionQuery builds the accession query for an admin list request. The search
+// param runs a saved search by ID, and its saved filters and sort take the place of
+// those in the request
+func (svc *ServiceContext) listAccessionQuery(c *gin.Context) (*accessionQuery, error) {
+	user := GetAuthUser(c)
+	query := c.Request.URL.Query()
+	if searchID := strings.TrimSpace(c.Query("search")); searchID != "" {
+		search, err := loadSavedSearch(svc.DB, searchID, user)
+		if err != nil {
+			return nil, filterError{fmt.Errorf("saved search %s not found", searchID)}
+		}
+		log.Printf("Run saved search %d [%s]", search.ID, search.Name)
+		query, err = url.ParseQuery(search.Query)
+		if err != nil {
+			return nil, err
+		}
+	}
+	return svc.newAccessionQuery(query, user.ID)
+}
+
+// savedSearchJob checks a newly submitted accession against every saved search with
+// notifications on, and emails the owners of the searches it matches for the first
+// time. Only accessions submitted after the search was saved are new to its owner
+func savedSearchJob(svc *ServiceContext, accession *Accession) error {
+	var searches []SavedSearch
+	q := svc.DB.NewQuery(savedSearchSelectQS + " where s.notify=1 and s.created_at <= {:created}")
+	q.Bind(dbx.Params{"created": accession.CreatedAt})
+	err := q.All(&searches)
+	if err != nil {
+		return err
+	}
+	for idx := range searches {
+		search := &searches[idx]
+		var found struct{ Count int }
+		mq := svc.DB.NewQuery(`select count(*) as count from saved_search_matches
+			where saved_search_id={:search} and accession_id={:id}`)
+		mq.Bind(dbx.Params{"search": search.ID, "id": accession.ID})
+		err = mq.One(&found)
+		if err != nil {
+			return err
+		}
+		if found.Count > 0 {
+			continue
+		}
+
+		query, err := url.ParseQuery(search.Query)
+		if err != nil {
+			log.Printf("ERROR: Invalid query in saved search %d: %s", search.ID, err.Error())
+			continue
+		}
+		aq, err := svc.newAccessionQuery(query, search.UserID)
+		if err != nil {
+			log.Printf("ERROR: Unable to build query for saved search %d: %s", search.ID, err.Error())
+			continue
+		}
+		aq.params["matchID"] = accession.ID
+		matches := 0
+		cq := svc.DB.NewQuery(aq.countQS("a.id={:matchID}"))
+		cq.Bind(aq.params)
+		err = cq.Row(&matches)
+		if err != nil {
+			return err
+		}
+		if matches == 0 {
+			continue
+		}
+
+		// the match is recorded once the owner has been told, so a failed email is
+		// sent again when the job is retried
+		log.Printf("Accession %d matches saved search %d [%s]", accession.ID, search.ID, search.Name)
+		err = svc.sendSavedSearchEmail(search, accession)
+		if err != nil {
+			return err
+		}
+		_, err = svc.DB.Insert("saved_search_matches", dbx.Params{
+			"saved_search_id": search.ID,
+			"accession_id":    accession.ID,
+			"created_at":      time.Now(),
+		}).Execute()
+		if err != nil {
+			return err
+		}
+	}
+	return nil
+}
+
+// sendSavedSearchEmail tells the owner of a saved search about a new matching accession
+func (svc *ServiceContext) sendSavedSearchEmail(search *SavedSearch, accession *Accession) error {
+	var owner User
+	err := svc.DB.Select().Model(search.UserID, &owner)
+	if err != nil {
+		return err
+	}
+	if owner.Admin == false {
+		log.Printf("Owner of saved search %d is no longer an admin; skipping notification", search.ID)
+		return nil
+	}
+	data := struct {
+		Search    *SavedSearch
+		Accession *Accession
+		URL       string
+		SearchURL string
+	}{Search: search, Accession: accession,
+		URL:       fmt.Sprintf("https://%s/admin/accessions/%d", svc.Hostname, accession.ID),
+		SearchURL: fmt.Sprintf("https://%s/admin?search=%d", svc.Hostname, search.ID)}
+	body, err := RenderEmailTemplate("saved_search_email.html", data)
+	if err != nil {
+		log.Printf("ERROR: Unable to render saved search email: %s", err.Error())
+		return err
+	}
+	name := strings.NewReplacer("\r", " ", "\n", " ").Replace(search.Name)
+	subject := fmt.Sprintf("UVA Archives Transfer %s Matches %s", accession.AccessionNumber, name)
+	return svc.SMTP.SendEmail(EmailRequest{Subject: subject, To: []string{owner.Email}, Body: body})
+}
+
+// GetSavedSearches is an admin API call that returns the saved searches of the current
+// admin and those shared by other admins
+func (svc *ServiceContext) GetSavedSearches(c *gin.Context) {
+	searches := make([]SavedSearch, 0)
+	q := svc.DB.NewQuery(savedSearchSelectQS + " where s.user_id={:user} or s.shared=1 order by s.name asc")
+	q.Bind(dbx.Params{"user": GetAuthUser(c).ID})
+	err := q.All(&searches)
+	if err != nil {
+		log.Printf("ERROR: Unable to get saved searches: %s", err.Error())
+		c.String(http.StatusInternalServerError, err.Error())
+		return
+	}
+	c.JSON(http.StatusOK, searches)
+}
+
+// bindSavedSearch reads a saved search from the request and checks that its query is
+// a valid accession list query
+func (svc *ServiceContext) bindSavedSearch(c *gin.Context, search *SavedSearch) bool {
+	err := c.ShouldBindJSON(search)
+	if err != nil {
+		c.String(http.StatusBadRequest, err.Error())
+		return false
+	}
+	search.Name = strings.TrimSpace(search.Name)
+	if search.Name == "" {
+		c.String(http.StatusBadRequest, "saved search name is required")
+		return false
+	}
+	query, err := url.ParseQuery(strings.TrimPrefix(strings.TrimSpace(search.Query), "?"))
+	if err != nil {
+		c.String(http.StatusBadRequest, "invalid saved search query: %s", err.Error())
+		return false
+	}
+	_, err = svc.newAccessionQuery(query, GetAuthUser(c).ID)
+	if err != nil {
+		svc.accessionQueryError(c, err)
+		return false
+	}
+	search.Query = searchQuery(query)
+	return true
+}
+
+// AddSavedSearch is an admin API call that saves a search for the current admin
+func (svc *ServiceContext) AddSavedSearch(c *gin.Context) {
+	var search SavedSearch
+	if svc.bindSavedSearch(c, &search) == false {
+		return
+	}
+	staff := GetAuthUser(c)
+	search.ID = 0
+	search.UserID = staff.ID
+	search.OwnerName = staff.FullName()
+	search.CreatedAt = time.Now()
+	search.UpdatedAt = search.CreatedAt
+	err := svc.DB.Model(&search).Exclude("OwnerName").Insert()
+	if err != nil {
+		log.Printf("ERROR: Unable to add saved search: %s", err.Error())
+		c.String(http.StatusInternalServerError, err.Error())
+		return
+	}
+	log.Printf("%s saved search %d [%s]", staff.Email, search.ID, search.Name)
+	c.JSON(http.StatusOK, search)
+}
+
+// UpdateSavedSearch is an admin API call that changes a saved search. Only the owner
+// of a search can change it
+func (svc *ServiceContext) UpdateSavedSearch(c *gin.Context) {
+	staff := GetAuthUser(c)
+	searchID := c.Param("id")
+	search, err := loadSavedSearch(svc.DB, searchID, staff)
+	if err != nil || search.UserID != staff.ID {
+		c.String(http.StatusNotFound, "saved search %s not found", searchID)
+		return
+	}
+	var update SavedSearch
+	if svc.bindSavedSearch(c, &update) == false {
+		return
+	}
+	search.Name = update.Name
+	search.Query = update.Query
+	search.Shared = update.Shared
+	search.Notify = update.Notify
+	search.UpdatedAt = time.Now()
+	err = svc.DB.Model(search).Update("Name", "Query", "Shared", "Notify", "UpdatedAt")
+	if err != nil {
+		log.Printf("ERROR: Unable to update saved search %s: %s", searchID, err.Error())
+		c.String(http.StatusInternalServerError, err.Error())
+		return
+	}
+	log.Printf("%s updated saved search %d [%s]", staff.Email, search.ID, search.Name)
+	c.JSON(http.StatusOK, search)
+}
+
+// DeleteSavedSearch is an admin API call that deletes a saved search. Only the owner
+// of a search can delete it
+func (svc *ServiceContext) DeleteSavedSearch(c *gin.Context) {
+	staff := GetAuthUser(c)
+	searchID := c.Param("id")
+	search, err := loadSavedSearch(svc.DB, searchID, staff)
+	if err != nil || search.UserID != staff.ID {
+		c.String(http.StatusNotFound, "saved search %s not found", searchID)
+		return
+	}
+	_, err = svc.DB.Delete("saved_searches", dbx.HashExp{"id": search.ID}).Execute()
+	if err != nil {
+		log.Printf("ERROR: Unable to delete saved search %s: %s", searchID, err.Error())
+		c.String(http.StatusInternalServerError, err.Error())
+		return
+	}
+	log.Printf("%s deleted saved search %d [%s]", staff.Email, search.ID, search.Name)
+	c.String(http.StatusOK, "deleted")
+}
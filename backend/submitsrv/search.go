@@ -0,0 +1,389 @@
+package main
+
+import (
+	"fmt"
+	"html"
+	"log"
+	"net/http"
+	"regexp"
+	"strings"
+	"unicode"
+	"unicode/utf8"
+
+	"github.com/gin-gonic/gin"
+	dbx "github.com/go-ozzo/ozzo-dbx"
+)
+
+// The search index has one row per searchable field of an accession in the
+// accession_search table, which has a FULLTEXT index on its content. Rows for an
+// accession are rebuilt by the index job whenever the accession or its notes change.
+// A MySQL boolean match only sees one row at a time, so each term of a query is
+// matched on its own against all of the rows of an accession
+
+// SearchSnippet is a highlighted excerpt from one field of a search result. Matched
+// terms are wrapped in <mark> and the rest of the text is HTML escaped
+type SearchSnippet struct {
+	Field string `json:"field"`
+	Text  string `json:"text"`
+}
+
+// searchTerm is one word or phrase of a search query. Op is the MySQL boolean mode
+// operator: + for required terms, - for excluded terms and empty for optional terms
+type searchTerm struct {
+	Op     string
+	Text   string
+	Phrase bool
+	Prefix bool
+}
+
+var searchTokenRE = regexp.MustCompile(`[+-]?"[^"]*"?|\S+`)
+
+// searchWords splits text into the words MySQL will index, dropping punctuation
+func searchWords(text string) []string {
+	return strings.FieldsFunc(text, func(r rune) bool {
+		return unicode.IsLetter(r) == false && unicode.IsDigit(r) == false && r != '_'
+	})
+}
+
+// parseSearchQuery parses a search string into terms. Words are required by default.
+// Quoted text is a phrase, AND / OR / NOT may join terms, a leading + or - marks a
+// term as required or excluded, and a trailing * matches words that start with the term
+func parseSearchQuery(query string) []searchTerm {
+	terms := make([]searchTerm, 0)
+	op := "+"
+	for _, token := range searchTokenRE.FindAllString(query, -1) {
+		switch token {
+		case "AND", "&&":
+			op = "+"
+			continue
+		case "OR", "||":
+			if len(terms) > 0 && terms[len(terms)-1].Op == "+" {
+				terms[len(terms)-1].Op = ""
+			}
+			op = ""
+			continue
+		case "NOT":
+			op = "-"
+			continue
+		}
+		term := searchTerm{Op: op}
+		op = "+"
+		if strings.HasPrefix(token, "+") || strings.HasPrefix(token, "-") {
+			term.Op = token[:1]
+			token = token[1:]
+		}
+		if strings.HasPrefix(token, `"`) {
+			term.Phrase = true
+			token = strings.Trim(token, `"`)
+		} else if strings.HasSuffix(token, "*") {
+			term.Prefix = true
+		}
+		words := searchWords(token)
+		if len(words) == 0 {
+			continue
+		}
+		// punctuation inside a word, as in a hyphenated name, makes it a phrase
+		if len(words) > 1 {
+			term.Phrase = true
+			term.Prefix = false
+		}
+		term.Text = strings.Join(words, " ")
+		terms = append(terms, term)
+	}
+	return terms
+}
+
+// booleanQuery returns the MySQL boolean mode search string for the terms
+func booleanQuery(terms []searchTerm) string {
+	parts := make([]string, 0, len(terms))
+	for _, term := range terms {
+		text := term.Text
+		if term.Phrase {
+			text = `"` + text + `"`
+		} else if term.Prefix {
+			text += "*"
+		}
+		parts = append(parts, term.Op+text)
+	}
+	return strings.Join(parts, " ")
+}
+
+// rankQuery returns the boolean mode search string used to rank and highlight the
+// field rows of an accession. Every term that is not excluded is optional, so a row
+// scores for each term it holds even when other terms are in other fields
+func rankQuery(terms []searchTerm) string {
+	optional := make([]searchTerm, 0, len(terms))
+	for _, term := range terms {
+		if term.Op != "-" {
+			term.Op = ""
+			optional = append(optional, term)
+		}
+	}
+	return booleanQuery(optional)
+}
+
+// searchConditions returns the where conditions that select the accessions matching
+// the terms, adding the term params to params. Required terms must match some field
+// of the accession and excluded terms must match none of them. Optional terms are only
+// needed when there are no required terms, and then at least one must match
+func searchConditions(terms []searchTerm, params dbx.Params) []string {
+	conds := make([]string, 0, len(terms))
+	optional := make([]string, 0)
+	for idx, term := range terms {
+		name := fmt.Sprintf("q%d", idx)
+		params[name] = booleanQuery([]searchTerm{{Text: term.Text, Phrase: term.Phrase, Prefix: term.Prefix}})
+		exists := fmt.Sprintf(`exists (select 1 from accession_search s
+			where s.accession_id=a.id and match(s.content) against ({:%s} in boolean mode))`, name)
+		switch term.Op {
+		case "+":
+			conds = append(conds, exists)
+		case "-":
+			conds = append(conds, "not "+exists)
+		default:
+			optional = append(optional, exists)
+		}
+	}
+	if len(optional) > 0 && hasRequiredTerm(terms) == false {
+		conds = append(conds, "("+strings.Join(optional, " or ")+")")
+	}
+	return conds
+}
+
+// hasRequiredTerm returns true if any of the terms is required
+func hasRequiredTerm(terms []searchTerm) bool {
+	for _, term := range terms {
+		if term.Op == "+" {
+			return true
+		}
+	}
+	return false
+}
+
+// highlightRE returns a case insensitive pattern that matches any of the terms that
+// are not excluded, or nil if there are none
+func highlightRE(terms []searchTerm) *regexp.Regexp {
+	alts := make([]string, 0, len(terms))
+	for _, term := range terms {
+		if term.Op == "-" {
+			continue
+		}
+		words := strings.Split(term.Text, " ")
+		for idx, w := range words {
+			words[idx] = regexp.QuoteMeta(w)
+		}
+		pattern := `\b` + strings.Join(words, `\W+`)
+		if term.Prefix {
+			pattern += `\w*`
+		} else {
+			pattern += `\b`
+		}
+		alts = append(alts, pattern)
+	}
+	if len(alts) == 0 {
+		return nil
+	}
+	return regexp.MustCompile(`(?i)(?:` + strings.Join(alts, "|") + `)`)
+}
+
+// makeSnippet returns an excerpt of the content around the first match with all of the
+// matches in it highlighted. It is empty if nothing in the content matches
+func makeSnippet(content string, re *regexp.Regexp) string {
+	const before, after = 60, 140
+	loc := re.FindStringIndex(content)
+	if loc == nil {
+		return ""
+	}
+	start := loc[0] - before
+	if start <= 0 {
+		start = 0
+	} else if sp := strings.IndexAny(content[start:loc[0]], " \n\t"); sp >= 0 {
+		start += sp + 1
+	}
+	for start > 0 && utf8.RuneStart(content[start]) == false {
+		start--
+	}
+	end := loc[1] + after
+	if end >= len(content) {
+		end = len(content)
+	} else if sp := strings.LastIndexAny(content[loc[1]:end], " \n\t"); sp >= 0 {
+		end = loc[1] + sp
+	}
+	for end < len(content) && utf8.RuneStart(content[end]) == false {
+		end++
+	}
+
+	window := content[start:end]
+	var out strings.Builder
+	if start > 0 {
+		out.WriteString("…")
+	}
+	prev := 0
+	for _, m := range re.FindAllStringIndex(window, -1) {
+		out.WriteString(html.EscapeString(window[prev:m[0]]))
+		out.WriteString("<mark>" + html.EscapeString(window[m[0]:m[1]]) + "</mark>")
+		prev = m[1]
+	}
+	out.WriteString(html.EscapeString(window[prev:]))
+	if end < len(content) {
+		out.WriteString("…")
+	}
+	return strings.Join(strings.Fields(out.String()), " ")
+}
+
+// getSearchSnippets returns the highlighted snippets of the fields of each accession
+// that match the search terms
+func getSearchSnippets(db *dbx.DB, accessionIDs []int, terms []searchTerm) map[int][]SearchSnippet {
+	out := make(map[int][]SearchSnippet)
+	re := highlightRE(terms)
+	if re == nil || len(accessionIDs) == 0 {
+		return out
+	}
+	ids := make([]interface{}, 0, len(accessionIDs))
+	for _, id := range accessionIDs {
+		ids = append(ids, id)
+	}
+	var rows []struct {
+		AccessionID int    `db:"accession_id"`
+		Field       string `db:"field"`
+		Content     string `db:"content"`
+	}
+	q := db.Select("accession_id", "field", "content").From("accession_search").
+		Where(dbx.And(dbx.In("accession_id", ids...),
+			dbx.NewExp("match(content) against ({:q} in boolean mode)", dbx.Params{"q": rankQuery(terms)}))).
+		OrderBy("accession_id", "id")
+	err := q.All(&rows)
+	if err != nil {
+		log.Printf("ERROR: Unable to get search snippets: %s", err.Error())
+		return out
+	}
+	for _, row := range rows {
+		text := makeSnippet(row.Content, re)
+		if text != "" {
+			out[row.AccessionID] = append(out[row.AccessionID], SearchSnippet{Field: row.Field, Text: text})
+		}
+	}
+	return out
+}
+
+// IndexAccession rebuilds the search index rows of an accession
+func IndexAccession(db *dbx.DB, a *Accession) error {
+	fields := make([][2]string, 0)
+	add := func(field string, values ...string) {
+		parts := make([]string, 0, len(values))
+		for _, v := range values {
+			if v = strings.TrimSpace(v); v != "" {
+				parts = append(parts, v)
+			}
+		}
+		if len(parts) > 0 {
+			fields = append(fields, [2]string{field, strings.Join(parts, "\n")})
+		}
+	}
+	deref := func(s *string) string {
+		if s == nil {
+			return ""
+		}
+		return *s
+	}
+
+	add("accession", a.AccessionNumber, a.Identifier)
+	add("summary", a.Summary)
+	add("activities", deref(a.Activities))
+	add("creator", deref(a.Creator))
+	add("submitter", a.User.FullName(), a.User.Email)
+	if a.Owner != nil {
+		add("owner", a.Owner.FullName(), a.Owner.Email)
+	}
+	add("unit", a.Unit)
+	if a.DigitalTransfer {
+		add("digital", a.Digital.Description, deref(a.Digital.DateRange))
+		add("files", a.Digital.Files...)
+	}
+	if a.PhysicalTransfer {
+		add("physical", a.Physical.BoxInfo, a.Physical.TechInfo, a.Physical.DateRange)
+		boxes := make([]string, 0, len(a.Physical.Inventory))
+		for _, item := range a.Physical.Inventory {
+			boxes = append(boxes, strings.Join([]string{item.BoxNumber, item.RecordGroup, item.Title,
+				item.Description, item.Dates, item.Note}, " "))
+		}
+		add("inventory", boxes...)
+	}
+	for _, att := range a.GetAttachments(db) {
+		add("attachment", att.Title, att.Filename)
+	}
+	var notes []Note
+	nq := db.NewQuery(`select n.*, "" as user_name from notes n
+		inner join accession_notes an on an.note_id = n.id where an.accession_id={:id}`)
+	nq.Bind(dbx.Params{"id": a.ID})
+	err := nq.All(&notes)
+	if err != nil {
+		return err
+	}
+	for _, note := range notes {
+		add("note", note.Title, note.Note)
+	}
+
+	tx, _ := db.Begin()
+	_, err = tx.Delete("accession_search", dbx.HashExp{"accession_id": a.ID}).Execute()
+	for idx := 0; err == nil && idx < len(fields); idx++ {
+		_, err = tx.Insert("accession_search", dbx.Params{
+			"accession_id": a.ID,
+			"field":        fields[idx][0],
+			"content":      fields[idx][1],
+		}).Execute()
+	}
+	if err != nil {
+		tx.Rollback()
+		return err
+	}
+	return tx.Commit()
+}
+
+// indexJob refreshes the search index for an accession after it changes
+func indexJob(svc *ServiceContext, accession *Accession) error {
+	return IndexAccession(svc.DB, accession)
+}
+
+// IndexAccessions queues the index job for every accession that is not in the search
+// index, such as those submitted before search was added
+func (svc *ServiceContext) IndexAccessions() error {
+	var accs []struct{ ID int }
+	err := svc.DB.NewQuery(`select id from accessions a
+		where not exists (select 1 from accession_search s where s.accession_id=a.id)
+		and not exists (select 1 from jobs j where j.accession_id=a.id and j.job_type="index" and j.status="pending")`).All(&accs)
+	if err != nil {
+		return err
+	}
+	for _, acc := range accs {
+		err = EnqueueJob(svc.DB, acc.ID, "index")
+		if err != nil {
+			return err
+		}
+	}
+	if len(accs) > 0 {
+		log.Printf("Queued %d unindexed accessions for indexing", len(accs))
+	}
+	return nil
+}
+
+// ReindexAccessions is an admin API call that queues a rebuild of the search index for
+// every accession
+func (svc *ServiceContext) ReindexAccessions(c *gin.Context) {
+	var all []struct{ ID int }
+	err := svc.DB.NewQuery("select id from accessions").All(&all)
+	if err != nil {
+		log.Printf("ERROR: Unable to get accessions to reindex: %s", err.Error())
+		c.String(http.StatusInternalServerError, err.Error())
+		return
+	}
+	for _, acc := range all {
+		err = EnqueueJob(svc.DB, acc.ID, "index")
+		if err != nil {
+			log.Printf("ERROR: Unable to queue index job for accession %d: %s", acc.ID, err.Error())
+			c.String(http.StatusInternalServerError, err.Error())
+			return
+		}
+	}
+	log.Printf("%s queued reindexing of %d accessions", GetAuthUser(c).Email, len(all))
+	c.String(http.StatusOK, "%d accessions queued for indexing", len(all))
+}
@@ -0,0 +1,134 @@
+package main
+
+import (
+	"testing"
+
+	dbx "github.com/go-ozzo/ozzo-dbx"
+)
+
+func TestBooleanQuery(t *testing.T) {
+	tests := []struct {
+		name  string
+		query string
+		want  string
+	}{
+		{"empty", "", ""},
+		{"words", "letters diaries", "+letters +diaries"},
+		{"phrase", `"board minutes"`, `+"board minutes"`},
+		{"phrase and word", `"board minutes" 1962`, `+"board minutes" +1962`},
+		{"unbalanced quote", `"board minutes`, `+"board minutes"`},
+		{"lone quote", `"`, ""},
+		{"empty quotes", `letters ""`, "+letters"},
+		{"excluded phrase", `-"draft copy"`, `-"draft copy"`},
+		{"quoted prefix", `"corr*"`, `+"corr"`},
+		{"or", "letters OR diaries", "letters diaries"},
+		{"or symbol", "letters || diaries", "letters diaries"},
+		{"and", "letters AND diaries", "+letters +diaries"},
+		{"not", "letters NOT drafts", "+letters -drafts"},
+		{"trailing operator", "letters OR", "letters"},
+		{"only operators", "AND OR NOT", ""},
+		{"lowercase operator is a word", "letters or diaries", "+letters +or +diaries"},
+		{"leading signs", "-drafts +final", "-drafts +final"},
+		{"doubled sign", "++letters --drafts", "+letters -drafts"},
+		{"prefix", "corr*", "+corr*"},
+		{"stray signs", "+ - *", ""},
+		{"stray operator characters", `@ > ~ < ( ) letters`, "+letters"},
+		{"operators inside word", "foo(bar)", `+"foo bar"`},
+		{"hyphenated name", "Smith-Jones", `+"Smith Jones"`},
+		{"apostrophe", "O'Brien", `+"O Brien"`},
+		{"prefix with punctuation", "smith-j*", `+"smith j"`},
+		{"accession number", "2026-0042", `+"2026 0042"`},
+		{"accession number with prefix", "RG-2026-0042", `+"RG 2026 0042"`},
+	}
+	for _, tt := range tests {
+		got := booleanQuery(parseSearchQuery(tt.query))
+		if got != tt.want {
+			t.Errorf("%s: booleanQuery(%q) = %q, want %q", tt.name, tt.query, got, tt.want)
+		}
+	}
+}
+
+func TestParseSearchQueryTerms(t *testing.T) {
+	tests := []struct {
+		query string
+		want  []searchTerm
+	}{
+		{"corr*", []searchTerm{{Op: "+", Text: "corr", Prefix: true}}},
+		{`"board minutes"`, []searchTerm{{Op: "+", Text: "board minutes", Phrase: true}}},
+		{"letters OR diaries", []searchTerm{{Op: "", Text: "letters"}, {Op: "", Text: "diaries"}}},
+		{"NOT -drafts", []searchTerm{{Op: "-", Text: "drafts"}}},
+	}
+	for _, tt := range tests {
+		got := parseSearchQuery(tt.query)
+		if len(got) != len(tt.want) {
+			t.Errorf("parseSearchQuery(%q) = %+v, want %+v", tt.query, got, tt.want)
+			continue
+		}
+		for idx := range got {
+			if got[idx] != tt.want[idx] {
+				t.Errorf("parseSearchQuery(%q) term %d = %+v, want %+v", tt.query, idx, got[idx], tt.want[idx])
+			}
+		}
+	}
+}
+
+func TestSearchConditions(t *testing.T) {
+	exists := func(name string) string {
+		return `exists (select 1 from accession_search s
+			where s.accession_id=a.id and match(s.content) against ({:` + name + `} in boolean mode))`
+	}
+	tests := []struct {
+		name       string
+		query      string
+		wantConds  []string
+		wantParams map[string]string
+		wantRank   string
+	}{
+		{"cross field and", "box budget",
+			[]string{exists("q0"), exists("q1")},
+			map[string]string{"q0": "box", "q1": "budget"}, "box budget"},
+		{"not", "letters NOT draft",
+			[]string{exists("q0"), "not " + exists("q1")},
+			map[string]string{"q0": "letters", "q1": "draft"}, "letters"},
+		{"excluded phrase", `minutes -"draft copy"`,
+			[]string{exists("q0"), "not " + exists("q1")},
+			map[string]string{"q0": "minutes", "q1": `"draft copy"`}, "minutes"},
+		{"only excluded", "-draft",
+			[]string{"not " + exists("q0")},
+			map[string]string{"q0": "draft"}, ""},
+		{"or", "letters OR diaries",
+			[]string{"(" + exists("q0") + " or " + exists("q1") + ")"},
+			map[string]string{"q0": "letters", "q1": "diaries"}, "letters diaries"},
+		{"required with optional", "+minutes letters OR diaries",
+			[]string{exists("q0")},
+			map[string]string{"q0": "minutes", "q1": "letters", "q2": "diaries"}, "minutes letters diaries"},
+		{"prefix", "corr* -smith",
+			[]string{exists("q0"), "not " + exists("q1")},
+			map[string]string{"q0": "corr*", "q1": "smith"}, "corr*"},
+	}
+	for _, tt := range tests {
+		terms := parseSearchQuery(tt.query)
+		params := dbx.Params{}
+		conds := searchConditions(terms, params)
+		if len(conds) != len(tt.wantConds) {
+			t.Errorf("%s: got conditions %v, want %v", tt.name, conds, tt.wantConds)
+			continue
+		}
+		for idx := range conds {
+			if conds[idx] != tt.wantConds[idx] {
+				t.Errorf("%s: condition %d = %s, want %s", tt.name, idx, conds[idx], tt.wantConds[idx])
+			}
+		}
+		if len(params) != len(tt.wantParams) {
+			t.Errorf("%s: got params %v, want %v", tt.name, params, tt.wantParams)
+		}
+		for name, want := range tt.wantParams {
+			if params[name] != want {
+				t.Errorf("%s: param %s = %v, want %s", tt.name, name, params[name], want)
+			}
+		}
+		if got := rankQuery(terms); got != tt.wantRank {
+			t.Errorf("%s: rankQuery = %q, want %q", tt.name, got, tt.wantRank)
+		}
+	}
+}
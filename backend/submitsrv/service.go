@@ -14,11 +14,15 @@ import (
 
 // ServiceContext contains the data
 type ServiceContext struct {
-	UploadDir   string
-	DevAuthUser string
-	Hostname    string
-	DB          *dbx.DB
-	SMTP        SMTPConfig
+	UploadDir             string
+	DevAuthUser           string
+	Hostname              string
+	ScanCommand           string
+	AccessionNumberFormat string
+	MaxPageSize           int
+	DB                    *dbx.DB
+	SMTP                  SMTPConfig
+	Counts                *countCache
 }
 
 // Init will initialize the service context based on the config parameters
@@ -28,6 +32,10 @@ func (svc *ServiceContext) Init(cfg *ServiceConfig) {
 	svc.DevAuthUser = cfg.DevAuthUser
 	svc.Hostname = cfg.Hostname
 	svc.SMTP = cfg.SMTP
+	svc.ScanCommand = cfg.ScanCommand
+	svc.AccessionNumberFormat = cfg.AccessionNumberFormat
+	svc.MaxPageSize = cfg.MaxPageSize
+	svc.Counts = &countCache{}
 
 	log.Printf("Init DB connection to %s...", cfg.DBHost)
 	connectStr := fmt.Sprintf("%s:%s@tcp(%s)/%s?parseTime=true", cfg.DBUser, cfg.DBPass, cfg.DBHost, cfg.DBName)
@@ -0,0 +1,256 @@
+package main
+
+import (
+	"fmt"
+	"log"
+	"net/http"
+	"strings"
+	"time"
+
+	"github.com/gin-gonic/gin"
+	dbx "github.com/go-ozzo/ozzo-dbx"
+)
+
+// SLARule is a service target for processing accessions: accessions of the transfer
+// type must reach the status within the number of days after submission. Reminders
+// are sent WarnDays before the deadline and again once it has passed
+type SLARule struct {
+	ID           int    `json:"id" db:"id"`
+	Status       string `json:"status" db:"status" binding:"required"`
+	TransferType string `json:"transferType" db:"transfer_type"`
+	Days         int    `json:"days" db:"days" binding:"required"`
+	WarnDays     int    `json:"warnDays" db:"warn_days"`
+	Description  string `json:"description" db:"description"`
+}
+
+// TableName defines the expected DB table name that holds data for SLA rules
+func (r *SLARule) TableName() string {
+	return "sla_rules"
+}
+
+// IsTransferType returns true if the type is a supported SLA rule transfer type
+func IsTransferType(transferType string) bool {
+	switch transferType {
+	case "any", "digital", "physical":
+		return true
+	}
+	return false
+}
+
+// appliesTo returns true if the rule covers the transfer type of the accession
+func (r *SLARule) appliesTo(a *Accession) bool {
+	switch r.TransferType {
+	case "digital":
+		return a.DigitalTransfer
+	case "physical":
+		return a.PhysicalTransfer
+	}
+	return true
+}
+
+// getSLARules returns all of the SLA rules, shortest deadline first
+func getSLARules(db dbx.Builder) ([]SLARule, error) {
+	rules := make([]SLARule, 0)
+	err := db.NewQuery("select * from sla_rules order by days asc, id asc").All(&rules)
+	return rules, err
+}
+
+// nextDeadline finds the earliest deadline of the rules the accession has not yet met.
+// The rule is nil if the accession has no outstanding deadline
+func (a *Accession) nextDeadline(rules []SLARule) (*SLARule, *time.Time) {
+	var next *SLARule
+	var due *time.Time
+	for idx := range rules {
+		rule := &rules[idx]
+		if rule.appliesTo(a) == false || statusRank(a.Status) >= statusRank(rule.Status) {
+			continue
+		}
+		ruleDue := a.CreatedAt.AddDate(0, 0, rule.Days)
+		if due == nil || ruleDue.Before(*due) {
+			next = rule
+			due = &ruleDue
+		}
+	}
+	return next, due
+}
+
+// UpdateDeadline recalculates the due date of an accession from the SLA rules and its
+// current status, and schedules the reminders for it. The overdue reminder is not
+// scheduled again if it was already sent for the deadline
+func (a *Accession) UpdateDeadline(db dbx.Builder) error {
+	rules, err := getSLARules(db)
+	if err != nil {
+		return err
+	}
+	rule, due := a.nextDeadline(rules)
+	a.DueAt = due
+	dueStatus := ""
+	if rule != nil {
+		dueStatus = rule.Status
+	}
+	if dueStatus != a.DueStatus {
+		a.RemindedAt = nil
+	}
+	a.DueStatus = dueStatus
+	_, err = db.Update("accessions", dbx.Params{"due_at": a.DueAt, "due_status": a.DueStatus,
+		"reminded_at": a.RemindedAt}, dbx.HashExp{"id": a.ID}).Execute()
+	if err != nil {
+		return err
+	}
+
+	q := db.NewQuery(`delete from jobs where accession_id={:id} and job_type="deadline" and status="pending"`)
+	q.Bind(dbx.Params{"id": a.ID})
+	_, err = q.Execute()
+	if err != nil || rule == nil {
+		return err
+	}
+	warnAt := due.AddDate(0, 0, -rule.WarnDays)
+	if rule.WarnDays > 0 && warnAt.After(time.Now()) {
+		err = EnqueueJobAt(db, a.ID, "deadline", warnAt)
+		if err != nil {
+			return err
+		}
+	}
+	if a.RemindedAt != nil && a.RemindedAt.Before(*due) == false {
+		return nil
+	}
+	return EnqueueJobAt(db, a.ID, "deadline", *due)
+}
+
+// deadlineJob reminds the assignees of an accession, or all admins if it is unassigned,
+// that its deadline is approaching or has passed. The reminder is skipped if the
+// deadline was met or moved after the job was queued
+func deadlineJob(svc *ServiceContext, accession *Accession) error {
+	rules, err := getSLARules(svc.DB)
+	if err != nil {
+		return err
+	}
+	rule, due := accession.nextDeadline(rules)
+	now := time.Now()
+	if rule == nil || now.Before(due.AddDate(0, 0, -rule.WarnDays)) {
+		log.Printf("Deadline for accession %d is no longer due; skipping reminder", accession.ID)
+		return nil
+	}
+	overdue := now.Before(*due) == false
+	if overdue && accession.RemindedAt != nil && accession.RemindedAt.Before(*due) == false {
+		log.Printf("Overdue reminder for accession %d was already sent; skipping", accession.ID)
+		return nil
+	}
+
+	to := make([]string, 0)
+	for _, asg := range accession.GetAssignments(svc.DB) {
+		if asg.UnassignedAt == nil {
+			var assignee User
+			if svc.DB.Select().Model(asg.UserID, &assignee) == nil {
+				to = append(to, assignee.Email)
+			}
+		}
+	}
+	if len(to) == 0 {
+		to = GetAdminEmails(svc.DB)
+	}
+
+	data := struct {
+		Accession *Accession
+		Rule      *SLARule
+		DueAt     string
+		Overdue   bool
+		URL       string
+	}{Accession: accession, Rule: rule, DueAt: due.Format("2006-01-02"), Overdue: overdue,
+		URL: fmt.Sprintf("https://%s/admin/accessions/%d", svc.Hostname, accession.ID)}
+	body, err := RenderEmailTemplate("deadline_email.html", data)
+	if err != nil {
+		log.Printf("ERROR: Unable to render deadline email: %s", err.Error())
+		return err
+	}
+	subject := fmt.Sprintf("UVA Archives Transfer %s Due %s", accession.AccessionNumber, data.DueAt)
+	if overdue {
+		subject = fmt.Sprintf("UVA Archives Transfer %s Overdue", accession.AccessionNumber)
+	}
+	err = svc.SMTP.SendEmail(EmailRequest{Subject: subject, To: to, Body: body})
+	if err != nil || overdue == false {
+		return err
+	}
+	_, err = svc.DB.Update("accessions", dbx.Params{"reminded_at": now},
+		dbx.HashExp{"id": accession.ID}).Execute()
+	return err
+}
+
+// GetSLARules is an admin API call that returns the SLA rules
+func (svc *ServiceContext) GetSLARules(c *gin.Context) {
+	rules, err := getSLARules(svc.DB)
+	if err != nil {
+		log.Printf("ERROR: Unable to get SLA rules: %s", err.Error())
+		c.String(http.StatusInternalServerError, err.Error())
+		return
+	}
+	c.JSON(http.StatusOK, rules)
+}
+
+// UpdateSLARules is an admin API call that replaces the SLA rules. The due dates of all
+// open accessions are recalculated with the new rules
+func (svc *ServiceContext) UpdateSLARules(c *gin.Context) {
+	var rules []SLARule
+	err := c.ShouldBindJSON(&rules)
+	if err != nil {
+		c.String(http.StatusBadRequest, err.Error())
+		return
+	}
+	for idx := range rules {
+		rule := &rules[idx]
+		rule.ID = 0
+		rule.TransferType = strings.TrimSpace(rule.TransferType)
+		if rule.TransferType == "" {
+			rule.TransferType = "any"
+		}
+		if IsAccessionStatus(rule.Status) == false || rule.Status == "submitted" {
+			c.String(http.StatusBadRequest, "invalid SLA rule status %s", rule.Status)
+			return
+		}
+		if IsTransferType(rule.TransferType) == false {
+			c.String(http.StatusBadRequest, "invalid SLA rule transfer type %s", rule.TransferType)
+			return
+		}
+		if rule.Days <= 0 || rule.WarnDays < 0 {
+			c.String(http.StatusBadRequest, "SLA rule days must be positive")
+			return
+		}
+	}
+
+	// deadlines of open accessions are recalculated by queued jobs rather than in the
+	// request. The jobs are queued with the rule change so neither happens without the other
+	staff := GetAuthUser(c)
+	tx, _ := svc.DB.Begin()
+	_, err = tx.NewQuery("delete from sla_rules").Execute()
+	for idx := 0; err == nil && idx < len(rules); idx++ {
+		err = tx.Model(&rules[idx]).Insert()
+	}
+	var queued int64
+	if err == nil {
+		queued, err = EnqueueAccessionJobs(tx, "sla_recalc", `a.status != "completed"`, nil)
+	}
+	if err != nil {
+		log.Printf("ERROR: Unable to update SLA rules: %s", err.Error())
+		tx.Rollback()
+		c.String(http.StatusInternalServerError, err.Error())
+		return
+	}
+	err = tx.Commit()
+	if err != nil {
+		log.Printf("ERROR: Unable to commit SLA rules: %s", err.Error())
+		c.String(http.StatusInternalServerError, err.Error())
+		return
+	}
+	log.Printf("%s updated the SLA rules; queued deadline recalculation for %d open accessions", staff.Email, queued)
+
+	type SLAUpdate struct {
+		Rules         []SLARule `json:"rules"`
+		Recalculating int64     `json:"recalculating"`
+	}
+	c.JSON(http.StatusOK, SLAUpdate{Rules: rules, Recalculating: queued})
+}
+
+// slaRecalcJob recalculates the deadline of an accession after the SLA rules change
+func slaRecalcJob(svc *ServiceContext, accession *Accession) error {
+	return accession.UpdateDeadline(svc.DB)
+}
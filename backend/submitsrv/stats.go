@@ -0,0 +1,306 @@
+package main
+
+import (
+	"fmt"
+	"log"
+	"math"
+	"net/http"
+	"sort"
+	"strconv"
+	"strings"
+	"time"
+
+	"github.com/gin-gonic/gin"
+	dbx "github.com/go-ozzo/ozzo-dbx"
+)
+
+// The statistics dashboard reports on the accessions submitted in a date range. Time
+// series are grouped into periods of a week, month, quarter or year and every period
+// in the range is listed, so the values line up with the periods for charting
+
+// statsGrouping is a period length of the statistics time series. PeriodQS returns the
+// SQL that formats a date column as the period label, and label does the same in Go
+type statsGrouping struct {
+	periodQS func(column string) string
+	label    func(t time.Time) string
+	start    func(t time.Time) time.Time
+	next     func(t time.Time) time.Time
+}
+
+var statsGroupings = map[string]statsGrouping{
+	"week": {
+		periodQS: func(column string) string { return fmt.Sprintf(`date_format(%s, "%%x-W%%v")`, column) },
+		label: func(t time.Time) string {
+			year, week := t.ISOWeek()
+			return fmt.Sprintf("%d-W%02d", year, week)
+		},
+		start: func(t time.Time) time.Time { return t.AddDate(0, 0, -((int(t.Weekday()) + 6) % 7)) },
+		next:  func(t time.Time) time.Time { return t.AddDate(0, 0, 7) },
+	},
+	"month": {
+		periodQS: func(column string) string { return fmt.Sprintf(`date_format(%s, "%%Y-%%m")`, column) },
+		label:    func(t time.Time) string { return t.Format("2006-01") },
+		start:    func(t time.Time) time.Time { return t.AddDate(0, 0, 1-t.Day()) },
+		next:     func(t time.Time) time.Time { return t.AddDate(0, 1, 0) },
+	},
+	"quarter": {
+		periodQS: func(column string) string {
+			return fmt.Sprintf(`concat(year(%s), "-Q", quarter(%s))`, column, column)
+		},
+		label: func(t time.Time) string { return fmt.Sprintf("%d-Q%d", t.Year(), (int(t.Month())+2)/3) },
+		start: func(t time.Time) time.Time {
+			return time.Date(t.Year(), time.Month((int(t.Month())-1)/3*3+1), 1, 0, 0, 0, 0, t.Location())
+		},
+		next: func(t time.Time) time.Time { return t.AddDate(0, 3, 0) },
+	},
+	"year": {
+		periodQS: func(column string) string { return fmt.Sprintf(`date_format(%s, "%%Y")`, column) },
+		label:    func(t time.Time) string { return t.Format("2006") },
+		start:    func(t time.Time) time.Time { return time.Date(t.Year(), 1, 1, 0, 0, 0, 0, t.Location()) },
+		next:     func(t time.Time) time.Time { return t.AddDate(1, 0, 0) },
+	},
+}
+
+// StatsCount is the count of accessions with one value, such as a genre or unit
+type StatsCount struct {
+	Label string `json:"label" db:"label"`
+	Count int    `json:"count" db:"count"`
+}
+
+// StatusTime is the median time from submission for accessions to reach a status.
+// Count is the number of accessions in the range that have reached it
+type StatusTime struct {
+	Status     string  `json:"status"`
+	MedianDays float64 `json:"medianDays"`
+	Count      int     `json:"count"`
+}
+
+// statsRequest is the date range and grouping of a statistics request. To is the day
+// after the last day of the range
+type statsRequest struct {
+	from     time.Time
+	to       time.Time
+	group    string
+	grouping statsGrouping
+	top      int
+}
+
+// parseStatsRequest reads the from, to, group and top params of a statistics request.
+// The range defaults to the last twelve months grouped by month, and top to ten units
+func parseStatsRequest(c *gin.Context) (*statsRequest, error) {
+	now := time.Now()
+	today := time.Date(now.Year(), now.Month(), now.Day(), 0, 0, 0, 0, time.Local)
+	req := statsRequest{to: today.AddDate(0, 0, 1), group: "month", top: 10}
+	req.from = req.to.AddDate(0, -12, 0)
+	if toStr := strings.TrimSpace(c.Query("to")); toStr != "" {
+		to, err := time.ParseInLocation("2006-01-02", toStr, time.Local)
+		if err != nil {
+			return nil, fmt.Errorf("invalid to date %s", toStr)
+		}
+		req.to = to.AddDate(0, 0, 1)
+		req.from = req.to.AddDate(0, -12, 0)
+	}
+	if fromStr := strings.TrimSpace(c.Query("from")); fromStr != "" {
+		from, err := time.ParseInLocation("2006-01-02", fromStr, time.Local)
+		if err != nil {
+			return nil, fmt.Errorf("invalid from date %s", fromStr)
+		}
+		req.from = from
+	}
+	if req.from.Before(req.to) == false {
+		return nil, fmt.Errorf("from date must not be after to date")
+	}
+	if group := strings.TrimSpace(c.Query("group")); group != "" {
+		req.group = group
+	}
+	grouping, ok := statsGroupings[req.group]
+	if ok == false {
+		return nil, fmt.Errorf("invalid group %s", req.group)
+	}
+	req.grouping = grouping
+	if topStr := c.Query("top"); topStr != "" {
+		top, err := strconv.Atoi(topStr)
+		if err != nil || top < 1 {
+			return nil, fmt.Errorf("invalid top %s", topStr)
+		}
+		req.top = top
+	}
+	return &req, nil
+}
+
+// periods returns the labels of every period in the range, in order
+func (sr *statsRequest) periods() []string {
+	out := make([]string, 0)
+	for t := sr.grouping.start(sr.from); t.Before(sr.to); t = sr.grouping.next(t) {
+		out = append(out, sr.grouping.label(t))
+	}
+	return out
+}
+
+// params returns the SQL params for the date range
+func (sr *statsRequest) params() dbx.Params {
+	return dbx.Params{"from": sr.from, "to": sr.to}
+}
+
+// series runs a query of period and value rows and returns the values in period order.
+// Periods with no row are zero
+func (sr *statsRequest) series(db *dbx.DB, periods []string, sql string) ([]float64, error) {
+	var rows []struct {
+		Period string  `db:"period"`
+		Value  float64 `db:"value"`
+	}
+	q := db.NewQuery(sql)
+	q.Bind(sr.params())
+	err := q.All(&rows)
+	if err != nil {
+		return nil, err
+	}
+	byPeriod := make(map[string]float64)
+	for _, row := range rows {
+		byPeriod[row.Period] = row.Value
+	}
+	out := make([]float64, len(periods))
+	for idx, p := range periods {
+		out[idx] = byPeriod[p]
+	}
+	return out, nil
+}
+
+// facetCounts counts the accessions in the range for each value of an accession list
+// facet, most common first. A limit of zero returns every value
+func (sr *statsRequest) facetCounts(db *dbx.DB, name string, limit int) ([]StatsCount, error) {
+	out := make([]StatsCount, 0)
+	for _, def := range accessionFacets {
+		if def.Name != name {
+			continue
+		}
+		qs := fmt.Sprintf(`select min(fv.label) as label, count(distinct fv.accession_id) as count from (%s) fv
+			inner join accessions a on a.id = fv.accession_id
+			where a.created_at >= {:from} and a.created_at < {:to}
+			group by fv.id order by count desc, label asc`, def.valuesQS)
+		if limit > 0 {
+			qs += fmt.Sprintf(" limit %d", limit)
+		}
+		q := db.NewQuery(qs)
+		q.Bind(sr.params())
+		err := q.All(&out)
+		return out, err
+	}
+	return out, fmt.Errorf("unknown facet %s", name)
+}
+
+// statusTimes returns the median time from submission to the first time accessions
+// in the range reached each status after submitted
+func (sr *statsRequest) statusTimes(db *dbx.DB) ([]StatusTime, error) {
+	var rows []struct {
+		Status  string `db:"status"`
+		Seconds int64  `db:"seconds"`
+	}
+	q := db.NewQuery(`select h.status, timestampdiff(second, a.created_at, min(h.created_at)) as seconds
+		from accessions a inner join accession_status_history h on h.accession_id = a.id
+		where a.created_at >= {:from} and a.created_at < {:to} and h.status != "submitted"
+		group by a.id, h.status`)
+	q.Bind(sr.params())
+	err := q.All(&rows)
+	if err != nil {
+		return nil, err
+	}
+	byStatus := make(map[string][]int64)
+	for _, row := range rows {
+		byStatus[row.Status] = append(byStatus[row.Status], row.Seconds)
+	}
+	out := make([]StatusTime, 0)
+	for _, status := range accessionStatuses {
+		if status == "submitted" {
+			continue
+		}
+		times := byStatus[status]
+		st := StatusTime{Status: status, Count: len(times)}
+		if len(times) > 0 {
+			sort.Slice(times, func(i, j int) bool { return times[i] < times[j] })
+			median := float64(times[len(times)/2])
+			if len(times)%2 == 0 {
+				median = float64(times[len(times)/2-1]+times[len(times)/2]) / 2
+			}
+			st.MedianDays = roundStat(median / (24 * 60 * 60))
+		}
+		out = append(out, st)
+	}
+	return out, nil
+}
+
+// roundStat rounds a statistic to two decimal places
+func roundStat(val float64) float64 {
+	return math.Round(val*100) / 100
+}
+
+// GetStats is an admin API call that returns the statistics dashboard for a date range.
+// Params are from and to as YYYY-MM-DD, group as week, month, quarter or year and
+// top as the number of units to list
+func (svc *ServiceContext) GetStats(c *gin.Context) {
+	type Stats struct {
+		From          string       `json:"from"`
+		To            string       `json:"to"`
+		Group         string       `json:"group"`
+		Periods       []string     `json:"periods"`
+		Accessions    []float64    `json:"accessions"`
+		DigitalGB     []float64    `json:"digitalGB"`
+		PhysicalBoxes []float64    `json:"physicalBoxes"`
+		Genres        []StatsCount `json:"genres"`
+		RecordTypes   []StatsCount `json:"recordTypes"`
+		TopUnits      []StatsCount `json:"topUnits"`
+		StatusTimes   []StatusTime `json:"statusTimes"`
+	}
+
+	sr, err := parseStatsRequest(c)
+	if err != nil {
+		c.String(http.StatusBadRequest, err.Error())
+		return
+	}
+	log.Printf("Get statistics from %s to %s by %s", sr.from.Format("2006-01-02"),
+		sr.to.AddDate(0, 0, -1).Format("2006-01-02"), sr.group)
+	out := Stats{From: sr.from.Format("2006-01-02"), To: sr.to.AddDate(0, 0, -1).Format("2006-01-02"),
+		Group: sr.group, Periods: sr.periods()}
+
+	out.Accessions, err = sr.series(svc.DB, out.Periods, fmt.Sprintf(`select %s as period, count(*) as value
+		from accessions a where a.created_at >= {:from} and a.created_at < {:to} group by period`,
+		sr.grouping.periodQS("a.created_at")))
+	if err == nil {
+		out.DigitalGB, err = sr.series(svc.DB, out.Periods, fmt.Sprintf(`select %s as period,
+			coalesce(sum(da.upload_size), 0) / 1000000000 as value
+			from accessions a inner join digital_accessions da on da.accession_id = a.id
+			where a.created_at >= {:from} and a.created_at < {:to} group by period`,
+			sr.grouping.periodQS("a.created_at")))
+	}
+	if err == nil {
+		// boxes are received when checked in, or when the transfer was received if
+		// the box was never checked individually
+		received := "coalesce(ii.checked_at, pa.received_at)"
+		out.PhysicalBoxes, err = sr.series(svc.DB, out.Periods, fmt.Sprintf(`select %s as period, count(*) as value
+			from inventory_items ii inner join physical_accessions pa on pa.id = ii.physical_accession_id
+			where ii.status in ("received", "damaged", "extra")
+				and %s >= {:from} and %s < {:to} group by period`,
+			sr.grouping.periodQS(received), received, received))
+	}
+	if err == nil {
+		for idx := range out.DigitalGB {
+			out.DigitalGB[idx] = roundStat(out.DigitalGB[idx])
+		}
+		out.Genres, err = sr.facetCounts(svc.DB, "genre", 0)
+	}
+	if err == nil {
+		out.RecordTypes, err = sr.facetCounts(svc.DB, "recordType", 0)
+	}
+	if err == nil {
+		out.TopUnits, err = sr.facetCounts(svc.DB, "unit", sr.top)
+	}
+	if err == nil {
+		out.StatusTimes, err = sr.statusTimes(svc.DB)
+	}
+	if err != nil {
+		log.Printf("ERROR: Unable to get statistics: %s", err.Error())
+		c.String(http.StatusInternalServerError, err.Error())
+		return
+	}
+	c.JSON(http.StatusOK, out)
+}
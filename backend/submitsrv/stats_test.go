@@ -0,0 +1,173 @@
+package main
+
+import (
+	"net/http/httptest"
+	"net/url"
+	"strings"
+	"testing"
+	"time"
+
+	"github.com/gin-gonic/gin"
+)
+
+// testStatsRequest parses the params of a statistics request with the given query
+func testStatsRequest(query url.Values) (*statsRequest, error) {
+	gin.SetMode(gin.TestMode)
+	c, _ := gin.CreateTestContext(httptest.NewRecorder())
+	c.Request = httptest.NewRequest("GET", "/api/admin/stats?"+query.Encode(), nil)
+	return parseStatsRequest(c)
+}
+
+func TestParseStatsRequest(t *testing.T) {
+	day := func(s string) time.Time {
+		d, _ := time.ParseInLocation("2006-01-02", s, time.Local)
+		return d
+	}
+	tests := []struct {
+		name      string
+		query     url.Values
+		wantFrom  string
+		wantTo    string
+		wantGroup string
+		wantTop   int
+	}{
+		{"range", url.Values{"from": {"2026-01-01"}, "to": {"2026-03-31"}}, "2026-01-01", "2026-04-01", "month", 10},
+		{"to only", url.Values{"to": {"2026-03-31"}}, "2025-04-01", "2026-04-01", "month", 10},
+		{"single day", url.Values{"from": {"2026-03-01"}, "to": {"2026-03-01"}}, "2026-03-01", "2026-03-02", "month", 10},
+		{"leap day", url.Values{"to": {"2024-02-29"}}, "2023-03-01", "2024-03-01", "month", 10},
+		{"week", url.Values{"from": {"2026-01-01"}, "to": {"2026-01-31"}, "group": {"week"}}, "2026-01-01", "2026-02-01", "week", 10},
+		{"quarter and top", url.Values{"from": {"2025-01-01"}, "to": {"2025-12-31"}, "group": {"quarter"}, "top": {"5"}},
+			"2025-01-01", "2026-01-01", "quarter", 5},
+		{"year", url.Values{"from": {"2020-01-01"}, "to": {"2025-12-31"}, "group": {" year "}}, "2020-01-01", "2026-01-01", "year", 10},
+	}
+	for _, tt := range tests {
+		sr, err := testStatsRequest(tt.query)
+		if err != nil {
+			t.Errorf("%s: unexpected error: %s", tt.name, err.Error())
+			continue
+		}
+		if sr.from.Equal(day(tt.wantFrom)) == false || sr.to.Equal(day(tt.wantTo)) == false {
+			t.Errorf("%s: range %s to %s, want %s to %s", tt.name, sr.from.Format("2006-01-02"),
+				sr.to.Format("2006-01-02"), tt.wantFrom, tt.wantTo)
+		}
+		if sr.group != tt.wantGroup || sr.top != tt.wantTop {
+			t.Errorf("%s: group %s top %d, want group %s top %d", tt.name, sr.group, sr.top, tt.wantGroup, tt.wantTop)
+		}
+	}
+
+	// the default range is the twelve months up to and including today
+	sr, err := testStatsRequest(url.Values{})
+	if err != nil {
+		t.Fatalf("default: unexpected error: %s", err.Error())
+	}
+	now := time.Now()
+	tomorrow := time.Date(now.Year(), now.Month(), now.Day(), 0, 0, 0, 0, time.Local).AddDate(0, 0, 1)
+	if sr.to.Equal(tomorrow) == false || sr.from.Equal(tomorrow.AddDate(0, -12, 0)) == false {
+		t.Errorf("default: range %s to %s, want twelve months to %s", sr.from, sr.to, tomorrow)
+	}
+	if sr.group != "month" || sr.top != 10 {
+		t.Errorf("default: group %s top %d, want month and 10", sr.group, sr.top)
+	}
+}
+
+func TestBadStatsRequest(t *testing.T) {
+	tests := []struct {
+		name  string
+		query url.Values
+	}{
+		{"bad from", url.Values{"from": {"03/01/2026"}}},
+		{"bad to", url.Values{"to": {"2026-02-30"}}},
+		{"from after to", url.Values{"from": {"2026-03-02"}, "to": {"2026-03-01"}}},
+		{"unknown group", url.Values{"group": {"day"}}},
+		{"zero top", url.Values{"top": {"0"}}},
+		{"negative top", url.Values{"top": {"-3"}}},
+		{"top not a number", url.Values{"top": {"ten"}}},
+	}
+	for _, tt := range tests {
+		sr, err := testStatsRequest(tt.query)
+		if err == nil {
+			t.Errorf("%s: parsed as %+v, want error", tt.name, sr)
+		}
+	}
+}
+
+// The labels must match the SQL of periodQS: date_format %x-W%v (ISO year and week),
+// %Y-%m, year()-Q quarter() and %Y. The expected values are the ones MySQL returns
+func TestStatsPeriodLabels(t *testing.T) {
+	tests := []struct {
+		group string
+		date  string
+		want  string
+	}{
+		{"week", "2026-03-04", "2026-W10"},
+		{"week", "2026-01-01", "2026-W01"},
+		{"week", "2026-12-31", "2026-W53"},
+		{"week", "2027-01-03", "2026-W53"},
+		{"week", "2027-01-04", "2027-W01"},
+		{"week", "2020-12-31", "2020-W53"},
+		{"week", "2021-01-01", "2020-W53"},
+		{"week", "2021-01-03", "2020-W53"},
+		{"week", "2021-01-04", "2021-W01"},
+		{"week", "2019-12-30", "2020-W01"},
+		{"week", "2018-12-31", "2019-W01"},
+		{"week", "2016-01-03", "2015-W53"},
+		{"week", "2025-12-29", "2026-W01"},
+		{"month", "2026-01-01", "2026-01"},
+		{"month", "2026-12-31", "2026-12"},
+		{"month", "2024-02-29", "2024-02"},
+		{"quarter", "2026-01-01", "2026-Q1"},
+		{"quarter", "2026-03-31", "2026-Q1"},
+		{"quarter", "2026-04-01", "2026-Q2"},
+		{"quarter", "2026-09-30", "2026-Q3"},
+		{"quarter", "2026-12-31", "2026-Q4"},
+		{"year", "2026-12-31", "2026"},
+		{"year", "2027-01-01", "2027"},
+	}
+	for _, tt := range tests {
+		date, _ := time.ParseInLocation("2006-01-02", tt.date, time.Local)
+		grouping := statsGroupings[tt.group]
+		if got := grouping.label(date); got != tt.want {
+			t.Errorf("%s label of %s = %s, want %s", tt.group, tt.date, got, tt.want)
+		}
+		// the start of the period containing the date has the same label
+		if got := grouping.label(grouping.start(date)); got != tt.want {
+			t.Errorf("%s label of start of %s = %s, want %s", tt.group, tt.date, got, tt.want)
+		}
+	}
+}
+
+func TestStatsPeriods(t *testing.T) {
+	tests := []struct {
+		name  string
+		query url.Values
+		want  []string
+	}{
+		{"weeks across year with week 53", url.Values{"from": {"2020-12-20"}, "to": {"2021-01-10"}, "group": {"week"}},
+			[]string{"2020-W51", "2020-W52", "2020-W53", "2021-W01"}},
+		{"weeks into ISO year before calendar year", url.Values{"from": {"2019-12-23"}, "to": {"2020-01-06"}, "group": {"week"}},
+			[]string{"2019-W52", "2020-W01", "2020-W02"}},
+		{"single week", url.Values{"from": {"2026-03-04"}, "to": {"2026-03-04"}, "group": {"week"}},
+			[]string{"2026-W10"}},
+		{"months from end of month", url.Values{"from": {"2026-01-31"}, "to": {"2026-03-01"}},
+			[]string{"2026-01", "2026-02", "2026-03"}},
+		{"months across year", url.Values{"from": {"2025-11-15"}, "to": {"2026-01-15"}},
+			[]string{"2025-11", "2025-12", "2026-01"}},
+		{"quarters", url.Values{"from": {"2025-11-15"}, "to": {"2026-04-01"}, "group": {"quarter"}},
+			[]string{"2025-Q4", "2026-Q1", "2026-Q2"}},
+		{"quarter ends on last day", url.Values{"from": {"2026-01-01"}, "to": {"2026-03-31"}, "group": {"quarter"}},
+			[]string{"2026-Q1"}},
+		{"years", url.Values{"from": {"2025-06-01"}, "to": {"2026-01-01"}, "group": {"year"}},
+			[]string{"2025", "2026"}},
+	}
+	for _, tt := range tests {
+		sr, err := testStatsRequest(tt.query)
+		if err != nil {
+			t.Errorf("%s: unexpected error: %s", tt.name, err.Error())
+			continue
+		}
+		got := sr.periods()
+		if strings.Join(got, "|") != strings.Join(tt.want, "|") {
+			t.Errorf("%s: periods %v, want %v", tt.name, got, tt.want)
+		}
+	}
+}
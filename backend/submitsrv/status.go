@@ -0,0 +1,135 @@
+package main
+
+import (
+	"fmt"
+	"log"
+	"net/http"
+	"time"
+
+	"github.com/gin-gonic/gin"
+	dbx "github.com/go-ozzo/ozzo-dbx"
+)
+
+// accessionStatuses lists the processing statuses of an accession in workflow order
+var accessionStatuses = []string{"submitted", "acknowledged", "received", "processing", "completed"}
+
+// IsAccessionStatus returns true if the status is a supported accession status
+func IsAccessionStatus(status string) bool {
+	return statusRank(status) >= 0
+}
+
+// statusRank returns the position of a status in the workflow, or -1 if it is unknown
+func statusRank(status string) int {
+	for idx, s := range accessionStatuses {
+		if s == status {
+			return idx
+		}
+	}
+	return -1
+}
+
+// StatusChange is one entry in the status history of an accession
+type StatusChange struct {
+	ID          int       `json:"-" db:"id"`
+	AccessionID int       `json:"-" db:"accession_id"`
+	Status      string    `json:"status" db:"status"`
+	UserID      *int      `json:"userID" db:"user_id"`
+	UserName    string    `json:"userName" db:"user_name"`
+	CreatedAt   time.Time `json:"createdAt" db:"created_at"`
+}
+
+// TableName defines the expected DB table name that holds data for status changes
+func (sc *StatusChange) TableName() string {
+	return "accession_status_history"
+}
+
+// writeStatusHistory adds an entry to the status history of an accession
+func writeStatusHistory(db dbx.Builder, accessionID int, status string, user *User) error {
+	var userID *int
+	if user != nil {
+		userID = &user.ID
+	}
+	_, err := db.Insert("accession_status_history", dbx.Params{
+		"accession_id": accessionID,
+		"status":       status,
+		"user_id":      userID,
+		"created_at":   time.Now(),
+	}).Execute()
+	return err
+}
+
+// SetStatus changes the status of an accession, recording it in the status history and
+// activity log, and moves its deadline. A nil user is a change made by the system
+func (a *Accession) SetStatus(db dbx.Builder, status string, user *User) error {
+	if status == a.Status {
+		return nil
+	}
+	_, err := db.Update("accessions", dbx.Params{"status": status}, dbx.HashExp{"id": a.ID}).Execute()
+	if err != nil {
+		return err
+	}
+	err = writeStatusHistory(db, a.ID, status, user)
+	if err != nil {
+		return err
+	}
+	LogEvent(db, a.ID, user, "status", fmt.Sprintf("Status changed from %s to %s", a.Status, status))
+	a.Status = status
+	return a.UpdateDeadline(db)
+}
+
+// AdvanceStatus moves an accession forward to a status as a side effect of other work,
+// such as receiving boxes. Accessions already at or past the status are unchanged
+func (a *Accession) AdvanceStatus(db dbx.Builder, status string, user *User) error {
+	if statusRank(a.Status) >= statusRank(status) {
+		return nil
+	}
+	return a.SetStatus(db, status, user)
+}
+
+// GetStatusHistory returns the status changes of an accession, oldest first
+func (a *Accession) GetStatusHistory(db *dbx.DB) []StatusChange {
+	q := db.NewQuery(`select h.*, coalesce(concat(u.first_name,' ',u.last_name), "") as user_name
+		from accession_status_history h left outer join users u on u.id = h.user_id
+		where h.accession_id={:id} order by h.created_at asc, h.id asc`)
+	q.Bind(dbx.Params{"id": a.ID})
+	out := make([]StatusChange, 0)
+	err := q.All(&out)
+	if err != nil {
+		log.Printf("ERROR: Unable to get status history for accession %d: %s", a.ID, err.Error())
+	}
+	return out
+}
+
+// UpdateAccessionStatus is an admin API call that sets the status of an accession
+func (svc *ServiceContext) UpdateAccessionStatus(c *gin.Context) {
+	accessionID := c.Param("id")
+	var req struct {
+		Status string `json:"status" binding:"required"`
+	}
+	err := c.ShouldBindJSON(&req)
+	if err != nil {
+		c.String(http.StatusBadRequest, err.Error())
+		return
+	}
+	if IsAccessionStatus(req.Status) == false {
+		c.String(http.StatusBadRequest, "invalid status %s", req.Status)
+		return
+	}
+	accession, err := LoadAccession(svc.DB, accessionID)
+	if err != nil {
+		c.String(http.StatusNotFound, "accession %s not found", accessionID)
+		return
+	}
+	staff := GetAuthUser(c)
+	tx, _ := svc.DB.Begin()
+	err = accession.SetStatus(tx, req.Status, staff)
+	if err != nil {
+		log.Printf("ERROR: Unable to set status of accession %d: %s", accession.ID, err.Error())
+		tx.Rollback()
+		c.String(http.StatusInternalServerError, err.Error())
+		return
+	}
+	tx.Commit()
+	log.Printf("%s set status of accession %d to %s", staff.Email, accession.ID, req.Status)
+	c.JSON(http.StatusOK, accession.GetStatusHistory(svc.DB))
+}
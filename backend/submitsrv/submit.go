@@ -1,18 +1,19 @@
 package main
 
 import (
+	"database/sql"
 	"fmt"
 	"log"
 	"net/http"
-	"os"
 	"time"
 
 	"github.com/gin-gonic/gin"
+	dbx "github.com/go-ozzo/ozzo-dbx"
 	"github.com/rs/xid"
 )
 
-// Submit accepts a transfer submission, creates a DB record and kicks off submission
-// processing. When complete, an email is sent to the submitter
+// Submit accepts a transfer submission, creates a DB record and queues the submission
+// processing jobs. One of these jobs sends a receipt email to the submitter
 func (svc *ServiceContext) Submit(c *gin.Context) {
 	var accession Accession
 	err := c.ShouldBindJSON(&accession)
@@ -23,27 +24,121 @@ func (svc *ServiceContext) Submit(c *gin.Context) {
 	}
 	log.Printf("Received: %+v", accession)
 
-	log.Printf("Update existing user %d:%s", accession.User.ID, accession.User.Email)
-	accession.User.UpdatedAt = time.Now()
-	accession.User.FormatPhone()
-	err = svc.DB.Model(&accession.User).Exclude("Verified", "VerifyToken", "Admin", "CreatedAt", "email").Update()
+	// The upload identifier is requested once per form, so it doubles as an idempotency
+	// key. A browser retry of the same request gets the original result back.
+	accession.RequestHash = accession.GenerateRequestHash()
+	if svc.handlePriorSubmission(c, &accession) {
+		return
+	}
+
+	// submitting does not require a session, but linking earlier transfers does
+	submitter, _ := svc.sessionUser(c, submitterSessionCookie)
+
+	log.Printf("Validate accession %s", accession.Identifier)
+	verrs, err := accession.Validate(svc.DB, svc.UploadDir, submitter)
 	if err != nil {
-		log.Printf("WARN: Unable to update %s - %s", accession.User.Email, err.Error())
+		log.Printf("ERROR: Unable to validate accession: %s", err.Error())
+		c.String(http.StatusInternalServerError, "Unable to validate submission")
+		return
+	}
+	if verrs.HasErrors() {
+		c.JSON(http.StatusBadRequest, verrs)
+		return
+	}
+
+	// acceptance of the agreement is recorded against a verified user, never the
+	// user details in the request body
+	acceptedBy, err := GetAgreementUser(svc.DB, submitter, accession.User.Email)
+	if err != nil {
+		log.Printf("ERROR: Unable to find user accepting agreement: %s", err.Error())
+		c.String(http.StatusInternalServerError, "Unable to validate submission")
+		return
+	}
+	if accession.AgreementAccepted && accession.AgreementID != 0 && acceptedBy == nil {
+		verrs.Add("agreementAccepted", "Verify your email address before accepting the transfer agreement")
+		c.JSON(http.StatusBadRequest, verrs)
+		return
+	}
+
+	err = accession.ResolveDefaults(svc.DB)
+	if err != nil {
+		log.Printf("ERROR: Unable to resolve accession details: %s", err.Error())
+		c.String(http.StatusInternalServerError, "Unable to validate submission")
+		return
 	}
 
 	log.Printf("Add new accession record")
 	tx, _ := svc.DB.Begin()
 	accession.UserID = accession.User.ID
 	accession.CreatedAt = time.Now()
+	accession.Status = "submitted"
+	accession.AccessToken = newToken()
+	accession.DispositionDate = accession.CalculateDispositionDate(accession.RetentionSchedule)
+	err = accession.AssignAccessionNumber(tx, svc.AccessionNumberFormat)
+	if err != nil {
+		log.Printf("ERROR: Unable to assign accession number: %s", err.Error())
+		tx.Rollback()
+		c.String(http.StatusInternalServerError, "Unable to assign accession number")
+		return
+	}
+	err = accession.ResolveOwner(tx)
+	if err != nil {
+		log.Printf("ERROR: Unable to resolve records owner: %s", err.Error())
+		tx.Rollback()
+		c.String(http.StatusInternalServerError, "Unable to record the records owner")
+		return
+	}
 	err = tx.Model(&accession).Insert()
 	if err != nil {
 		log.Printf("ERROR: Unable to add accession %s", err.Error())
 		tx.Rollback()
+		// a concurrent retry may have won the race to insert this identifier
+		if svc.handlePriorSubmission(c, &accession) {
+			return
+		}
+		c.String(http.StatusInternalServerError, "Unable to create accession record")
+		return
+	}
+
+	err = writeStatusHistory(tx, accession.ID, accession.Status, nil)
+	if err != nil {
+		log.Printf("ERROR: Unable to write status history: %s", err.Error())
+		tx.Rollback()
 		c.String(http.StatusInternalServerError, "Unable to create accession record")
 		return
 	}
 
+	// User updates are part of the transaction so a failed submission leaves no changes behind
+	log.Printf("Update existing user %d:%s", accession.User.ID, accession.User.Email)
+	accession.User.UpdatedAt = time.Now()
+	accession.User.FormatPhone()
+	err = tx.Model(&accession.User).Exclude("Verified", "VerifyToken", "Admin", "APIToken", "SubmitToken", "CreatedAt", "email").Update()
+	if err != nil {
+		log.Printf("WARN: Unable to update %s - %s", accession.User.Email, err.Error())
+	}
+
 	accession.WriteGenres(tx)
+	err = accession.WriteAgreement(tx, acceptedBy, c.ClientIP())
+	if err != nil {
+		log.Printf("ERROR: Unable to record agreement acceptance: %s", err.Error())
+		tx.Rollback()
+		c.String(http.StatusInternalServerError, "Unable to record transfer agreement")
+		return
+	}
+	err = accession.WriteRestriction(tx)
+	if err != nil {
+		log.Printf("ERROR: Unable to record restriction: %s", err.Error())
+		tx.Rollback()
+		c.String(http.StatusInternalServerError, "Unable to record access restriction")
+		return
+	}
+	err = accession.WriteLinks(tx)
+	if err != nil {
+		log.Printf("ERROR: Unable to write accession links: %s", err.Error())
+		tx.Rollback()
+		c.String(http.StatusInternalServerError, "Unable to link related accessions")
+		return
+	}
 	if accession.PhysicalTransfer {
 		perr := accession.WritePhysicalTransfer(tx)
 		if perr != nil {
@@ -61,27 +156,98 @@ func (svc *ServiceContext) Submit(c *gin.Context) {
 			c.String(http.StatusInternalServerError, "Unable to create digital transfer record")
 			return
 		}
+	}
+
+	// File promotion and the receipt email run as retryable jobs. Queue them as part
+	// of the transaction so an accession is never committed without its processing
+	jobs := []string{"notify", "index_new"}
+	if accession.DigitalTransfer {
+		jobs = append([]string{"promote"}, jobs...)
+	}
+	if accession.Owner != nil {
+		jobs = append(jobs, "owner_notify")
+	}
+	for _, jobType := range jobs {
+		err = EnqueueJob(tx, accession.ID, jobType)
+		if err != nil {
+			log.Printf("ERROR: Unable to queue %s job: %s", jobType, err.Error())
+			tx.Rollback()
+			c.String(http.StatusInternalServerError, "Unable to queue submission processing")
+			return
+		}
+	}
+	err = accession.UpdateDeadline(tx)
+	if err != nil {
+		log.Printf("ERROR: Unable to set accession deadline: %s", err.Error())
+		tx.Rollback()
+		c.String(http.StatusInternalServerError, "Unable to queue submission processing")
+		return
+	}
+	LogEvent(tx, accession.ID, nil, "submitted", fmt.Sprintf("Submitted by %s", accession.User.FullName()))
+	err = tx.Commit()
+	if err != nil {
+		log.Printf("ERROR: Unable to commit accession %s: %s", accession.Identifier, err.Error())
+		c.String(http.StatusInternalServerError, "Unable to create accession record")
+		return
+	}
+
+	c.JSON(http.StatusOK, newSubmitResult(&accession))
+}
+
+// SubmitResult is the response to a successful submission. The receipt and box label
+// URLs carry the accession access token so the submitter can download them without
+// signing in. There are no box labels for a transfer without physical records
+type SubmitResult struct {
+	AccessionNumber string `json:"accessionNumber"`
+	ReceiptURL      string `json:"receiptURL"`
+	LabelsURL       string `json:"labelsURL,omitempty"`
+}
+
+// newSubmitResult builds the submission response for an accession
+func newSubmitResult(accession *Accession) SubmitResult {
+	out := SubmitResult{AccessionNumber: accession.AccessionNumber,
+		ReceiptURL: fmt.Sprintf("/api/receipt/%s", accession.AccessToken)}
+	if accession.PhysicalTransfer {
+		out.LabelsURL = fmt.Sprintf("/api/labels/%s", accession.AccessToken)
+	}
+	return out
+}
+
+// handlePriorSubmission checks for an accession that has already been submitted with the
+// same identifier. An identical request gets the original result, and a different one is a
+// conflict. Returns true if a response has been sent.
+func (svc *ServiceContext) handlePriorSubmission(c *gin.Context, accession *Accession) bool {
+	var prior struct {
+		ID          int    `db:"id"`
+		RequestHash string `db:"request_hash"`
+	}
+	q := svc.DB.NewQuery("select id, request_hash from accessions where identifier={:id}")
+	q.Bind(dbx.Params{"id": accession.Identifier})
+	err := q.One(&prior)
+	if err != nil {
+		if err != sql.ErrNoRows {
+			log.Printf("ERROR: Unable to check for prior submission %s: %s", accession.Identifier, err.Error())
+			c.String(http.StatusInternalServerError, "Unable to check submission status")
+			return true
+		}
+		return false
+	}
 
-		// Submitted dir gets broken up by YYYY/MM before the submissionID. Make the tree:
-		currTime := time.Now()
-		xferDir := fmt.Sprintf("%s/%s/%s", svc.UploadDir, "transferred", currTime.Format("2006/01"))
-		os.MkdirAll(xferDir, 0777)
-
-		// Move pending into transfer tree
-		pendingDir := fmt.Sprintf("%s/%s", svc.UploadDir, "pending")
-		uploadDir := fmt.Sprintf("%s/%s", pendingDir, accession.Identifier)
-		tgtDir := fmt.Sprintf("%s/%s", xferDir, accession.Identifier)
-		log.Printf("Moving pending upload files from %s to %s", uploadDir, tgtDir)
-		err = os.Rename(uploadDir, tgtDir)
+	if prior.RequestHash == accession.RequestHash {
+		log.Printf("Submission %s is a repeat of accession %d; returning original result", accession.Identifier, prior.ID)
+		original, err := LoadAccession(svc.DB, prior.ID)
 		if err != nil {
-			log.Printf("WARN: Unable to move pending files to submitted: %s", err.Error())
+			log.Printf("ERROR: Unable to load prior accession %d: %s", prior.ID, err.Error())
+			c.String(http.StatusInternalServerError, "Unable to check submission status")
+			return true
 		}
+		c.JSON(http.StatusOK, newSubmitResult(original))
+		return true
 	}
-	tx.Commit()
 
-	// Now send recepit to submitter and admins
-	accession.User.SendReceiptEmail(svc.DB, svc.SMTP, accession)
-	c.String(http.StatusOK, "accepted")
+	log.Printf("ERROR: Submission %s conflicts with existing accession %d", accession.Identifier, prior.ID)
+	c.String(http.StatusConflict, "A different submission has already been made with identifier %s", accession.Identifier)
+	return true
 }
 
 // GetAccessionIdentifier will generate an unique token to identify digital content uploads
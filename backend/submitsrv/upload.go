@@ -10,8 +10,31 @@ import (
 
 	"github.com/gin-gonic/gin"
 	_ "github.com/go-sql-driver/mysql"
+	"github.com/rs/xid"
 )
 
+// pendingUploadDir returns the pending directory for the uploads of a submission. The
+// identifier comes from the client, so it must be one handed out by GetAccessionIdentifier
+func (svc *ServiceContext) pendingUploadDir(uploadID string) (string, error) {
+	if _, err := xid.FromString(uploadID); err != nil {
+		return "", fmt.Errorf("invalid upload identifier %s", uploadID)
+	}
+	return filepath.Join(svc.UploadDir, "pending", uploadID), nil
+}
+
+// pendingUploadPath returns the path of an uploaded file in the pending directory of a
+// submission. The file name must be a plain name so the path stays inside that directory
+func (svc *ServiceContext) pendingUploadPath(uploadID string, filename string) (string, error) {
+	dir, err := svc.pendingUploadDir(uploadID)
+	if err != nil {
+		return "", err
+	}
+	if filename == "" || filename == "." || filename == ".." || filename != filepath.Base(filename) {
+		return "", fmt.Errorf("invalid file name %s", filename)
+	}
+	return filepath.Join(dir, filename), nil
+}
+
 // UploadFile handles raw file uploads from the front end
 func (svc *ServiceContext) UploadFile(c *gin.Context) {
 	log.Printf("Checking for upload identifier...")
@@ -26,8 +49,12 @@ func (svc *ServiceContext) UploadFile(c *gin.Context) {
 	// at that point, they will be moved to a final transfer directory. all files
 	// in pending can me considered temporary and be purged.
 	log.Printf("Identifier %s received. Create upload directory.", uploadID)
-	pendingDir := fmt.Sprintf("%s/%s", svc.UploadDir, "pending")
-	uploadDir := fmt.Sprintf("%s/%s", pendingDir, uploadID)
+	uploadDir, err := svc.pendingUploadDir(uploadID)
+	if err != nil {
+		log.Printf("ERROR: %s", err.Error())
+		c.String(http.StatusBadRequest, err.Error())
+		return
+	}
 	os.MkdirAll(uploadDir, 0777)
 
 	// when chunking is being used, there will be additional form params:
@@ -41,8 +68,11 @@ func (svc *ServiceContext) UploadFile(c *gin.Context) {
 			c.String(http.StatusBadRequest, fmt.Sprintf("Unable to get form file: %s", err.Error()))
 			return
 		}
-		filename := header.Filename
-		dest := fmt.Sprintf("%s/%s", uploadDir, filename)
+		dest, err := svc.pendingUploadPath(uploadID, filepath.Base(header.Filename))
+		if err != nil {
+			c.String(http.StatusBadRequest, err.Error())
+			return
+		}
 		log.Printf("Received CHUNKED request to upload %s, chunk %s size %s", dest, chunkIdx, c.PostForm("dzchunksize"))
 		if chunkIdx == "0" {
 			if _, err := os.Stat(dest); err == nil {
@@ -65,7 +95,11 @@ func (svc *ServiceContext) UploadFile(c *gin.Context) {
 			return
 		}
 		filename := filepath.Base(file.Filename)
-		dest := fmt.Sprintf("%s/%s", uploadDir, filename)
+		dest, err := svc.pendingUploadPath(uploadID, filename)
+		if err != nil {
+			c.String(http.StatusBadRequest, err.Error())
+			return
+		}
 		if _, err := os.Stat(dest); err == nil {
 			log.Printf("WARN: File %s already exists; removing", dest)
 			os.Remove(dest)
@@ -85,7 +119,12 @@ func (svc *ServiceContext) UploadFile(c *gin.Context) {
 func (svc *ServiceContext) DeleteUploadedFile(c *gin.Context) {
 	tgtFile := c.Param("file")
 	uploadID := c.Query("key")
-	tgt := fmt.Sprintf("%s/%s/%s/%s", svc.UploadDir, "pending", uploadID, tgtFile)
+	tgt, err := svc.pendingUploadPath(uploadID, tgtFile)
+	if err != nil {
+		log.Printf("ERROR: Rejected request to delete %s from %s: %s", tgtFile, uploadID, err.Error())
+		c.String(http.StatusBadRequest, err.Error())
+		return
+	}
 	log.Printf("Request to delete %s", tgt)
 	if _, err := os.Stat(tgt); err == nil {
 		delErr := os.Remove(tgt)
@@ -96,7 +135,7 @@ func (svc *ServiceContext) DeleteUploadedFile(c *gin.Context) {
 		}
 	} else {
 		log.Printf("WARN: Target file %s does not exist", tgt)
-		c.String(http.StatusNotFound, "% not found", tgtFile)
+		c.String(http.StatusNotFound, "%s not found", tgtFile)
 		return
 	}
 	log.Printf("Deleted %s", tgt)
@@ -0,0 +1,42 @@
+package main
+
+import (
+	"testing"
+
+	"github.com/rs/xid"
+)
+
+func TestPendingUploadPath(t *testing.T) {
+	svc := ServiceContext{UploadDir: "/data/uploads"}
+	uploadID := xid.New().String()
+	tests := []struct {
+		name     string
+		uploadID string
+		filename string
+		want     string
+	}{
+		{"plain file", uploadID, "minutes.pdf", "/data/uploads/pending/" + uploadID + "/minutes.pdf"},
+		{"file with spaces", uploadID, "board minutes 1962.pdf", "/data/uploads/pending/" + uploadID + "/board minutes 1962.pdf"},
+		{"parent key", "..", "minutes.pdf", ""},
+		{"traversal key", "../attachments/12", "1-letter.pdf", ""},
+		{"traversal key to receipts", "../receipts/2026", "receipt.pdf", ""},
+		{"empty key", "", "minutes.pdf", ""},
+		{"traversal file", uploadID, "../../receipts/receipt.pdf", ""},
+		{"nested file", uploadID, "sub/minutes.pdf", ""},
+		{"parent file", uploadID, "..", ""},
+		{"current file", uploadID, ".", ""},
+		{"empty file", uploadID, "", ""},
+	}
+	for _, tt := range tests {
+		got, err := svc.pendingUploadPath(tt.uploadID, tt.filename)
+		if tt.want == "" {
+			if err == nil {
+				t.Errorf("%s: pendingUploadPath(%q, %q) = %s, want error", tt.name, tt.uploadID, tt.filename, got)
+			}
+			continue
+		}
+		if err != nil || got != tt.want {
+			t.Errorf("%s: pendingUploadPath(%q, %q) = %s, %v, want %s", tt.name, tt.uploadID, tt.filename, got, err, tt.want)
+		}
+	}
+}
@@ -1,12 +1,9 @@
 package main
 
 import (
-	"bytes"
 	"fmt"
-	"html/template"
 	"log"
 	"net/http"
-	"net/smtp"
 	"strings"
 	"time"
 
@@ -23,12 +20,14 @@ type User struct {
 	LastName    string    `json:"lastName" binding:"required" db:"last_name"`
 	Title       string    `json:"title" binding:"required" form:"title"`
 	Affiliation string    `json:"affiliation"  binding:"required" db:"university_affiliation"`
+	UnitID      *int      `json:"unitID" form:"unitID" db:"unit_id"`
 	Email       string    `json:"email" binding:"required" form:"email"`
 	Phone       string    `json:"phone" binding:"required" form:"phone"`
 	Verified    bool      `json:"verified"`
-	VerifyToken *string   `json:"token"  db:"verify_token"`
+	VerifyToken *string   `json:"-"  db:"verify_token"`
 	Admin       bool      `json:"admin"`
 	APIToken    string    `json:"-"  db:"api_token" `
+	SubmitToken string    `json:"-"  db:"submit_token"`
 	CreatedAt   time.Time `db:"created_at" json:"-"`
 	UpdatedAt   time.Time `db:"updated_at" json:"-"`
 }
@@ -57,20 +56,14 @@ func (user *User) FullName() string {
 	return fmt.Sprintf("%s %s", user.FirstName, user.LastName)
 }
 
-// SendReceiptEmail will send the user (and admins) a transfer receipt email
-func (user *User) SendReceiptEmail(db *dbx.DB, smtpCfg SMTPConfig, accession Accession) {
-	to := []string{user.Email}
-	q := db.NewQuery(`select email from users where admin=1`)
-	rows, _ := q.Rows()
-	for rows.Next() {
-		var email string
-		rows.Scan(&email)
-		to = append(to, email)
-	}
-	log.Printf("Send Receipt email recipients: %s", strings.Join(to, ","))
-
+// SendReceiptEmail will send the user a transfer receipt email with the PDF receipt
+// attached. The user is either the submitter, whose copy also goes to admins, or the
+// records owner of a delegated submission. The accession is expected to have been read
+// with LoadAccession so that vocabulary names are present
+func (user *User) SendReceiptEmail(db *dbx.DB, smtpCfg SMTPConfig, baseURL string, accession *Accession, receiptPDF []byte) error {
 	type Data struct {
 		*Accession
+		Recipient              *User
 		Genres                 string
 		DigitalRecordTypes     string
 		PhysicalRecordTypes    string
@@ -78,91 +71,63 @@ func (user *User) SendReceiptEmail(db *dbx.DB, smtpCfg SMTPConfig, accession Acc
 		DigitalFiles           string
 		PhysicalTransferMethod string
 		MediaCarriers          string
+		ReceiptURL             string
+		LabelsURL              string
+		ViewURL                string
 	}
 
-	data := Data{Accession: &accession}
-	data.Genres = GetVocabNamesCSV(db, "genres", accession.Genres)
+	data := Data{Accession: accession, Recipient: user,
+		ReceiptURL: fmt.Sprintf("https://%s/api/receipt/%s", baseURL, accession.AccessToken)}
+	data.Genres = strings.Join(accession.Genres, ", ")
 	if accession.DigitalTransfer {
-		data.DigitalRecordTypes = GetVocabNamesCSV(db, "record_types", accession.Digital.RecordTypes)
-		sizeGB := float32(accession.Digital.TotalSize) / 1000.0 / 1000.0
-		data.DigitalSizeGB = fmt.Sprintf("%.2fGB", sizeGB)
+		data.DigitalRecordTypes = strings.Join(accession.Digital.RecordTypes, ", ")
+		sizeGB := float32(accession.Digital.TotalSize) / 1000.0 / 1000.0 / 1000.0
+		data.DigitalSizeGB = fmt.Sprintf("%.2f", sizeGB)
 		data.DigitalFiles = strings.Join(accession.Digital.Files, ", ")
 	}
 	if accession.PhysicalTransfer {
-		data.PhysicalRecordTypes = GetVocabNamesCSV(db, "record_types", accession.Physical.RecordTypes)
-		data.PhysicalTransferMethod = GetVocabName(db, "transfer_methods", data.Physical.TransferMethodID)
-		data.MediaCarriers = GetVocabNamesCSV(db, "media_carriers", accession.Physical.MediaCarriers)
+		data.PhysicalRecordTypes = strings.Join(accession.Physical.RecordTypes, ", ")
+		data.PhysicalTransferMethod = accession.Physical.TransferMethod
+		data.MediaCarriers = strings.Join(accession.Physical.MediaCarriers, ", ")
+		data.LabelsURL = fmt.Sprintf("https://%s/api/labels/%s", baseURL, accession.AccessToken)
+	}
+	if user.ID != accession.User.ID && user.Verified {
+		data.ViewURL = ownerViewURL(baseURL, user)
 	}
 
-	log.Printf("Rendering receipt email body")
-	var renderedEmail bytes.Buffer
-	tpl := template.Must(template.ParseFiles("templates/receipt_email.html"))
-	err := tpl.Execute(&renderedEmail, data)
+	body, err := RenderEmailTemplate("receipt_email.html", data)
 	if err != nil {
 		log.Printf("ERROR: Unable to render receipt email: %s", err.Error())
-		return
+		return err
 	}
 
-	log.Printf("Generate SMTP message")
-	mime := "MIME-version: 1.0;\nContent-Type: text/html; charset=\"UTF-8\";\n\n"
-	subject := "Subject: UVA Archives Transfer Receipt\n"
-	toHdr := fmt.Sprintf("To: %s\n", user.Email)
-	msg := []byte(subject + toHdr + mime + renderedEmail.String())
-
-	if smtpCfg.DevMode {
-		log.Printf("Email is in dev mode. Logging message instead of sending")
-		log.Printf("==================================================")
-		log.Printf("%s", msg)
-		log.Printf("==================================================")
-	} else {
-		// NOTES: per docs, to make a recipient BCC'd, include them in the to
-		// param in the SendMail call, but omit them in the message above.
-		log.Printf("Sending receipt email to %s", strings.Join(to, ","))
-		err := smtp.SendMail(fmt.Sprintf("%s:%d", smtpCfg.Host, smtpCfg.Port), nil, "no-reply@virginia.edu", to, msg)
-		if err != nil {
-			log.Printf("ERROR: Unable to send receipt email: %s", err.Error())
-			return
-		}
+	req := EmailRequest{Subject: "UVA Archives Transfer Receipt", To: []string{user.Email}, Body: body}
+	if user.ID == accession.User.ID {
+		req.BCC = GetAdminEmails(db)
 	}
+	if receiptPDF != nil {
+		req.Attachments = append(req.Attachments, EmailAttachment{Name: ReceiptFilename(accession),
+			ContentType: "application/pdf", Data: receiptPDF})
+	}
+	return smtpCfg.SendEmail(req)
 }
 
 // SendVerifyEmail will send a verify email to a new user
 func (user *User) SendVerifyEmail(baseURL string, smtpCfg SMTPConfig) {
-	log.Printf("Rendering verification email body")
-	var renderedEmail bytes.Buffer
 	var data struct {
 		Name string
 		URL  string
 	}
 	data.Name = user.FullName()
 	data.URL = fmt.Sprintf("https://%s/verify/%s", baseURL, *user.VerifyToken)
-	tpl := template.Must(template.ParseFiles("templates/verify_email.html"))
-	err := tpl.Execute(&renderedEmail, data)
+	body, err := RenderEmailTemplate("verify_email.html", data)
 	if err != nil {
 		log.Printf("ERROR: Unable to render verify email: %s", err.Error())
 		return
 	}
 
-	log.Printf("Generate SMTP message")
-	mime := "MIME-version: 1.0;\nContent-Type: text/html; charset=\"UTF-8\";\n\n"
-	subject := "Subject: UVA Archives Transfer Verification\n"
-	to := fmt.Sprintf("To: %s\n", user.Email)
-	msg := []byte(subject + to + mime + renderedEmail.String())
-
-	if smtpCfg.DevMode {
-		log.Printf("Email is in dev mode. Logging message instead of sending")
-		log.Printf("==================================================")
-		log.Printf("%s", msg)
-		log.Printf("==================================================")
-	} else {
-		log.Printf("Send verify email to %s", user.Email)
-		to := []string{user.Email}
-		err := smtp.SendMail(fmt.Sprintf("%s:%d", smtpCfg.Host, smtpCfg.Port), nil, "no-reply@virginia.edu", to, msg)
-		if err != nil {
-			log.Printf("ERROR: Unable to send verify email: %s", err.Error())
-			return
-		}
-	}
+	smtpCfg.SendEmail(EmailRequest{Subject: "UVA Archives Transfer Verification",
+		To: []string{user.Email}, Body: body})
 }
 
 // FindByEmail finds a user by email
@@ -186,6 +151,13 @@ func (user *User) FindByAPIToken(db *dbx.DB, token string) error {
 	return q.One(user)
 }
 
+// FindBySubmitToken finds a user by the token of their submitter session
+func (user *User) FindBySubmitToken(db *dbx.DB, token string) error {
+	q := db.NewQuery(`select * from users where submit_token={:token} limit 1`)
+	q.Bind(dbx.Params{"token": token})
+	return q.One(user)
+}
+
 // Create creates a user record in the DB based in data in the struct
 func (user *User) Create(db *dbx.DB) error {
 	user.CreatedAt = time.Now()
@@ -194,9 +166,11 @@ func (user *User) Create(db *dbx.DB) error {
 	return db.Model(user).Insert()
 }
 
-// Verify will mark this user account as verified
+// Verify will mark this user account as verified. The verify token is cleared so the
+// verification link can not be used to sign in again
 func (user *User) Verify(db *dbx.DB) error {
 	user.Verified = true
+	user.VerifyToken = nil
 	user.UpdatedAt = time.Now()
 	return db.Model(user).Update()
 }
@@ -230,6 +204,10 @@ func (svc *ServiceContext) CreateUser(c *gin.Context) {
 		c.String(http.StatusBadRequest, "All fields are required")
 		return
 	}
+	if user.UnitID != nil && GetUnitName(svc.DB, user.UnitID) == "" {
+		c.String(http.StatusBadRequest, "Unit %d is not valid", *user.UnitID)
+		return
+	}
 
 	token := xid.New().String()
 	user.VerifyToken = &token
@@ -256,33 +234,31 @@ func (svc *ServiceContext) VerifyUser(c *gin.Context) {
 		c.String(http.StatusNotFound, err.Error())
 		return
 	}
-	if user.Verified == false {
-		log.Printf("Marking %s as verified", user.Email)
-		err = user.Verify(svc.DB)
-		if err != nil {
-			log.Printf("Unable to verify %s: %s", user.Email, err.Error())
-			c.String(http.StatusInternalServerError, err.Error())
-			return
-		}
-	} else {
-		log.Printf("User %s already verified; nothing to do.", user.Email)
+	log.Printf("Marking %s as verified", user.Email)
+	err = user.Verify(svc.DB)
+	if err != nil {
+		log.Printf("Unable to verify %s: %s", user.Email, err.Error())
+		c.String(http.StatusInternalServerError, err.Error())
+		return
 	}
+	svc.startSubmitterSession(c, &user)
 	c.JSON(http.StatusOK, user)
 }
 
-// ResendVerification accepts a token, finds the associated user, and resends the validation email
+// ResendVerification accepts an email, finds the associated user, and resends the validation
+// email. The verify token is only ever sent to the user's email address
 func (svc *ServiceContext) ResendVerification(c *gin.Context) {
-	var data struct{ Token string }
+	var data struct{ Email string }
 	c.Bind(&data)
-	log.Printf("Resend verification for [%s]", data.Token)
+	log.Printf("Resend verification for [%s]", data.Email)
 	user := User{}
-	err := user.FindByToken(svc.DB, data.Token)
-	if err != nil {
-		log.Printf("ERROR: No user found for %s: %s", data.Token, err.Error())
-		c.String(http.StatusNotFound, err.Error())
+	err := user.FindByEmail(svc.DB, data.Email)
+	if err != nil || user.VerifyToken == nil {
+		log.Printf("ERROR: No unverified user found for %s", data.Email)
+		c.String(http.StatusNotFound, "%s not found", data.Email)
 		return
 	}
-	log.Printf("[%s] found; resending verification email", data.Token)
+	log.Printf("[%s] found; resending verification email", data.Email)
 	user.SendVerifyEmail(svc.Hostname, svc.SMTP)
 	c.String(http.StatusOK, "email resent")
 }
@@ -0,0 +1,267 @@
+package main
+
+import (
+	"fmt"
+	"log"
+	"os"
+	"strconv"
+	"strings"
+	"time"
+
+	dbx "github.com/go-ozzo/ozzo-dbx"
+	"github.com/rs/xid"
+)
+
+// FieldError describes a problem with a single field of a submission. Field is
+// the JSON path of the problem field so the form can highlight it
+type FieldError struct {
+	Field   string `json:"field"`
+	Message string `json:"message"`
+}
+
+// ValidationErrors is the response returned when a submission fails validation
+type ValidationErrors struct {
+	Errors []FieldError `json:"errors"`
+}
+
+// Add appends a field error to the list
+func (ve *ValidationErrors) Add(field string, msg string, args ...interface{}) {
+	ve.Errors = append(ve.Errors, FieldError{Field: field, Message: fmt.Sprintf(msg, args...)})
+}
+
+// HasErrors returns true if any errors have been recorded
+func (ve *ValidationErrors) HasErrors() bool {
+	return len(ve.Errors) > 0
+}
+
+// getVocabIDs returns a set of all IDs present in a controlled vocabulary table.
+// An optional where clause can be used to restrict the set.
+func getVocabIDs(db *dbx.DB, table string, where string) (map[int]bool, error) {
+	qs := fmt.Sprintf("select id from %s", table)
+	if where != "" {
+		qs += " where " + where
+	}
+	out := make(map[int]bool)
+	rows, err := db.NewQuery(qs).Rows()
+	if err != nil {
+		return nil, err
+	}
+	defer rows.Close()
+	for rows.Next() {
+		var id int
+		rows.Scan(&id)
+		out[id] = true
+	}
+	return out, nil
+}
+
+// validateVocabList checks that every ID in a list of string IDs is present in the valid set
+func validateVocabList(ve *ValidationErrors, field string, ids []string, valid map[int]bool, label string) {
+	for idx, idStr := range ids {
+		id, err := strconv.Atoi(idStr)
+		if err != nil || valid[id] == false {
+			ve.Add(fmt.Sprintf("%s[%d]", field, idx), "%s '%s' is not valid", label, idStr)
+		}
+	}
+}
+
+// Validate checks an incoming accession against the controlled vocabularies, the fields
+// required by each type of transfer and the pending upload area. All problems found
+// are returned; an empty list means the accession is acceptable. The submitter is the
+// user of the submitter session, if any, and is who linked accessions must belong to.
+func (a *Accession) Validate(db *dbx.DB, uploadDir string, submitter *User) (*ValidationErrors, error) {
+	ve := ValidationErrors{Errors: make([]FieldError, 0)}
+
+	// general info
+	if a.User.ID <= 0 {
+		ve.Add("user.id", "Submitter account is missing")
+	}
+	if a.User.IsValid() == false {
+		ve.Add("user", "All submitter contact fields are required")
+	}
+	a.validateOwner(&ve)
+	if strings.TrimSpace(a.Summary) == "" {
+		ve.Add("summary", "A summary of the records is required")
+	}
+	switch a.Type {
+	case "new", "add", "unsure":
+	default:
+		ve.Add("accessionType", "Accession type '%s' is not valid", a.Type)
+	}
+	if len(a.Genres) == 0 {
+		ve.Add("genres", "At least one genre is required")
+	}
+	genres, err := getVocabIDs(db, "genres", "")
+	if err != nil {
+		return nil, err
+	}
+	validateVocabList(&ve, "genres", a.Genres, genres, "Genre")
+
+	// transfer agreement; the submitter must accept the version currently in effect
+	agreement, err := GetCurrentAgreement(db)
+	if err != nil {
+		return nil, err
+	}
+	if agreement != nil {
+		if a.AgreementAccepted == false {
+			ve.Add("agreementAccepted", "The transfer agreement must be accepted")
+		} else if a.AgreementID != agreement.ID {
+			ve.Add("agreementID", "The transfer agreement has been updated to version %s; please review and accept it", agreement.Version)
+		}
+	}
+
+	// the submitter's unit is saved to their account, so it is checked even when the
+	// records are transferred on behalf of another unit
+	if a.User.UnitID != nil && GetUnitName(db, a.User.UnitID) == "" {
+		ve.Add("user.unitID", "Unit '%d' is not valid", *a.User.UnitID)
+	}
+
+	// the unit the records are transferred on behalf of; when it is not given the
+	// submitter's unit, checked above, is used
+	if a.UnitID != nil && GetUnitName(db, a.UnitID) == "" {
+		ve.Add("unitID", "Unit '%d' is not valid", *a.UnitID)
+	}
+
+	// retention schedule series
+	if a.RetentionScheduleID != nil {
+		if _, err := GetRetentionSchedule(db, *a.RetentionScheduleID); err != nil {
+			ve.Add("retentionScheduleID", "Retention schedule '%d' is not valid", *a.RetentionScheduleID)
+		}
+	}
+
+	// proposed access restriction
+	if a.Restriction != nil {
+		categories, err := getVocabIDs(db, "restriction_categories", "")
+		if err != nil {
+			return nil, err
+		}
+		if categories[a.Restriction.CategoryID] == false {
+			ve.Add("restriction.categoryID", "Restriction category '%d' is not valid", a.Restriction.CategoryID)
+		}
+		if strings.TrimSpace(a.Restriction.Justification) == "" {
+			ve.Add("restriction.justification", "A justification for the restriction is required")
+		}
+		if a.Restriction.RestrictedUntil != nil && a.Restriction.RestrictedUntil.Before(time.Now()) {
+			ve.Add("restriction.restrictedUntil", "The restricted until date must be in the future")
+		}
+	}
+
+	// links to earlier accessions from the same submitter
+	if len(a.Links) > 0 && submitter == nil {
+		ve.Add("links", "Sign in to link earlier transfers")
+	}
+	linked := make(map[string]int)
+	for idx, link := range a.Links {
+		field := fmt.Sprintf("links[%d]", idx)
+		if IsLinkType(link.Type) == false {
+			ve.Add(field+".type", "Link type '%s' is not valid", link.Type)
+		}
+		key := fmt.Sprintf("%d/%s", link.RelatedID, link.Type)
+		if prior, ok := linked[key]; ok {
+			ve.Add(field, "Accession %d is already linked as %s in link %d", link.RelatedID, link.Type, prior+1)
+			continue
+		}
+		linked[key] = idx
+		if submitter == nil {
+			continue
+		}
+		var related struct{ ID int }
+		q := db.NewQuery("select id from accessions where id={:id} and (user_id={:user} or owner_id={:user})")
+		q.Bind(dbx.Params{"id": link.RelatedID, "user": submitter.ID})
+		if q.One(&related) != nil {
+			ve.Add(field+".relatedID", "Related accession %d is not one of your accessions", link.RelatedID)
+		}
+	}
+
+	// upload identifier
+	if _, err := xid.FromString(a.Identifier); err != nil {
+		ve.Add("identifier", "Submission identifier '%s' is not valid", a.Identifier)
+	}
+
+	if a.DigitalTransfer == false && a.PhysicalTransfer == false {
+		ve.Add("digitalTransfer", "A digital or physical transfer is required")
+	}
+
+	if a.DigitalTransfer {
+		digitalTypes, err := getVocabIDs(db, "record_types", "digital=1")
+		if err != nil {
+			return nil, err
+		}
+		if strings.TrimSpace(a.Digital.Description) == "" {
+			ve.Add("digital.description", "A technical description of the digital transfer is required")
+		}
+		validateVocabList(&ve, "digital.selectedTypes", a.Digital.RecordTypes, digitalTypes, "Record type")
+		if len(a.Digital.Files) == 0 {
+			ve.Add("digital.uploadedFiles", "At least one digital file must be uploaded")
+		}
+		pendingDir := fmt.Sprintf("%s/%s/%s", uploadDir, "pending", a.Identifier)
+		for idx, fn := range a.Digital.Files {
+			tgt := fmt.Sprintf("%s/%s", pendingDir, fn)
+			if strings.Contains(fn, "/") || strings.Contains(fn, "..") {
+				ve.Add(fmt.Sprintf("digital.uploadedFiles[%d]", idx), "File name '%s' is not valid", fn)
+			} else if _, err := os.Stat(tgt); err != nil {
+				ve.Add(fmt.Sprintf("digital.uploadedFiles[%d]", idx), "File '%s' was not found for this submission", fn)
+			}
+		}
+	}
+
+	if a.PhysicalTransfer {
+		physicalTypes, err := getVocabIDs(db, "record_types", "digital=0")
+		if err != nil {
+			return nil, err
+		}
+		methods, err := getVocabIDs(db, "transfer_methods", "")
+		if err != nil {
+			return nil, err
+		}
+		carriers, err := getVocabIDs(db, "media_carriers", "")
+		if err != nil {
+			return nil, err
+		}
+		if strings.TrimSpace(a.Physical.BoxInfo) == "" {
+			ve.Add("physical.boxInfo", "Details about the quantity and size of the boxes are required")
+		}
+		if methods[a.Physical.TransferMethodID] == false {
+			ve.Add("physical.transferMethod", "A valid transfer method is required")
+		}
+		validateVocabList(&ve, "physical.selectedTypes", a.Physical.RecordTypes, physicalTypes, "Record type")
+		if len(a.Physical.Inventory) == 0 {
+			ve.Add("physical.inventory", "An inventory of the records is required")
+		}
+		for idx, item := range a.Physical.Inventory {
+			if strings.TrimSpace(item.BoxNumber) == "" {
+				ve.Add(fmt.Sprintf("physical.inventory[%d].boxNum", idx), "Box number is required")
+			}
+		}
+		if a.Physical.HasDigital {
+			if strings.TrimSpace(a.Physical.TechInfo) == "" {
+				ve.Add("physical.techInfo", "A technical description of the digital records is required")
+			}
+			validateVocabList(&ve, "physical.mediaCarriers", a.Physical.MediaCarriers, carriers, "Media carrier")
+		}
+	}
+
+	if ve.HasErrors() {
+		log.Printf("Accession %s failed validation: %+v", a.Identifier, ve.Errors)
+	}
+	return &ve, nil
+}
+
+// ResolveDefaults fills in the details of a validated accession that are derived from
+// the submission rather than sent with it: the unit defaults to the submitter's unit,
+// and the unit name and retention schedule are looked up from their IDs
+func (a *Accession) ResolveDefaults(db *dbx.DB) error {
+	if a.UnitID == nil {
+		a.UnitID = a.User.UnitID
+	}
+	a.Unit = GetUnitName(db, a.UnitID)
+	a.RetentionSchedule = nil
+	if a.RetentionScheduleID != nil {
+		schedule, err := GetRetentionSchedule(db, *a.RetentionScheduleID)
+		if err != nil {
+			return err
+		}
+		a.RetentionSchedule = schedule
+	}
+	return nil
+}
@@ -82,6 +82,18 @@ func (svc *ServiceContext) GetMediaCarriers(c *gin.Context) {
 	c.JSON(http.StatusOK, carriers)
 }
 
+// GetRestrictionCategories returns a list of access restriction categories as JSON
+func (svc *ServiceContext) GetRestrictionCategories(c *gin.Context) {
+	q := svc.DB.NewQuery("SELECT id, name, description FROM restriction_categories order by name asc")
+	var categories []ControlledVocab
+	err := q.All(&categories)
+	if err != nil {
+		c.String(http.StatusInternalServerError, "Unable to retrive restriction categories: %s", err.Error())
+		return
+	}
+	c.JSON(http.StatusOK, categories)
+}
+
 // GetTypes returns a list of object types as JSON
 func (svc *ServiceContext) GetTypes(c *gin.Context) {
 	q := svc.DB.NewQuery("SELECT id, name, description, digital FROM record_types order by name asc")
@@ -8,6 +8,8 @@ import Admin from './views/Admin.vue'
 import Accession from './views/Accession.vue'
 import Forbidden from './views/Forbidden.vue'
 import Verify from './views/Verify.vue'
+import Messages from './views/Messages.vue'
+import Transfers from './views/Transfers.vue'
 import store from './store'
 
 Vue.use(Router)
@@ -53,6 +55,16 @@ const router = new Router({
       name: 'verify',
       component: Verify
     },
+    {
+      path: '/messages/:token',
+      name: 'messages',
+      component: Messages
+    },
+    {
+      path: '/transfers',
+      name: 'transfers',
+      component: Transfers
+    },
     {
       path: '/admin',
       name: 'admin',
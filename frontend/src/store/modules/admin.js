@@ -1,5 +1,23 @@
 import axios from 'axios'
 
+// filterParams returns the query params for the current accession list filters and sort
+function filterParams(state) {
+   let params = []
+   if (state.queryStr.length > 0) {
+      params.push("q=" + encodeURIComponent(state.queryStr))
+   }
+   if (state.tgtGenre.length > 0 ) {
+      params.push("g=" + encodeURIComponent(state.tgtGenre))
+   }
+   Object.keys(state.facetSel).forEach( name => {
+      state.facetSel[name].forEach( val => {
+         params.push("f."+name+"="+encodeURIComponent(val))
+      })
+   })
+   params.push("sort="+state.sortBy+":"+state.sortDir)
+   return params
+}
+
 const admin = {
    namespaced: true,
    state: {
@@ -8,12 +26,19 @@ const admin = {
       accessions: [],
       totalAccessions: 0,
       filteredTotal: 0,
-      page: 0,
+      page: 1,
       pageSize: 0,
+      cursors: [""],
+      nextCursor: "",
       accessionDetail: null,
       notes: [],
+      notesCursor: "",
       queryStr: "",
       tgtGenre: "",
+      facets: [],
+      facetSel: {},
+      savedSearches: [],
+      savedSearch: "",
       addingNote: false,
       working: false
    },
@@ -30,6 +55,9 @@ const admin = {
    },
    mutations: {
       updateSortOrder(state, column) {
+         state.savedSearch = ""
+         state.page = 1
+         state.cursors = [""]
          if (state.sortBy != column) {
             state.sortBy = column
             state.sortDir = "desc"
@@ -49,21 +77,55 @@ const admin = {
       },
       setGenreFilter(state, val) {
          state.tgtGenre = val
+         state.savedSearch = ""
+         state.page = 1
+         state.cursors = [""]
+      },
+      toggleFacetValue(state, {name, value}) {
+         let vals = state.facetSel[name] || []
+         if (vals.includes(value)) {
+            vals = vals.filter( v => v != value)
+         } else {
+            vals = vals.concat([value])
+         }
+         state.facetSel = Object.assign({}, state.facetSel, {[name]: vals})
+         state.savedSearch = ""
+         state.page = 1
+         state.cursors = [""]
+      },
+      setSavedSearches(state, searches) {
+         state.savedSearches = searches
+      },
+      addSavedSearch(state, search) {
+         state.savedSearches.push(search)
+         state.savedSearch = ""+search.id
+      },
+      setSavedSearch(state, id) {
+         state.savedSearch = id
+         state.page = 1
+         state.cursors = [""]
       },
       resetAccessionsSearch(state) {
+         state.savedSearch = ""
          state.tgtGenre = ""
+         state.facetSel = {}
          state.queryStr = ""
          state.page = 1
+         state.cursors = [""]
+         state.nextCursor = ""
          state.filteredTotal = 0
          state.total = 0
          state.accessions.length = 0
       },
       setAccessionsPage(state, resp) {
-         state.totalAccessions = resp.total
-         state.filteredTotal = resp.filteredTotal
-         state.page = resp.page
+         state.totalAccessions = resp.total || 0
+         state.filteredTotal = resp.filteredTotal || 0
          state.pageSize = resp.pageSize
+         state.nextCursor = resp.nextCursor
          state.accessions = resp.accessions
+         if (resp.facets) {
+            state.facets = resp.facets
+         }
       },
       clearAccessionDetail(state) {
          state.accessionDetail = null
@@ -72,24 +134,31 @@ const admin = {
          state.accessionDetail = data
       },
       setNotes(state, data) {
-         state.notes = data
+         state.notes = data.notes
+         state.notesCursor = data.nextCursor
+      },
+      addNotes(state, data) {
+         state.notes = state.notes.concat(data.notes)
+         state.notesCursor = data.nextCursor
       },
       addNote(state, note) {
          state.notes.push(note)
       },
       updateSearchQuery(state, val) {
          state.queryStr = val
+         state.page = 1
+         state.cursors = [""]
       },
       gotoFirstPage(state) {
          state.page = 1
-      },
-      gotoLastPage(state) {
-         state.page = Math.floor(state.totalAccessions / state.pageSize) + 1
+         state.cursors = [""]
       },
       nextPage(state) {
+         state.cursors.push(state.nextCursor)
          state.page++
       },
       prevPage(state) {
+         state.cursors.pop()
          state.page--
       },
    },
@@ -99,27 +168,27 @@ const admin = {
          ctx.dispatch("getAccessionsPage")
       },
       prevPage(ctx) {
+         if (ctx.state.page == 1) return
          ctx.commit('prevPage')
          ctx.dispatch("getAccessionsPage")
       },
       nextPage(ctx) {
+         if (ctx.state.nextCursor == "") return
          ctx.commit('nextPage')
          ctx.dispatch("getAccessionsPage")
       },
-      lastPage(ctx) {
-         ctx.commit('gotoLastPage')
-         ctx.dispatch("getAccessionsPage")
-      },
       getAccessionsPage(ctx) {
          ctx.commit("setLoading", true, { root: true })
-         let url = "/api/admin/accessions?page=" + ctx.state.page
-         if (ctx.state.queryStr.length > 0) {
-            url = url + "&q=" + ctx.state.queryStr
+         let url = "/api/admin/accessions?total=true"
+         let cursor = ctx.state.cursors[ctx.state.page-1]
+         if (cursor) {
+            url = url + "&cursor=" + cursor
          }
-         if (ctx.state.tgtGenre.length > 0 ) {
-            url = url +"&g="+ctx.state.tgtGenre
+         if (ctx.state.savedSearch != "") {
+            url = url + "&search=" + ctx.state.savedSearch
+         } else {
+            url = url + "&" + filterParams(ctx.state).join("&")
          }
-         url = url + "&sort="+ctx.state.sortBy+":"+ctx.state.sortDir
          axios.get(url, { withCredentials: true }).then((response) => {
             ctx.commit('setAccessionsPage', response.data)
             ctx.commit("setLoading", false, { root: true })
@@ -128,6 +197,21 @@ const admin = {
             ctx.commit("setLoading", false, { root: true })
          })
       },
+      getSavedSearches(ctx) {
+         axios.get("/api/admin/searches", { withCredentials: true }).then((response) => {
+            ctx.commit('setSavedSearches', response.data)
+         }).catch(() => {
+            ctx.commit('setError', "Internal Error: Unable to get saved searches", { root: true })
+         })
+      },
+      saveSearch(ctx, data) {
+         data.query = filterParams(ctx.state).join("&")
+         axios.post("/api/admin/searches", data, { withCredentials: true }).then((response) => {
+            ctx.commit('addSavedSearch', response.data)
+         }).catch((err) => {
+            ctx.commit('setError', err.response.data, { root: true })
+         })
+      },
       getAccessionDetail(ctx, id) {
          ctx.commit("setLoading", true, { root: true })
          ctx.commit('clearAccessionDetail')
@@ -147,6 +231,14 @@ const admin = {
             ctx.commit('setError', "Internal Error: Unable to get accession notes", { root: true })
          })
       },
+      getMoreNotes(ctx) {
+         let url = "/api/admin/accessions/" + ctx.state.accessionDetail.id+"/notes?cursor="+ctx.state.notesCursor
+         axios.get(url, { withCredentials: true }).then((response) => {
+            ctx.commit('addNotes', response.data)
+         }).catch(() => {
+            ctx.commit('setError', "Internal Error: Unable to get accession notes", { root: true })
+         })
+      },
       addNote(ctx, data) {
          let id = ctx.state.accessionDetail.id
          data.userID = ctx.rootState.user.id
@@ -14,13 +14,37 @@ const transfer = {
       physicalRecordTypes: [],
       mediaCarrierChoices: [],
       transferMethods: [],
+      userAccessions: [],
+      restrictionCategories: [],
+      retentionSchedules: [],
+      units: [],
+      fieldErrors: {},
+      submitResult: null,
       accession: {
          identifier: null,
          summary: '',
          activities: '',
          creator: '',
          genres: [],
-         accessionType: 'new'
+         accessionType: 'new',
+         unitID: null,
+         retentionScheduleID: null,
+         links: []
+      },
+      owner: {
+         delegated: false,
+         firstName: '',
+         lastName: '',
+         title: '',
+         affiliation: '',
+         email: '',
+         phone: ''
+      },
+      restriction: {
+         restricted: false,
+         categoryID: '',
+         restrictedUntil: '',
+         justification: ''
       },
       digital: {
          description: '',
@@ -48,6 +72,17 @@ const transfer = {
       submissionID: state => {
          return state.accession.identifier
       },
+      // fieldError returns the validation messages for a form field, including those
+      // for any of its items such as physical.inventory[0].boxNum
+      fieldError: (state) => (field) => {
+         let msgs = []
+         Object.keys(state.fieldErrors).forEach( key => {
+            if (key == field || key.startsWith(field+"[") || key.startsWith(field+".")) {
+               msgs.push(state.fieldErrors[key])
+            }
+         })
+         return msgs.join("; ")
+      },
       inventoryCount: state => {
          return state.physical.inventory.length
       },
@@ -72,6 +107,19 @@ const transfer = {
          state.physical.mediaCount = ''
          state.physical.hasSoftware = '0'
       },
+      setFieldErrors(state, errors) {
+         let out = {}
+         errors.forEach( e => {
+            out[e.field] = e.message
+         })
+         state.fieldErrors = out
+      },
+      addLink(state, link) {
+         state.accession.links.push(link)
+      },
+      removeLink(state, idx) {
+         state.accession.links.splice(idx, 1)
+      },
       clearInventory(state) {
          state.physical.inventory = []
       },
@@ -88,8 +136,15 @@ const transfer = {
       toggleInventory(state) {
          state.showInventory = !state.showInventory
       },
+      setSubmitResult (state, result) {
+         state.submitResult = result
+      },
       clearSubmissionData(state) {
-         state.accession = { identifier: '', summary: '', activities: '', creator: '', genres: [],accessionType: 'new' }
+         state.accession = { identifier: '', summary: '', activities: '', creator: '', genres: [],accessionType: 'new',
+            unitID: null, retentionScheduleID: null, links: [] }
+         state.owner = { delegated: false, firstName: '', lastName: '', title: '', affiliation: '',
+            email: '', phone: '' }
+         state.restriction = { restricted: false, categoryID: '', restrictedUntil: '', justification: '' }
          state.digital = { description: '', dateRange: '', selectedTypes: [], 
             uploadedFiles: [], totalSizeBytes: 0 }
          state.physical = { dateRange: '', boxInfo: '', selectedTypes: [], transferMethod: 0, hasDigital: '1',
@@ -105,6 +160,18 @@ const transfer = {
       setTransferMethods (state, methods) {
          state.transferMethods = methods
       },
+      setRestrictionCategories (state, categories) {
+         state.restrictionCategories = categories
+      },
+      setRetentionSchedules (state, schedules) {
+         state.retentionSchedules = schedules
+      },
+      setUnits (state, units) {
+         state.units = units
+      },
+      setUserAccessions (state, accessions) {
+         state.userAccessions = accessions
+      },
       setSubmissionID (state, identifier) {
          state.accession.identifier = identifier
       },
@@ -172,6 +239,39 @@ const transfer = {
             ctx.commit('setError', "Internal Error: Unable to get record types", {root: true})
          })
       },
+      getRestrictionCategories( ctx ) {
+         ctx.commit('setRestrictionCategories', [])
+         axios.get("/api/restriction-categories").then((response)  =>  {
+            ctx.commit('setRestrictionCategories', response.data )
+         }).catch(() => {
+            ctx.commit('setError', "Internal Error: Unable to get restriction categories", {root: true})
+         })
+      },
+      getRetentionSchedules( ctx ) {
+         ctx.commit('setRetentionSchedules', [])
+         axios.get("/api/retention-schedules").then((response)  =>  {
+            ctx.commit('setRetentionSchedules', response.data )
+         }).catch(() => {
+            ctx.commit('setError', "Internal Error: Unable to get retention schedules", {root: true})
+         })
+      },
+      getUnits( ctx ) {
+         ctx.commit('setUnits', [])
+         axios.get("/api/units").then((response)  =>  {
+            ctx.commit('setUnits', response.data )
+         }).catch(() => {
+            ctx.commit('setError', "Internal Error: Unable to get units", {root: true})
+         })
+      },
+      getUserAccessions( ctx ) {
+         ctx.commit('setUserAccessions', [])
+         axios.get("/api/user/accessions").then((response)  =>  {
+            ctx.commit('setUserAccessions', response.data )
+         }).catch(() => {
+            // submitters that have not signed in have no earlier accessions to link to
+            ctx.commit('setUserAccessions', [])
+         })
+      },
       getSubmissionID( ctx ) {
          ctx.commit('setSubmissionID', "") 
          axios.get("/api/identifier").then((response)  =>  {